@@ -88,6 +88,9 @@ func (s *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(s.TokenLiteral() + " ")
 	out.WriteString(s.Name.String())
+	if s.Name.Type != nil {
+		out.WriteString(": " + s.Name.Type.String())
+	}
 	out.WriteString(" = ")
 	if s.Value != nil {
 		out.WriteString(s.Value.String())
@@ -113,9 +116,56 @@ func (s *ReturnStatement) String() string {
 	return out.String()
 }
 
+type ThrowStatement struct {
+	Token gtoken.Token
+	Value Expression
+}
+
+func (s *ThrowStatement) statementNode()       {}
+func (s *ThrowStatement) TokenLiteral() string { return s.Token.Literal }
+func (s *ThrowStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(s.TokenLiteral() + " ")
+	if s.Value != nil {
+		out.WriteString(s.Value.String())
+	}
+	out.WriteString(";")
+	return out.String()
+}
+
+type TryStatement struct {
+	Token   gtoken.Token
+	Block   *BlockStatement
+	Param   *Identifier // optional name the caught error is bound to
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (s *TryStatement) statementNode()       {}
+func (s *TryStatement) TokenLiteral() string { return s.Token.Literal }
+func (s *TryStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("try ")
+	out.WriteString(s.Block.String())
+	if s.Catch != nil {
+		out.WriteString("catch")
+		if s.Param != nil {
+			out.WriteString("(" + s.Param.String() + ")")
+		}
+		out.WriteString(" ")
+		out.WriteString(s.Catch.String())
+	}
+	if s.Finally != nil {
+		out.WriteString("finally ")
+		out.WriteString(s.Finally.String())
+	}
+	return out.String()
+}
+
 type ObjectBlockStatement struct {
 	Token      gtoken.Token
 	Statements []Statement
+	Rbrace     gtoken.Token // like BlockStatement.Rbrace
 }
 
 func (s *ObjectBlockStatement) statementNode()       {}
@@ -131,6 +181,8 @@ func (s *ObjectBlockStatement) String() string {
 type BlockStatement struct {
 	Token      gtoken.Token
 	Statements []Statement
+	// Rbrace is the closing brace, or the EOF token if it is missing.
+	Rbrace gtoken.Token
 }
 
 func (s *BlockStatement) statementNode()       {}
@@ -156,3 +208,13 @@ func (s *ExpressionStatement) String() string {
 	}
 	return ""
 }
+
+// BadStmt is a placeholder for a statement with syntax errors, from the
+// token starting it to the one the parser stopped at.
+type BadStmt struct {
+	From, To gtoken.Token
+}
+
+func (s *BadStmt) statementNode()       {}
+func (s *BadStmt) TokenLiteral() string { return s.From.Literal }
+func (s *BadStmt) String() string       { return "<bad statement>" }
@@ -3,6 +3,7 @@ package ast
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/GhostNet-Dev/gscript/gtoken"
@@ -35,6 +36,9 @@ func (i *IdentifierType) String() string       { return i.Value }
 type Identifier struct {
 	Token gtoken.Token
 	Value string
+	// Type is the annotation of a let name or parameter, as in x: int,
+	// or nil.
+	Type *IdentifierType
 }
 
 func (i *Identifier) expressionNode()      {}
@@ -68,6 +72,16 @@ func (s *Null) expressionNode()      {}
 func (s *Null) TokenLiteral() string { return s.Token.Literal }
 func (s *Null) String() string       { return s.Token.Literal }
 
+// BadExpr is a placeholder for an expression with syntax errors, from the
+// token starting it to the one the parser stopped at.
+type BadExpr struct {
+	From, To gtoken.Token
+}
+
+func (e *BadExpr) expressionNode()      {}
+func (e *BadExpr) TokenLiteral() string { return e.From.Literal }
+func (e *BadExpr) String() string       { return "<bad expression>" }
+
 type Boolean struct {
 	Token gtoken.Token
 	Value bool
@@ -78,9 +92,10 @@ func (s *Boolean) TokenLiteral() string { return s.Token.Literal }
 func (s *Boolean) String() string       { return s.Token.Literal }
 
 type IndexExpression struct {
-	Token gtoken.Token
-	Left  Expression
-	Index Expression
+	Token    gtoken.Token
+	Left     Expression
+	Index    Expression
+	Rbracket gtoken.Token
 }
 
 func (s *IndexExpression) expressionNode()      {}
@@ -95,9 +110,23 @@ func (s *IndexExpression) String() string {
 	return out.String()
 }
 
+// PropagateExpression is a postfix `?`: it returns Value from the enclosing
+// function when it is an error value and evaluates to Value otherwise.
+type PropagateExpression struct {
+	Token gtoken.Token
+	Value Expression
+}
+
+func (s *PropagateExpression) expressionNode()      {}
+func (s *PropagateExpression) TokenLiteral() string { return s.Token.Literal }
+func (s *PropagateExpression) String() string {
+	return "(" + s.Value.String() + "?)"
+}
+
 type ArrayLiteral struct {
 	Token    gtoken.Token
 	Elements []Expression
+	Rbracket gtoken.Token
 }
 
 func (s *ArrayLiteral) expressionNode()      {}
@@ -114,9 +143,31 @@ func (s *ArrayLiteral) String() string {
 	return out.String()
 }
 
+// TupleLiteral is a parenthesized list of two or more expressions, or of
+// one followed by a comma: (1, 2) or (1,).
+type TupleLiteral struct {
+	Token    gtoken.Token // the ( token
+	Elements []Expression
+	Rparen   gtoken.Token
+}
+
+func (s *TupleLiteral) expressionNode()      {}
+func (s *TupleLiteral) TokenLiteral() string { return s.Token.Literal }
+func (s *TupleLiteral) String() string {
+	elements := []string{}
+	for _, el := range s.Elements {
+		elements = append(elements, el.String())
+	}
+	if len(elements) == 1 {
+		return "(" + elements[0] + ",)"
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
 type HashLiteral struct {
-	Token gtoken.Token
-	Pairs map[Expression]Expression
+	Token  gtoken.Token
+	Pairs  map[Expression]Expression
+	Rbrace gtoken.Token
 }
 
 func (s *HashLiteral) expressionNode()      {}
@@ -124,8 +175,8 @@ func (s *HashLiteral) TokenLiteral() string { return s.Token.Literal }
 func (s *HashLiteral) String() string {
 	var out bytes.Buffer
 	elements := []string{}
-	for key, value := range s.Pairs {
-		elements = append(elements, key.String()+":"+value.String())
+	for _, key := range s.Keys() {
+		elements = append(elements, key.String()+":"+s.Pairs[key].String())
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(elements, ", "))
@@ -133,6 +184,25 @@ func (s *HashLiteral) String() string {
 	return out.String()
 }
 
+// Keys returns the keys of the literal in source order, or in the order
+// of their text if they have no positions. Both engines evaluate and
+// insert the pairs in this order.
+func (s *HashLiteral) Keys() []Expression {
+	keys := make([]Expression, 0, len(s.Pairs))
+	for k := range s.Pairs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := Span(keys[i])
+		b, _ := Span(keys[j])
+		if a != b {
+			return a.Before(b)
+		}
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
 type ForExpression struct {
 	Token       gtoken.Token
 	Init        Statement
@@ -146,14 +216,39 @@ func (s *ForExpression) TokenLiteral() string { return s.Token.Literal }
 func (s *ForExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("for")
-	out.WriteString(s.Init.String())
-	out.WriteString(s.Condition.String())
-	out.WriteString(s.Increment.String())
+	if s.Init != nil {
+		out.WriteString(s.Init.String())
+	}
+	if s.Condition != nil {
+		out.WriteString(s.Condition.String())
+	}
+	if s.Increment != nil {
+		out.WriteString(s.Increment.String())
+	}
 	out.WriteString(" ")
 	out.WriteString(s.Consequence.String())
 	return out.String()
 }
 
+// ForInExpression is a loop over the elements of a collection, binding
+// one or two variables to each: for (v in xs) { } or for (k, v in xs) { }.
+type ForInExpression struct {
+	Token       gtoken.Token // the for token
+	Variables   []*Identifier
+	Iterable    Expression
+	Consequence *BlockStatement
+}
+
+func (s *ForInExpression) expressionNode()      {}
+func (s *ForInExpression) TokenLiteral() string { return s.Token.Literal }
+func (s *ForInExpression) String() string {
+	names := []string{}
+	for _, v := range s.Variables {
+		names = append(names, v.String())
+	}
+	return "for (" + strings.Join(names, ", ") + " in " + s.Iterable.String() + ") " + s.Consequence.String()
+}
+
 type IfExpression struct {
 	Token       gtoken.Token
 	Condition   Expression
@@ -177,9 +272,10 @@ func (s *IfExpression) String() string {
 }
 
 type CallExpression struct {
-	Token     gtoken.Token
+	Token     gtoken.Token // (
 	Function  Expression
 	Arguments []Expression
+	Rparen    gtoken.Token
 }
 
 func (s *CallExpression) expressionNode()      {}
@@ -200,6 +296,7 @@ func (s *CallExpression) String() string {
 type FunctionLiteral struct {
 	Token      gtoken.Token
 	Parameters []*Identifier
+	ReturnType *IdentifierType // nil when not annotated
 	Body       *BlockStatement
 	Name       string
 }
@@ -210,7 +307,11 @@ func (s *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
 	for _, p := range s.Parameters {
-		params = append(params, p.String())
+		if p.Type != nil {
+			params = append(params, p.String()+": "+p.Type.String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 	out.WriteString(s.TokenLiteral())
 	if s.Name != "" {
@@ -219,6 +320,9 @@ func (s *FunctionLiteral) String() string {
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(")")
+	if s.ReturnType != nil {
+		out.WriteString(": " + s.ReturnType.String() + " ")
+	}
 	out.WriteString(s.Body.String())
 	return out.String()
 }
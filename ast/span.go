@@ -0,0 +1,131 @@
+package ast
+
+import (
+	"strings"
+
+	"github.com/GhostNet-Dev/gscript/gtoken"
+)
+
+// Pos is a position in the source: a line and a byte column, both counted
+// from 1. The zero Pos is no position.
+type Pos struct {
+	Line, Column int
+}
+
+// IsValid reports whether p is a position.
+func (p Pos) IsValid() bool { return p.Line > 0 }
+
+// Before reports whether p comes before q.
+func (p Pos) Before(q Pos) bool {
+	return p.Line < q.Line || p.Line == q.Line && p.Column < q.Column
+}
+
+// TokenSpan returns the position of the first byte of tok and the position
+// just past its last one.
+func TokenSpan(tok gtoken.Token) (start, end Pos) {
+	start = Pos{tok.Line, tok.Column}
+	text := tok.Literal
+	if tok.Type == gtoken.STRING {
+		text = `"` + text + `"`
+	}
+	if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+		return start, Pos{tok.Line + strings.Count(text, "\n"), len(text) - i}
+	}
+	return start, Pos{tok.Line, tok.Column + len(text)}
+}
+
+// Span returns the position of the first byte of node and the position
+// just past its last one, as far as the tokens in the tree record them:
+// the parentheses of a grouped expression and the semicolon ending a
+// statement are not part of it. Both are the zero Pos if the tree records
+// no position, as in trees built by hand.
+func Span(node Node) (start, end Pos) {
+	if IsNil(node) {
+		return Pos{}, Pos{}
+	}
+	widen := func(s, e Pos) {
+		if !s.IsValid() {
+			return
+		}
+		if !start.IsValid() || s.Before(start) {
+			start = s
+		}
+		if end.Before(e) {
+			end = e
+		}
+	}
+	for _, tok := range tokens(node) {
+		if tok.Line > 0 {
+			widen(TokenSpan(tok))
+		}
+	}
+	for _, child := range Children(node) {
+		widen(Span(child))
+	}
+	return start, end
+}
+
+// tokens returns the tokens node holds itself, not through its children.
+func tokens(node Node) []gtoken.Token {
+	switch n := node.(type) {
+	case *LetStatement:
+		return []gtoken.Token{n.Token}
+	case *ReturnStatement:
+		return []gtoken.Token{n.Token}
+	case *ThrowStatement:
+		return []gtoken.Token{n.Token}
+	case *TryStatement:
+		return []gtoken.Token{n.Token}
+	case *TypeStatement:
+		return []gtoken.Token{n.Token}
+	case *StructStatement:
+		return []gtoken.Token{n.Token}
+	case *ObjectBlockStatement:
+		return []gtoken.Token{n.Token, n.Rbrace}
+	case *BlockStatement:
+		return []gtoken.Token{n.Token, n.Rbrace}
+	case *Identifier:
+		return []gtoken.Token{n.Token}
+	case *IdentifierType:
+		return []gtoken.Token{n.Token}
+	case *TypeIdentifier:
+		return []gtoken.Token{n.Token}
+	case *StringLiteral:
+		return []gtoken.Token{n.Token}
+	case *IntegerLiteral:
+		return []gtoken.Token{n.Token}
+	case *Null:
+		return []gtoken.Token{n.Token}
+	case *Boolean:
+		return []gtoken.Token{n.Token}
+	case *IndexExpression:
+		return []gtoken.Token{n.Token, n.Rbracket}
+	case *PropagateExpression:
+		return []gtoken.Token{n.Token}
+	case *ArrayLiteral:
+		return []gtoken.Token{n.Token, n.Rbracket}
+	case *TupleLiteral:
+		return []gtoken.Token{n.Token, n.Rparen}
+	case *HashLiteral:
+		return []gtoken.Token{n.Token, n.Rbrace}
+	case *ForExpression:
+		return []gtoken.Token{n.Token}
+	case *ForInExpression:
+		return []gtoken.Token{n.Token}
+	case *IfExpression:
+		return []gtoken.Token{n.Token}
+	case *CallExpression:
+		return []gtoken.Token{n.Token, n.Rparen}
+	case *FunctionLiteral:
+		return []gtoken.Token{n.Token}
+	case *PrefixExpression:
+		return []gtoken.Token{n.Token}
+	case *InfixExpression:
+		return []gtoken.Token{n.Token}
+	case *BadStmt:
+		return []gtoken.Token{n.From, n.To}
+	case *BadExpr:
+		return []gtoken.Token{n.From, n.To}
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A Visitor's Visit method is called by Walk for each node. If it returns a
+// visitor w, Walk visits the children of the node with w, and then calls
+// w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node in depth-first order, starting
+// with v.Visit(node).
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	for _, child := range Children(node) {
+		Walk(v, child)
+	}
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree rooted at node in depth-first order, calling
+// f for each node and then f(nil) once its children are done. Children are
+// skipped when f returns false.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// IsNil reports whether node is missing: nil, or a nil pointer such as an
+// optional field left empty.
+func IsNil(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// Children returns the nodes directly below node, in source order. Missing
+// nodes are left out; the pairs of a hash are ordered by the position of
+// their keys.
+func Children(node Node) []Node {
+	var children []Node
+	add := func(nodes ...Node) {
+		for _, n := range nodes {
+			if !IsNil(n) {
+				children = append(children, n)
+			}
+		}
+	}
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			add(s)
+		}
+	case *LetStatement:
+		add(n.Name, n.Value)
+	case *ReturnStatement:
+		add(n.ReturnValue)
+	case *ThrowStatement:
+		add(n.Value)
+	case *TryStatement:
+		add(n.Block, n.Param, n.Catch, n.Finally)
+	case *TypeStatement:
+		add(n.Name, n.Type, n.Body)
+	case *StructStatement:
+		add(n.Name, n.Body)
+	case *ObjectBlockStatement:
+		for _, s := range n.Statements {
+			add(s)
+		}
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			add(s)
+		}
+	case *ExpressionStatement:
+		add(n.Expression)
+	case *Identifier:
+		add(n.Type)
+	case *TypeIdentifier:
+		add(n.Variable)
+	case *IndexExpression:
+		add(n.Left, n.Index)
+	case *PropagateExpression:
+		add(n.Value)
+	case *ArrayLiteral:
+		for _, el := range n.Elements {
+			add(el)
+		}
+	case *TupleLiteral:
+		for _, el := range n.Elements {
+			add(el)
+		}
+	case *HashLiteral:
+		for _, k := range n.Keys() {
+			add(k, n.Pairs[k])
+		}
+	case *ForExpression:
+		add(n.Init, n.Condition, n.Increment, n.Consequence)
+	case *ForInExpression:
+		for _, v := range n.Variables {
+			add(v)
+		}
+		add(n.Iterable, n.Consequence)
+	case *IfExpression:
+		add(n.Condition, n.Consequence, n.Alternative)
+	case *CallExpression:
+		add(n.Function)
+		for _, arg := range n.Arguments {
+			add(arg)
+		}
+	case *FunctionLiteral:
+		for _, p := range n.Parameters {
+			add(p)
+		}
+		add(n.ReturnType, n.Body)
+	case *PrefixExpression:
+		add(n.Right)
+	case *InfixExpression:
+		add(n.Left, n.Right)
+	}
+	return children
+}
+
+// Rewrite replaces the nodes of the tree rooted at node, in post-order:
+// the children of a node are rewritten, and stored back into it, before f
+// is called with it. f returns the node to put in its place, which may be
+// the node itself. A statement f returns nil for is removed from its list;
+// other nil results leave the field empty. Rewrite returns the result of f
+// for node, and panics if f returns a node of the wrong kind for a field.
+func Rewrite(node Node, f func(Node) Node) Node {
+	if IsNil(node) {
+		return node
+	}
+	switch n := node.(type) {
+	case *Program:
+		n.Statements = rewriteStatements(n.Statements, f)
+	case *LetStatement:
+		n.Name = rewriteIdentifier(n.Name, f)
+		n.Value = rewriteExpression(n.Value, f)
+	case *ReturnStatement:
+		n.ReturnValue = rewriteExpression(n.ReturnValue, f)
+	case *ThrowStatement:
+		n.Value = rewriteExpression(n.Value, f)
+	case *TryStatement:
+		n.Block = rewriteBlock(n.Block, f)
+		n.Param = rewriteIdentifier(n.Param, f)
+		n.Catch = rewriteBlock(n.Catch, f)
+		n.Finally = rewriteBlock(n.Finally, f)
+	case *TypeStatement:
+		n.Name = rewriteIdentifier(n.Name, f)
+		n.Type = rewriteType(n.Type, f)
+		if n.Body != nil {
+			body, _ := rewriteAs[*ObjectBlockStatement](n.Body, f)
+			n.Body = body
+		}
+	case *StructStatement:
+		n.Name = rewriteIdentifier(n.Name, f)
+		n.Body = rewriteBlock(n.Body, f)
+	case *ObjectBlockStatement:
+		n.Statements = rewriteStatements(n.Statements, f)
+	case *BlockStatement:
+		n.Statements = rewriteStatements(n.Statements, f)
+	case *ExpressionStatement:
+		n.Expression = rewriteExpression(n.Expression, f)
+	case *Identifier:
+		n.Type = rewriteType(n.Type, f)
+	case *TypeIdentifier:
+		n.Variable = rewriteIdentifier(n.Variable, f)
+	case *IndexExpression:
+		n.Left = rewriteExpression(n.Left, f)
+		n.Index = rewriteExpression(n.Index, f)
+	case *PropagateExpression:
+		n.Value = rewriteExpression(n.Value, f)
+	case *ArrayLiteral:
+		for i, el := range n.Elements {
+			n.Elements[i] = rewriteExpression(el, f)
+		}
+	case *TupleLiteral:
+		for i, el := range n.Elements {
+			n.Elements[i] = rewriteExpression(el, f)
+		}
+	case *HashLiteral:
+		pairs := make(map[Expression]Expression, len(n.Pairs))
+		for _, k := range n.Keys() {
+			v := rewriteExpression(n.Pairs[k], f)
+			pairs[rewriteExpression(k, f)] = v
+		}
+		n.Pairs = pairs
+	case *ForExpression:
+		if n.Init != nil {
+			n.Init, _ = rewriteAs[Statement](n.Init, f)
+		}
+		n.Condition = rewriteExpression(n.Condition, f)
+		n.Increment = rewriteExpression(n.Increment, f)
+		n.Consequence = rewriteBlock(n.Consequence, f)
+	case *ForInExpression:
+		for i, v := range n.Variables {
+			n.Variables[i] = rewriteIdentifier(v, f)
+		}
+		n.Iterable = rewriteExpression(n.Iterable, f)
+		n.Consequence = rewriteBlock(n.Consequence, f)
+	case *IfExpression:
+		n.Condition = rewriteExpression(n.Condition, f)
+		n.Consequence = rewriteBlock(n.Consequence, f)
+		n.Alternative = rewriteBlock(n.Alternative, f)
+	case *CallExpression:
+		n.Function = rewriteExpression(n.Function, f)
+		for i, arg := range n.Arguments {
+			n.Arguments[i] = rewriteExpression(arg, f)
+		}
+	case *FunctionLiteral:
+		for i, p := range n.Parameters {
+			n.Parameters[i] = rewriteIdentifier(p, f)
+		}
+		n.ReturnType = rewriteType(n.ReturnType, f)
+		n.Body = rewriteBlock(n.Body, f)
+	case *PrefixExpression:
+		n.Right = rewriteExpression(n.Right, f)
+	case *InfixExpression:
+		n.Left = rewriteExpression(n.Left, f)
+		n.Right = rewriteExpression(n.Right, f)
+	}
+	return f(node)
+}
+
+// rewriteAs rewrites node, which must be rewritten to a T, and reports
+// whether the result is not nil.
+func rewriteAs[T Node](node T, f func(Node) Node) (T, bool) {
+	var zero T
+	if IsNil(node) {
+		return node, false
+	}
+	result := Rewrite(node, f)
+	if IsNil(result) {
+		return zero, false
+	}
+	t, ok := result.(T)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace %T with %T", node, result))
+	}
+	return t, true
+}
+
+func rewriteStatements(stmts []Statement, f func(Node) Node) []Statement {
+	out := stmts[:0]
+	for _, s := range stmts {
+		if IsNil(s) {
+			out = append(out, s)
+		} else if s, ok := rewriteAs(s, f); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func rewriteExpression(e Expression, f func(Node) Node) Expression {
+	e, _ = rewriteAs(e, f)
+	return e
+}
+
+func rewriteIdentifier(id *Identifier, f func(Node) Node) *Identifier {
+	id, _ = rewriteAs(id, f)
+	return id
+}
+
+func rewriteType(t *IdentifierType, f func(Node) Node) *IdentifierType {
+	t, _ = rewriteAs(t, f)
+	return t
+}
+
+func rewriteBlock(b *BlockStatement, f func(Node) Node) *BlockStatement {
+	b, _ = rewriteAs(b, f)
+	return b
+}
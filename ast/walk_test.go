@@ -0,0 +1,178 @@
+package ast_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/ast"
+	"github.com/GhostNet-Dev/gscript/gtoken"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestInspect(t *testing.T) {
+	input := `type p struct { let x: int = 1; }
+point q;
+let f = fn(a: int): int { return -a; };
+for (let i = 0; i < 2; i = i + 1) { f(i)? }
+try { throw {"b": [1], "a": null}["a"]; } catch (e) { if (true) { e } else { "s" } } finally { 1 }`
+	var got []string
+	depth := 0
+	ast.Inspect(parse(t, input), func(n ast.Node) bool {
+		if n == nil {
+			depth--
+			return false
+		}
+		got = append(got, strings.Repeat(".", depth)+strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast."))
+		depth++
+		return true
+	})
+	expected := []string{
+		"Program",
+		".TypeStatement", "..Identifier", "..IdentifierType", "..ObjectBlockStatement",
+		"...LetStatement", "....Identifier", ".....IdentifierType", "....IntegerLiteral",
+		".ExpressionStatement", "..TypeIdentifier", "...Identifier",
+		".LetStatement", "..Identifier", "..FunctionLiteral", "...Identifier", "....IdentifierType",
+		"...IdentifierType", "...BlockStatement", "....ReturnStatement", ".....PrefixExpression", "......Identifier",
+		".ExpressionStatement", "..ForExpression",
+		"...LetStatement", "....Identifier", "....IntegerLiteral",
+		"...InfixExpression", "....Identifier", "....IntegerLiteral",
+		"...InfixExpression", "....Identifier", "....InfixExpression", ".....Identifier", ".....IntegerLiteral",
+		"...BlockStatement", "....ExpressionStatement", ".....PropagateExpression",
+		"......CallExpression", ".......Identifier", ".......Identifier",
+		".TryStatement", "..BlockStatement", "...ThrowStatement", "....IndexExpression",
+		".....HashLiteral", "......StringLiteral", "......ArrayLiteral", ".......IntegerLiteral",
+		"......StringLiteral", "......Null", ".....StringLiteral",
+		"..Identifier", "..BlockStatement", "...ExpressionStatement", "....IfExpression",
+		".....Boolean", ".....BlockStatement", "......ExpressionStatement", ".......Identifier",
+		".....BlockStatement", "......ExpressionStatement", ".......StringLiteral",
+		"..BlockStatement", "...ExpressionStatement", "....IntegerLiteral",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong nodes.\nwant=%q\ngot=%q", expected, got)
+	}
+}
+
+type counter struct {
+	visits, leaves int
+}
+
+func (c *counter) Visit(n ast.Node) ast.Visitor {
+	if n == nil {
+		c.leaves++
+		return nil
+	}
+	c.visits++
+	if _, ok := n.(*ast.FunctionLiteral); ok {
+		return nil
+	}
+	return c
+}
+
+func TestWalk(t *testing.T) {
+	c := &counter{}
+	ast.Walk(c, parse(t, "let a = [1, 2]; let f = fn(x) { x };"))
+	// Program, two lets with their names, the array and its elements, and
+	// the function, whose children are skipped and which is not left.
+	if c.visits != 9 || c.leaves != 8 {
+		t.Errorf("wrong walk: %d visits, %d leaves", c.visits, c.leaves)
+	}
+}
+
+func TestSpan(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string // the source of each statement and expression
+	}{
+		{"let x = a + b * 2;", []string{"let x = a + b * 2", "x", "a + b * 2", "a", "b * 2", "b", "2"}},
+		{"f(1, \"s\")[0]?", []string{`f(1, "s")[0]?`, `f(1, "s")[0]?`, `f(1, "s")[0]`, `f(1, "s")`, "f", "1", `"s"`, "0"}},
+		{"let s = \"a\nb\"; -s", []string{"let s = \"a\nb\"", "s", "\"a\nb\"", "-s", "-s", "s"}},
+		{"{\"k\": [1]}", []string{`{"k": [1]}`, `{"k": [1]}`, `"k"`, "[1]", "1"}},
+		{"fn(a: int): bool { a }", []string{"fn(a: int): bool { a }", "fn(a: int): bool { a }", "a: int", "int", "bool", "{ a }", "a", "a"}},
+		{"if (x) { 1 } else { 2 }", []string{"if (x) { 1 } else { 2 }", "if (x) { 1 } else { 2 }", "x", "{ 1 }", "1", "1", "{ 2 }", "2", "2"}},
+		{"type t struct { let y = 1; }", []string{"type t struct { let y = 1; }", "t", "struct", "{ let y = 1; }", "let y = 1", "y", "1"}},
+		{"(1 + 2)", []string{"1 + 2", "1 + 2", "1", "2"}},
+	}
+
+	for _, tt := range tests {
+		lines := strings.SplitAfter(tt.input, "\n")
+		offset := func(p ast.Pos) int {
+			o := 0
+			for _, l := range lines[:p.Line-1] {
+				o += len(l)
+			}
+			return o + p.Column - 1
+		}
+		var got []string
+		ast.Inspect(parse(t, tt.input), func(n ast.Node) bool {
+			if _, ok := n.(*ast.Program); n != nil && !ok {
+				start, end := ast.Span(n)
+				got = append(got, tt.input[offset(start):offset(end)])
+			}
+			return true
+		})
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: wrong spans.\nwant=%q\ngot=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	if start, end := ast.Span(&ast.Identifier{Value: "x"}); start.IsValid() || end.IsValid() {
+		t.Errorf("span of a node without positions: %v, %v", start, end)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	program := parse(t, "let x = 1 + 2 * 3; puts(x); let f = fn() { 4 - 1; puts(0) }; f()")
+	result := ast.Rewrite(program, func(n ast.Node) ast.Node {
+		switch n := n.(type) {
+		case *ast.InfixExpression:
+			// Fold arithmetic on literals.
+			l, lok := n.Left.(*ast.IntegerLiteral)
+			r, rok := n.Right.(*ast.IntegerLiteral)
+			if !lok || !rok {
+				return n
+			}
+			v := map[string]int64{"+": l.Value + r.Value, "-": l.Value - r.Value, "*": l.Value * r.Value}[n.Operator]
+			return &ast.IntegerLiteral{
+				Token: gtoken.Token{Type: gtoken.INT, Literal: fmt.Sprint(v)},
+				Value: v,
+			}
+		case *ast.ExpressionStatement:
+			// Drop calls to puts.
+			if call, ok := n.Expression.(*ast.CallExpression); ok && call.Function.String() == "puts" {
+				return nil
+			}
+		}
+		return n
+	})
+	if result != program {
+		t.Fatalf("Rewrite did not return the program")
+	}
+	if got, want := program.String(), "let x = 7;let f = fn<f>()3;f()"; got != want {
+		t.Errorf("wrong rewrite. want=%q, got=%q", want, got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("replacing an expression with a statement did not panic")
+		}
+	}()
+	ast.Rewrite(parse(t, "1"), func(n ast.Node) ast.Node {
+		if _, ok := n.(*ast.IntegerLiteral); ok {
+			return &ast.BlockStatement{}
+		}
+		return n
+	})
+}
@@ -0,0 +1,529 @@
+// Package astjson converts syntax trees to JSON and back, for tools written
+// in other languages.
+//
+// A node is an object with its "kind", the name of its type in package
+// ast such as "LetStatement", and its "start" and "end" positions from
+// ast.Span, each {"line": l, "column": c}, end being just past the node.
+// Positions are left out where the tree has none. Attributes are fields of
+// the node:
+//
+//	"value"     the name of an Identifier, IdentifierType or TypeIdentifier,
+//	            and the value of a StringLiteral, IntegerLiteral or Boolean
+//	"operator"  of a PrefixExpression or InfixExpression
+//	"name"      of a FunctionLiteral, if it has one
+//
+// Child nodes are in "children", by the name of their field in package
+// ast with a lowercase first letter, such as "returnValue". Missing
+// children are left out. Lists of children are arrays, and the pairs of a
+// HashLiteral are its "keys" and "values" arrays, in source order.
+package astjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/GhostNet-Dev/gscript/ast"
+	"github.com/GhostNet-Dev/gscript/gtoken"
+)
+
+type object = map[string]interface{}
+
+// Encode returns the JSON form of node, indented by two spaces.
+func Encode(node ast.Node) ([]byte, error) {
+	return json.MarshalIndent(encode(node), "", "  ")
+}
+
+func encode(node ast.Node) interface{} {
+	if ast.IsNil(node) {
+		return nil
+	}
+	o := object{"kind": strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")}
+	if start, end := ast.Span(node); start.IsValid() {
+		o["start"] = object{"line": start.Line, "column": start.Column}
+		o["end"] = object{"line": end.Line, "column": end.Column}
+	}
+	children := object{}
+	child := func(role string, n ast.Node) {
+		if !ast.IsNil(n) {
+			children[role] = encode(n)
+		}
+	}
+
+	switch n := node.(type) {
+	case *ast.Program:
+		children["statements"] = list(n.Statements)
+	case *ast.LetStatement:
+		child("name", n.Name)
+		child("value", n.Value)
+	case *ast.ReturnStatement:
+		child("returnValue", n.ReturnValue)
+	case *ast.ThrowStatement:
+		child("value", n.Value)
+	case *ast.TryStatement:
+		child("block", n.Block)
+		child("param", n.Param)
+		child("catch", n.Catch)
+		child("finally", n.Finally)
+	case *ast.TypeStatement:
+		child("name", n.Name)
+		child("type", n.Type)
+		child("body", n.Body)
+	case *ast.StructStatement:
+		child("name", n.Name)
+		child("body", n.Body)
+	case *ast.ObjectBlockStatement:
+		children["statements"] = list(n.Statements)
+	case *ast.BlockStatement:
+		children["statements"] = list(n.Statements)
+	case *ast.ExpressionStatement:
+		child("expression", n.Expression)
+	case *ast.Identifier:
+		o["value"] = n.Value
+		child("type", n.Type)
+	case *ast.IdentifierType:
+		o["value"] = n.Value
+	case *ast.TypeIdentifier:
+		o["value"] = n.Value
+		child("variable", n.Variable)
+	case *ast.StringLiteral:
+		o["value"] = n.Value
+	case *ast.IntegerLiteral:
+		o["value"] = n.Value
+	case *ast.Boolean:
+		o["value"] = n.Value
+	case *ast.Null:
+	case *ast.PrefixExpression:
+		o["operator"] = n.Operator
+		child("right", n.Right)
+	case *ast.InfixExpression:
+		o["operator"] = n.Operator
+		child("left", n.Left)
+		child("right", n.Right)
+	case *ast.IndexExpression:
+		child("left", n.Left)
+		child("index", n.Index)
+	case *ast.PropagateExpression:
+		child("value", n.Value)
+	case *ast.ArrayLiteral:
+		children["elements"] = list(n.Elements)
+	case *ast.TupleLiteral:
+		children["elements"] = list(n.Elements)
+	case *ast.HashLiteral:
+		// Children alternates the keys and values in source order.
+		var keys, values []ast.Node
+		for i, c := range ast.Children(n) {
+			if i%2 == 0 {
+				keys = append(keys, c)
+			} else {
+				values = append(values, c)
+			}
+		}
+		children["keys"] = list(keys)
+		children["values"] = list(values)
+	case *ast.ForExpression:
+		child("init", n.Init)
+		child("condition", n.Condition)
+		child("increment", n.Increment)
+		child("consequence", n.Consequence)
+	case *ast.ForInExpression:
+		children["variables"] = list(n.Variables)
+		child("iterable", n.Iterable)
+		child("consequence", n.Consequence)
+	case *ast.IfExpression:
+		child("condition", n.Condition)
+		child("consequence", n.Consequence)
+		child("alternative", n.Alternative)
+	case *ast.CallExpression:
+		child("function", n.Function)
+		children["arguments"] = list(n.Arguments)
+	case *ast.FunctionLiteral:
+		if n.Name != "" {
+			o["name"] = n.Name
+		}
+		children["parameters"] = list(n.Parameters)
+		child("returnType", n.ReturnType)
+		child("body", n.Body)
+	}
+	if len(children) > 0 {
+		o["children"] = children
+	}
+	return o
+}
+
+func list[T ast.Node](nodes []T) []interface{} {
+	out := make([]interface{}, len(nodes))
+	for i, n := range nodes {
+		out[i] = encode(n)
+	}
+	return out
+}
+
+// Decode returns the program of the JSON form Encode gives. Tokens are
+// rebuilt from the kinds and positions of the nodes; those of operators,
+// which the JSON form does not locate, have no position.
+func Decode(data []byte) (*ast.Program, error) {
+	node, err := DecodeNode(data)
+	if err != nil {
+		return nil, err
+	}
+	program, ok := node.(*ast.Program)
+	if !ok {
+		return nil, fmt.Errorf("astjson: want a Program, got %s", kindOf(node))
+	}
+	return program, nil
+}
+
+// DecodeNode returns the node of the JSON form Encode gives.
+func DecodeNode(data []byte) (ast.Node, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("astjson: %w", err)
+	}
+	d := &decoder{}
+	node := d.node("$", v)
+	if d.err != nil {
+		return nil, d.err
+	}
+	if ast.IsNil(node) {
+		return nil, fmt.Errorf("astjson: no node")
+	}
+	return node, nil
+}
+
+// operators maps the operators to their token types.
+var operators = map[string]gtoken.TokenType{
+	"=":  gtoken.ASSIGN,
+	"+":  gtoken.PLUS,
+	"-":  gtoken.MINUS,
+	"!":  gtoken.BANG,
+	"*":  gtoken.ASTERISK,
+	"/":  gtoken.SLASH,
+	"==": gtoken.EQ,
+	"!=": gtoken.NOT_EQ,
+	"<":  gtoken.LT,
+	">":  gtoken.RT,
+}
+
+// decoder keeps the first error found, at a path like
+// $.children.statements[0].
+type decoder struct {
+	err error
+}
+
+func (d *decoder) fail(path, format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("astjson: %s: %s", path, fmt.Sprintf(format, a...))
+	}
+}
+
+func (d *decoder) node(path string, v interface{}) ast.Node {
+	if v == nil || d.err != nil {
+		return nil
+	}
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		d.fail(path, "want a node, got %s", jsonType(v))
+		return nil
+	}
+	kind, _ := o["kind"].(string)
+	start, end := d.pos(path+".start", o["start"]), d.pos(path+".end", o["end"])
+	children := map[string]interface{}{}
+	if c, ok := o["children"]; ok {
+		if children, ok = c.(map[string]interface{}); !ok {
+			d.fail(path+".children", "want an object, got %s", jsonType(c))
+			return nil
+		}
+	}
+	field := path + "."
+	path += ".children."
+
+	// tok is the token at the start of the node, and closing the one of
+	// the single character ending it.
+	tok := func(t gtoken.TokenType, literal string) gtoken.Token {
+		return gtoken.Token{Type: t, Literal: literal, Line: start.Line, Column: start.Column}
+	}
+	closing := func(t gtoken.TokenType) gtoken.Token {
+		if !end.IsValid() {
+			return gtoken.Token{Type: t, Literal: string(t)}
+		}
+		return gtoken.Token{Type: t, Literal: string(t), Line: end.Line, Column: end.Column - 1}
+	}
+	expr := func(role string) ast.Expression {
+		return as[ast.Expression](d, path+role, children[role], "an expression")
+	}
+	ident := func(role string) *ast.Identifier {
+		return as[*ast.Identifier](d, path+role, children[role], "an Identifier")
+	}
+	block := func(role string) *ast.BlockStatement {
+		return as[*ast.BlockStatement](d, path+role, children[role], "a BlockStatement")
+	}
+	typ := func(role string) *ast.IdentifierType {
+		return as[*ast.IdentifierType](d, path+role, children[role], "an IdentifierType")
+	}
+	value := func() string {
+		s, ok := o["value"].(string)
+		if !ok {
+			d.fail(field+"value", "want a string, got %s", jsonType(o["value"]))
+		}
+		return s
+	}
+	operator := func() (gtoken.TokenType, string) {
+		op, _ := o["operator"].(string)
+		t, ok := operators[op]
+		if !ok {
+			d.fail(field+"operator", "unknown operator %q", op)
+		}
+		return t, op
+	}
+
+	switch kind {
+	case "Program":
+		return &ast.Program{Statements: listOf[ast.Statement](d, path+"statements", children["statements"], "a statement")}
+	case "LetStatement":
+		return &ast.LetStatement{Token: tok(gtoken.LET, "let"), Name: ident("name"), Value: expr("value")}
+	case "ReturnStatement":
+		return &ast.ReturnStatement{Token: tok(gtoken.RETURN, "return"), ReturnValue: expr("returnValue")}
+	case "ThrowStatement":
+		return &ast.ThrowStatement{Token: tok(gtoken.THROW, "throw"), Value: expr("value")}
+	case "TryStatement":
+		return &ast.TryStatement{
+			Token:   tok(gtoken.TRY, "try"),
+			Block:   block("block"),
+			Param:   ident("param"),
+			Catch:   block("catch"),
+			Finally: block("finally"),
+		}
+	case "TypeStatement":
+		return &ast.TypeStatement{
+			Token: tok(gtoken.TYPE, "type"),
+			Name:  ident("name"),
+			Type:  typ("type"),
+			Body:  as[*ast.ObjectBlockStatement](d, path+"body", children["body"], "an ObjectBlockStatement"),
+		}
+	case "StructStatement":
+		return &ast.StructStatement{Token: tok(gtoken.STRUCT, "struct"), Name: ident("name"), Body: block("body")}
+	case "ObjectBlockStatement":
+		return &ast.ObjectBlockStatement{
+			Token:      tok(gtoken.LBRACE, "{"),
+			Statements: listOf[ast.Statement](d, path+"statements", children["statements"], "a statement"),
+			Rbrace:     closing(gtoken.RBRACE),
+		}
+	case "BlockStatement":
+		return &ast.BlockStatement{
+			Token:      tok(gtoken.LBRACE, "{"),
+			Statements: listOf[ast.Statement](d, path+"statements", children["statements"], "a statement"),
+			Rbrace:     closing(gtoken.RBRACE),
+		}
+	case "ExpressionStatement":
+		return &ast.ExpressionStatement{Token: tok("", ""), Expression: expr("expression")}
+	case "Identifier":
+		name := value()
+		return &ast.Identifier{Token: tok(gtoken.IDENT, name), Value: name, Type: typ("type")}
+	case "IdentifierType":
+		name := value()
+		return &ast.IdentifierType{Token: tok(gtoken.LookupIdent(name), name), Value: name}
+	case "TypeIdentifier":
+		name := value()
+		return &ast.TypeIdentifier{Token: tok(gtoken.IDENT, name), Value: name, Variable: ident("variable")}
+	case "StringLiteral":
+		s := value()
+		return &ast.StringLiteral{Token: tok(gtoken.STRING, s), Value: s}
+	case "IntegerLiteral":
+		n, ok := o["value"].(json.Number)
+		i, err := n.Int64()
+		if !ok || err != nil {
+			d.fail(field+"value", "want an integer, got %v", o["value"])
+		}
+		return &ast.IntegerLiteral{Token: tok(gtoken.INT, n.String()), Value: i}
+	case "Boolean":
+		b, ok := o["value"].(bool)
+		if !ok {
+			d.fail(field+"value", "want a bool, got %s", jsonType(o["value"]))
+		}
+		if b {
+			return &ast.Boolean{Token: tok(gtoken.TRUE, "true"), Value: true}
+		}
+		return &ast.Boolean{Token: tok(gtoken.FALSE, "false"), Value: false}
+	case "Null":
+		return &ast.Null{Token: tok(gtoken.NULL, "null"), Value: "null"}
+	case "BadStmt":
+		return &ast.BadStmt{From: tok("", ""), To: gtoken.Token{Line: end.Line, Column: end.Column}}
+	case "BadExpr":
+		return &ast.BadExpr{From: tok("", ""), To: gtoken.Token{Line: end.Line, Column: end.Column}}
+	case "PrefixExpression":
+		t, op := operator()
+		return &ast.PrefixExpression{Token: tok(t, op), Operator: op, Right: expr("right")}
+	case "InfixExpression":
+		t, op := operator()
+		return &ast.InfixExpression{
+			Token:    gtoken.Token{Type: t, Literal: op},
+			Operator: op,
+			Left:     expr("left"),
+			Right:    expr("right"),
+		}
+	case "IndexExpression":
+		return &ast.IndexExpression{
+			Token:    gtoken.Token{Type: gtoken.LBRACKET, Literal: "["},
+			Left:     expr("left"),
+			Index:    expr("index"),
+			Rbracket: closing(gtoken.RBRACKET),
+		}
+	case "PropagateExpression":
+		return &ast.PropagateExpression{Token: closing(gtoken.QUESTION), Value: expr("value")}
+	case "ArrayLiteral":
+		return &ast.ArrayLiteral{
+			Token:    tok(gtoken.LBRACKET, "["),
+			Elements: listOf[ast.Expression](d, path+"elements", children["elements"], "an expression"),
+			Rbracket: closing(gtoken.RBRACKET),
+		}
+	case "TupleLiteral":
+		return &ast.TupleLiteral{
+			Token:    tok(gtoken.LPAREN, "("),
+			Elements: listOf[ast.Expression](d, path+"elements", children["elements"], "an expression"),
+			Rparen:   closing(gtoken.RPAREN),
+		}
+	case "HashLiteral":
+		keys := listOf[ast.Expression](d, path+"keys", children["keys"], "an expression")
+		values := listOf[ast.Expression](d, path+"values", children["values"], "an expression")
+		if len(keys) != len(values) {
+			d.fail(path+"values", "%d values for %d keys", len(values), len(keys))
+			return nil
+		}
+		hash := &ast.HashLiteral{
+			Token:  tok(gtoken.LBRACE, "{"),
+			Pairs:  make(map[ast.Expression]ast.Expression, len(keys)),
+			Rbrace: closing(gtoken.RBRACE),
+		}
+		for i, k := range keys {
+			hash.Pairs[k] = values[i]
+		}
+		return hash
+	case "ForExpression":
+		return &ast.ForExpression{
+			Token:       tok(gtoken.FOR, "for"),
+			Init:        as[ast.Statement](d, path+"init", children["init"], "a statement"),
+			Condition:   expr("condition"),
+			Increment:   expr("increment"),
+			Consequence: block("consequence"),
+		}
+	case "ForInExpression":
+		variables := listOf[*ast.Identifier](d, path+"variables", children["variables"], "an Identifier")
+		if len(variables) != 1 && len(variables) != 2 {
+			d.fail(path+"variables", "want 1 or 2 variables, got %d", len(variables))
+			return nil
+		}
+		return &ast.ForInExpression{
+			Token:       tok(gtoken.FOR, "for"),
+			Variables:   variables,
+			Iterable:    expr("iterable"),
+			Consequence: block("consequence"),
+		}
+	case "IfExpression":
+		return &ast.IfExpression{
+			Token:       tok(gtoken.IF, "if"),
+			Condition:   expr("condition"),
+			Consequence: block("consequence"),
+			Alternative: block("alternative"),
+		}
+	case "CallExpression":
+		return &ast.CallExpression{
+			Token:     gtoken.Token{Type: gtoken.LPAREN, Literal: "("},
+			Function:  expr("function"),
+			Arguments: listOf[ast.Expression](d, path+"arguments", children["arguments"], "an expression"),
+			Rparen:    closing(gtoken.RPAREN),
+		}
+	case "FunctionLiteral":
+		name, _ := o["name"].(string)
+		return &ast.FunctionLiteral{
+			Token:      tok(gtoken.FUNCTION, "fn"),
+			Name:       name,
+			Parameters: listOf[*ast.Identifier](d, path+"parameters", children["parameters"], "an Identifier"),
+			ReturnType: typ("returnType"),
+			Body:       block("body"),
+		}
+	}
+	d.fail(field+"kind", "unknown kind %q", kind)
+	return nil
+}
+
+// as decodes v at path as a node of type T, described by want.
+func as[T ast.Node](d *decoder, path string, v interface{}, want string) T {
+	var zero T
+	node := d.node(path, v)
+	if ast.IsNil(node) {
+		return zero
+	}
+	t, ok := node.(T)
+	if !ok {
+		d.fail(path, "want %s, got %s", want, kindOf(node))
+		return zero
+	}
+	return t
+}
+
+func listOf[T ast.Node](d *decoder, path string, v interface{}, want string) []T {
+	if v == nil {
+		return []T{}
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		d.fail(path, "want an array, got %s", jsonType(v))
+		return nil
+	}
+	out := make([]T, len(items))
+	for i, item := range items {
+		out[i] = as[T](d, fmt.Sprintf("%s[%d]", path, i), item, want)
+	}
+	return out
+}
+
+func (d *decoder) pos(path string, v interface{}) ast.Pos {
+	if v == nil {
+		return ast.Pos{}
+	}
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		d.fail(path, "want a position, got %s", jsonType(v))
+		return ast.Pos{}
+	}
+	var p ast.Pos
+	for _, f := range []struct {
+		name string
+		to   *int
+	}{{"line", &p.Line}, {"column", &p.Column}} {
+		n, ok := o[f.name].(json.Number)
+		i, err := n.Int64()
+		if !ok || err != nil || i < 1 {
+			d.fail(path+"."+f.name, "want a number from 1, got %v", o[f.name])
+			return ast.Pos{}
+		}
+		*f.to = int(i)
+	}
+	return p
+}
+
+func kindOf(node ast.Node) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a bool"
+	}
+	return fmt.Sprintf("%T", v)
+}
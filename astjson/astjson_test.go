@@ -0,0 +1,118 @@
+package astjson
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/ast"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/parser"
+	"github.com/GhostNet-Dev/gscript/printer"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestEncode(t *testing.T) {
+	got, err := Encode(parse(t, `let x: int = -f(1)["a"];`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/let.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got)+"\n" != string(want) {
+		t.Errorf("wrong encoding.\nwant=%s\ngot=%s", want, got)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	inputs := []string{
+		`let s = "a
+b"; s`,
+		`type p struct { let x: int = 1; } point q;`,
+		`let f = fn(a: int, b): bool { return a < b; }; f(1, 2) == !true`,
+		`for (let i = 0; i > -2; i = i - 1) { puts(i * 2 / 1 != 3); }`,
+		`try { throw {"b": [1], "a": null}["a"]; } catch (e) { if (e) { e } else { "s" } } finally { 1 }`,
+		`let g = fn() { let v = h()?; v }; {}; []`,
+		`let t = (1, (2,)); {(1, 2): freeze([t])}`,
+		`for (k, v in {"a": 1}) { puts(k, v); } for (c in "ab") { c }`,
+	}
+	scripts, _ := filepath.Glob("../*/testdata/*.gs")
+	for _, file := range scripts {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, string(data))
+	}
+
+	for _, input := range inputs {
+		program := parse(t, input)
+		data, err := Encode(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Decode(data)
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		// String lists the pairs of a hash in map order, the printer in source
+		// order.
+		var want, got strings.Builder
+		printer.Fprint(&want, program)
+		printer.Fprint(&got, decoded)
+		if got.String() != want.String() {
+			t.Errorf("%q: printed differently.\nwant=%q\ngot=%q", input, want.String(), got.String())
+		}
+		again, err := Encode(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(data) {
+			t.Errorf("%q: encoding the decoded tree differs.\nwant=%s\ngot=%s", input, data, again)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[`, "astjson: unexpected EOF"},
+		{`null`, "astjson: no node"},
+		{`{"kind": "Identifier", "value": "x"}`, "astjson: want a Program, got Identifier"},
+		{`{"kind": "Program", "children": {"statements": [{"kind": "Loop"}]}}`,
+			`astjson: $.children.statements[0].kind: unknown kind "Loop"`},
+		{`{"kind": "Program", "children": {"statements": [{"kind": "Identifier", "value": "x"}]}}`,
+			"astjson: $.children.statements[0]: want a statement, got Identifier"},
+		{`{"kind": "Program", "children": {"statements": {}}}`,
+			"astjson: $.children.statements: want an array, got an object"},
+		{`{"kind": "ExpressionStatement", "children": {"expression": {"kind": "InfixExpression", "operator": "%"}}}`,
+			`astjson: $.children.expression.operator: unknown operator "%"`},
+		{`{"kind": "IntegerLiteral", "value": 1.5}`, "astjson: $.value: want an integer, got 1.5"},
+		{`{"kind": "Boolean", "value": "true"}`, "astjson: $.value: want a bool, got a string"},
+		{`{"kind": "Null", "start": {"line": 0, "column": 1}}`,
+			"astjson: $.start.line: want a number from 1, got 0"},
+		{`{"kind": "HashLiteral", "children": {"keys": [{"kind": "Null"}]}}`,
+			"astjson: $.children.values: 0 values for 1 keys"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode([]byte(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/GhostNet-Dev/gscript/astjson"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/parser"
+	"github.com/GhostNet-Dev/gscript/printer"
+	"github.com/spf13/cobra"
+)
+
+// NewASTCommand prints the syntax tree of a script as JSON, or with
+// --decode turns such JSON back into source.
+func NewASTCommand() *cobra.Command {
+	var decode bool
+	cmd := &cobra.Command{
+		Use:   "ast [--decode] file",
+		Short: "Print the syntax tree of a gscript file as JSON",
+		Long: "Print the syntax tree of a gscript file as JSON, in the form described\n" +
+			"by package astjson. With --decode, read that form from the file instead\n" +
+			"and print the program it holds as formatted source.",
+		Args: cobra.ExactArgs(1),
+		// Syntax errors are not usage errors.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			input, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			if decode {
+				program, err := astjson.Decode(input)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				return printer.Fprint(cmd.OutOrStdout(), program)
+			}
+
+			p := parser.NewParser(lexer.NewLexer(string(input)))
+			program := p.ParseProgram()
+			if len(p.Errors()) != 0 {
+				for _, msg := range p.Errors() {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s:%s\n", name, msg)
+				}
+				return fmt.Errorf("%s: parsing failed", name)
+			}
+			data, err := astjson.Encode(program)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s\n", data)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&decode, "decode", false, "read a JSON tree and print it as source")
+	return cmd
+}
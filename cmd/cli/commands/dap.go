@@ -0,0 +1,22 @@
+package commands
+
+import (
+	"github.com/GhostNet-Dev/gscript/debugger"
+	"github.com/spf13/cobra"
+)
+
+// NewDAPCommand serves the Debug Adapter Protocol on stdin and stdout, for
+// editors to debug scripts with.
+func NewDAPCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dap",
+		Short: "Serve the Debug Adapter Protocol on stdio",
+		Args:  cobra.NoArgs,
+		// Protocol failures are not usage errors.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return debugger.ServeDAP(cmd.InOrStdin(), cmd.OutOrStdout())
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/GhostNet-Dev/gscript/compiler"
+	"github.com/GhostNet-Dev/gscript/debugger"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/parser"
+	"github.com/spf13/cobra"
+)
+
+// NewDebugCommand runs a script file on the VM under the interactive
+// debugger.
+func NewDebugCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "debug file.gs",
+		Short: "Debug a gscript file",
+		Args:  cobra.ExactArgs(1),
+		// Script failures are not usage errors.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			input, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			p := parser.NewParser(lexer.NewLexer(string(input)))
+			program := p.ParseProgram()
+			if len(p.Errors()) != 0 {
+				for _, msg := range p.Errors() {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s:%s\n", args[0], msg)
+				}
+				return fmt.Errorf("%s: parsing failed", args[0])
+			}
+
+			comp := compiler.NewCompiler()
+			if err := comp.Compile(program); err != nil {
+				return fmt.Errorf("compilation failed: %w", err)
+			}
+			return debugger.Start(cmd.InOrStdin(), cmd.OutOrStdout(), args[0], string(input), comp.Bytecode())
+		},
+	}
+	return cmd
+}
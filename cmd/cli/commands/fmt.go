@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/GhostNet-Dev/gscript/internal/diff"
+	"github.com/GhostNet-Dev/gscript/printer"
+	"github.com/spf13/cobra"
+)
+
+// NewFmtCommand formats scripts in the canonical style, printing them,
+// rewriting them with -w or showing the changes with -d.
+func NewFmtCommand() *cobra.Command {
+	var (
+		write    bool
+		showDiff bool
+	)
+	cmd := &cobra.Command{
+		Use:   "fmt [-w] [-d] [file.gs...]",
+		Short: "Format gscript files",
+		Long: "Format gscript files, or standard input if none are given, and " +
+			"print the result.",
+		// Syntax errors are not usage errors.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				if write {
+					return errors.New("cannot use -w with standard input")
+				}
+				return formatFile(cmd, "<standard input>", cmd.InOrStdin(), false, showDiff)
+			}
+			failed := false
+			for _, name := range args {
+				f, err := os.Open(name)
+				if err != nil {
+					return err
+				}
+				err = formatFile(cmd, name, f, write, showDiff)
+				f.Close()
+				if err != nil {
+					fmt.Fprintln(cmd.ErrOrStderr(), err)
+					failed = true
+				}
+			}
+			if failed {
+				return errors.New("formatting failed")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&write, "write", "w", false, "write the result to the file instead of printing it")
+	cmd.Flags().BoolVarP(&showDiff, "diff", "d", false, "print a diff of the changes instead of the result")
+	return cmd
+}
+
+func formatFile(cmd *cobra.Command, name string, in io.Reader, write, showDiff bool) error {
+	src, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	formatted, err := printer.Format(string(src))
+	var perr *printer.Error
+	if errors.As(err, &perr) {
+		for _, e := range perr.Errors {
+			fmt.Fprintf(cmd.ErrOrStderr(), "%s:%s\n", name, e)
+		}
+		return fmt.Errorf("%s: parsing failed", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if showDiff {
+		fmt.Fprint(cmd.OutOrStdout(), diff.Unified(name+".orig", name, string(src), formatted))
+	}
+	if write {
+		if formatted == string(src) {
+			return nil
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(name, []byte(formatted), info.Mode().Perm())
+	}
+	if !showDiff {
+		fmt.Fprint(cmd.OutOrStdout(), formatted)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package commands
+
+import (
+	"github.com/GhostNet-Dev/gscript/lsp"
+	"github.com/spf13/cobra"
+)
+
+// NewLSPCommand serves the Language Server Protocol on stdin and stdout,
+// for editors to check and navigate scripts with.
+func NewLSPCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lsp",
+		Short: "Serve the Language Server Protocol on stdio",
+		Args:  cobra.NoArgs,
+		// Protocol failures are not usage errors.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return lsp.Serve(cmd.InOrStdin(), cmd.OutOrStdout())
+		},
+	}
+	return cmd
+}
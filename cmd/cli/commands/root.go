@@ -37,6 +37,14 @@ func NewRootCommand() *cobra.Command {
 			repl.Start(os.Stdin, os.Stdout)
 		},
 	}
+	cmd.AddCommand(NewRunCommand())
+	cmd.AddCommand(NewDebugCommand())
+	cmd.AddCommand(NewDAPCommand())
+	cmd.AddCommand(NewLSPCommand())
+	cmd.AddCommand(NewFmtCommand())
+	cmd.AddCommand(NewVetCommand())
+	cmd.AddCommand(NewTokensCommand())
+	cmd.AddCommand(NewASTCommand())
 
 	return cmd
 }
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/GhostNet-Dev/gscript/compiler"
+	"github.com/GhostNet-Dev/gscript/evaluator"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/object"
+	"github.com/GhostNet-Dev/gscript/parser"
+	"github.com/GhostNet-Dev/gscript/vm"
+	"github.com/spf13/cobra"
+)
+
+// NewRunCommand runs a script file on the VM, or on the tree-walking
+// evaluator with --eval.
+func NewRunCommand() *cobra.Command {
+	var (
+		timeout  time.Duration
+		useEval  bool
+		gasLimit uint64
+		memLimit uint64
+		optimize bool
+		typed    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "run file.gs",
+		Short: "Run a gscript file",
+		Args:  cobra.ExactArgs(1),
+		// Script failures are not usage errors.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			input, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			p := parser.NewParser(lexer.NewLexer(string(input)))
+			program := p.ParseProgram()
+			if len(p.Errors()) != 0 {
+				for _, msg := range p.Errors() {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s:%s\n", args[0], msg)
+				}
+				return fmt.Errorf("%s: parsing failed", args[0])
+			}
+
+			ctx := cmd.Context()
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
+			if useEval {
+				opts := evaluator.Options{GasLimit: gasLimit, MemoryLimit: memLimit}
+				result, err := evaluator.EvalWithOptions(ctx, program, object.NewEnvironment(nil), opts)
+				if err != nil {
+					return err
+				}
+				if errObj, ok := result.(*object.Error); ok {
+					return fmt.Errorf("%s", errObj.Message)
+				}
+				return nil
+			}
+
+			comp := compiler.NewCompilerWithOptions(compiler.Options{Optimize: optimize, TypeCheck: typed})
+			if err := comp.Compile(program); err != nil {
+				return fmt.Errorf("compilation failed: %w", err)
+			}
+			opts := vm.Options{GasLimit: gasLimit, MemoryLimit: memLimit}
+			machine := vm.NewVMWithOptions(comp.Bytecode(), opts)
+			err = machine.RunContext(ctx)
+			if gasLimit > 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "gas used: %d\n", machine.GasUsed())
+			}
+			return err
+		},
+	}
+	cmd.Flags().DurationVar(&timeout, "timeout", time.Duration(defaultCfg.Timeout)*time.Second,
+		"abort the script after this long (0 disables the limit)")
+	cmd.Flags().BoolVar(&useEval, "eval", false, "run on the tree-walking evaluator instead of the VM")
+	cmd.Flags().Uint64Var(&gasLimit, "gas", 0, "meter the run and abort after this much gas (0 disables metering)")
+	cmd.Flags().BoolVarP(&optimize, "optimize", "O", false, "optimize the bytecode before running it")
+	cmd.Flags().BoolVar(&typed, "typecheck", false, "check the type annotations before compiling")
+	cmd.Flags().Uint64Var(&memLimit, "memory", 0, "abort after allocating about this many bytes (0 disables the limit)")
+	return cmd
+}
@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/GhostNet-Dev/gscript/gtoken"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/spf13/cobra"
+)
+
+// NewTokensCommand prints the tokens of a script as a JSON array, one
+// token per line.
+func NewTokensCommand() *cobra.Command {
+	var comments bool
+	cmd := &cobra.Command{
+		Use:   "tokens [--comments] file.gs",
+		Short: "Print the tokens of a gscript file as JSON",
+		Long: "Print the tokens of a gscript file, up to and including EOF, as a JSON\n" +
+			"array of {\"type\", \"literal\", \"line\", \"column\"} objects. The literal of\n" +
+			"a string is its text between the quotes, and its column that of the\n" +
+			"opening quote.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			input, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			l := lexer.NewLexer(string(input))
+			var tokens []gtoken.Token
+			for {
+				tok := l.NextTokenMake()
+				tokens = append(tokens, tok)
+				if tok.Type == gtoken.EOF {
+					break
+				}
+			}
+			if comments {
+				tokens = append(tokens, l.Comments()...)
+				sort.SliceStable(tokens, func(i, j int) bool {
+					a, b := tokens[i], tokens[j]
+					return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+				})
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintln(out, "[")
+			for i, tok := range tokens {
+				data, err := json.Marshal(tok)
+				if err != nil {
+					return err
+				}
+				sep := ","
+				if i == len(tokens)-1 {
+					sep = ""
+				}
+				fmt.Fprintf(out, "  %s%s\n", data, sep)
+			}
+			fmt.Fprintln(out, "]")
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&comments, "comments", false, "include the comments, as COMMENT tokens")
+	return cmd
+}
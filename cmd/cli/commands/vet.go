@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/parser"
+	"github.com/GhostNet-Dev/gscript/vet"
+	"github.com/spf13/cobra"
+)
+
+// NewVetCommand reports suspicious constructs in scripts, as
+// file:line:col: message (check).
+func NewVetCommand() *cobra.Command {
+	var checks strings.Builder
+	for _, c := range vet.Checks {
+		fmt.Fprintf(&checks, "  %-12s %s\n", c.ID, c.Doc)
+	}
+	cmd := &cobra.Command{
+		Use:   "vet file.gs...",
+		Short: "Report likely mistakes in gscript files",
+		Long: "Report likely mistakes in gscript files. The checks are:\n\n" + checks.String() +
+			"\nA comment \"// vet:ignore\" suppresses the reports on its line, and\n" +
+			"\"// vet:ignore unused,shadow\" the reports of the checks listed.",
+		Args: cobra.MinimumNArgs(1),
+		// Findings are not usage errors.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			found := false
+			for _, name := range args {
+				input, err := os.ReadFile(name)
+				if err != nil {
+					return err
+				}
+				l := lexer.NewLexer(string(input))
+				p := parser.NewParser(l)
+				program := p.ParseProgram()
+				if len(p.Errors()) != 0 {
+					for _, msg := range p.Errors() {
+						fmt.Fprintf(cmd.ErrOrStderr(), "%s:%s\n", name, msg)
+					}
+					found = true
+					continue
+				}
+				for _, d := range vet.Check(program, l.Comments()) {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s:%s\n", name, d)
+					found = true
+				}
+			}
+			if found {
+				return errors.New("vet found problems")
+			}
+			return nil
+		},
+	}
+	return cmd
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 type Instructions []byte
@@ -41,6 +42,18 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpThrow
+	OpPropagate
+	OpWide
+	OpTailCall
+	OpTuple
+	OpIter
+	OpIterNext
+	OpAssignLocal
+	OpSetFree
+	OpCaptureLocal
+	OpCaptureFree
+	OpLessThan
 )
 
 type Definition struct {
@@ -79,20 +92,146 @@ var definitions = map[Opcode]*Definition{
 	OpClosure:        {"OpClosure", []int{2, 1}},
 	OpGetFree:        {"OpGetFree", []int{1}},
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpThrow:          {"OpThrow", []int{}},
+	OpPropagate:      {"OpPropagate", []int{2}},
+	// OpWide prefixes an instruction whose 1-byte operands are 2 bytes
+	// wide. Make adds it when an operand needs it.
+	OpWide: {"OpWide", []int{}},
+	// OpTailCall calls like OpCall and returns the result, in place of the
+	// current frame.
+	OpTailCall: {"OpTailCall", []int{1}},
+	// OpTuple builds a tuple, like OpArray builds an array.
+	OpTuple: {"OpTuple", []int{2}},
+	// OpIter replaces the collection on top of the stack with the state
+	// of a loop over it.
+	OpIter: {"OpIter", []int{}},
+	// OpIterNext pushes the next element of the loop on top of the stack
+	// as the second operand number of variables bind it, or pops the loop
+	// and jumps to the first operand once there are none left.
+	OpIterNext: {"OpIterNext", []int{2, 1}},
+	// OpAssignLocal assigns to a local like OpSetLocal, but through the
+	// cell a closure captured it in, if any; OpSetLocal binds the slot
+	// anew.
+	OpAssignLocal: {"OpAssignLocal", []int{1}},
+	// OpSetFree assigns to a free variable through its cell.
+	OpSetFree: {"OpSetFree", []int{1}},
+	// OpCaptureLocal moves a local into a cell, unless a closure already
+	// did, and pushes the cell for OpClosure.
+	OpCaptureLocal: {"OpCaptureLocal", []int{1}},
+	// OpCaptureFree pushes the cell of a free variable for OpClosure.
+	OpCaptureFree: {"OpCaptureFree", []int{1}},
+	// OpLessThan compares its operands in source order, like
+	// OpGreaterThan, so errors name the operator that was written.
+	OpLessThan: {"OpLessThan", []int{}},
+}
+
+// MaxOperand is the largest operand of a width of 2 bytes, which is also
+// the largest operand after an OpWide prefix.
+const MaxOperand = 1<<16 - 1
+
+// OperandError reports an operand that does not fit its opcode even with
+// an OpWide prefix.
+type OperandError struct {
+	Op      Opcode
+	Operand int
+}
+
+func (e *OperandError) Error() string {
+	def, err := Lookup(byte(e.Op))
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("operand %d of %s is out of range [0, %d]", e.Operand, def.Name, MaxOperand)
+}
+
+// Check reports whether op can be encoded with operands.
+func Check(op Opcode, operands ...int) error {
+	for _, o := range operands {
+		if o < 0 || o > MaxOperand {
+			return &OperandError{Op: op, Operand: o}
+		}
+	}
+	return nil
+}
+
+// needsWide reports whether one of the operands exceeds its width.
+func needsWide(def *Definition, operands []int) bool {
+	for i, o := range operands {
+		if def.OperandWidths[i] == 1 && o > 255 {
+			return true
+		}
+	}
+	return false
+}
+
+// widths returns the operand widths of def, doubled from 1 to 2 bytes
+// after an OpWide prefix.
+func widths(def *Definition, wide bool) []int {
+	if !wide {
+		return def.OperandWidths
+	}
+	widths := make([]int, len(def.OperandWidths))
+	for i, w := range def.OperandWidths {
+		widths[i] = w
+		if w == 1 {
+			widths[i] = 2
+		}
+	}
+	return widths
+}
+
+// Handler is an entry of a function's exception table. An error raised
+// while ip is in [Start, End) resumes at Target with the operand stack cut
+// back to Depth values above the frame's locals and the caught error
+// pushed on top.
+type Handler struct {
+	Start  int
+	End    int
+	Target int
+	Depth  int
+}
+
+// Line is an entry of a function's line table: the statement of the
+// source line Line starts at the instruction at Pos.
+type Line struct {
+	Pos  int
+	Line int
+}
+
+// Local names the local slot Index while ip is in [Start, End).
+type Local struct {
+	Name  string
+	Index int
+	Start int
+	End   int
+}
+
+// LineAt returns the line of the instruction at ip, or 0 if lines has no
+// entry at or before it.
+func LineAt(lines []Line, ip int) int {
+	i := sort.Search(len(lines), func(i int) bool { return lines[i].Pos > ip })
+	if i == 0 {
+		return 0
+	}
+	return lines[i-1].Line
 }
 
 func (ins Instructions) String() string {
 	var out bytes.Buffer
 	i := 0
 	for i < len(ins) {
-		def, err := Lookup(ins[i])
+		op, operands, read, err := ReadInstruction(ins[i:])
 		if err != nil {
 			fmt.Fprintf(&out, "ERROR: %s\n", err)
-			continue
+			break
+		}
+		def, _ := Lookup(byte(op))
+		prefix := ""
+		if Opcode(ins[i]) == OpWide {
+			prefix = "OpWide "
 		}
-		operands, read := ReadOperands(def, ins[i+1:])
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
-		i += 1 + read
+		fmt.Fprintf(&out, "%04d %s%s\n", i, prefix, ins.fmtInstruction(def, operands))
+		i += read
 	}
 	return out.String()
 }
@@ -121,21 +260,40 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
+// MakeChecked is Make for operands that may not fit: it returns an
+// *OperandError for those instead of truncating them.
+func MakeChecked(op Opcode, operands ...int) ([]byte, error) {
+	if err := Check(op, operands...); err != nil {
+		return nil, err
+	}
+	return Make(op, operands...), nil
+}
+
+// Make encodes an instruction, with an OpWide prefix if a 1-byte operand
+// exceeds 255. Operands beyond MaxOperand are truncated; use MakeChecked
+// when they may occur.
 func Make(op Opcode, operands ...int) []byte {
 	def, ok := definitions[op]
 	if !ok {
 		return []byte{}
 	}
-	instructionLen := 1
-	for _, w := range def.OperandWidths {
+	prefix := []byte{}
+	wide := needsWide(def, operands)
+	if wide {
+		prefix = append(prefix, byte(OpWide))
+	}
+	operandWidths := widths(def, wide)
+	instructionLen := len(prefix) + 1
+	for _, w := range operandWidths {
 		instructionLen += w
 	}
 	instruction := make([]byte, instructionLen)
-	instruction[0] = byte(op)
+	copy(instruction, prefix)
+	instruction[len(prefix)] = byte(op)
 
-	offset := 1
+	offset := len(prefix) + 1
 	for i, o := range operands {
-		width := def.OperandWidths[i]
+		width := operandWidths[i]
 		switch width {
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
@@ -147,10 +305,42 @@ func Make(op Opcode, operands ...int) []byte {
 	return instruction
 }
 
+// ReadInstruction decodes the instruction at the start of ins, including
+// an OpWide prefix, and returns its opcode, operands and length in bytes.
+func ReadInstruction(ins Instructions) (Opcode, []int, int, error) {
+	def, err := Lookup(ins[0])
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if Opcode(ins[0]) != OpWide {
+		operands, read := ReadOperands(def, ins[1:])
+		return Opcode(ins[0]), operands, 1 + read, nil
+	}
+	if len(ins) < 2 {
+		return 0, nil, 0, fmt.Errorf("OpWide at the end of the instructions")
+	}
+	def, err = Lookup(ins[1])
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	operands, read := ReadWideOperands(def, ins[2:])
+	return Opcode(ins[1]), operands, 2 + read, nil
+}
+
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
-	operands := make([]int, len(def.OperandWidths))
+	return readOperands(widths(def, false), ins)
+}
+
+// ReadWideOperands reads the operands of an instruction after an OpWide
+// prefix.
+func ReadWideOperands(def *Definition, ins Instructions) ([]int, int) {
+	return readOperands(widths(def, true), ins)
+}
+
+func readOperands(operandWidths []int, ins Instructions) ([]int, int) {
+	operands := make([]int, len(operandWidths))
 	offset := 0
-	for i, width := range def.OperandWidths {
+	for i, width := range operandWidths {
 		switch width {
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
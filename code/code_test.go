@@ -1,6 +1,7 @@
 package code
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -14,6 +15,8 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpGetLocal, []int{256}, []byte{byte(OpWide), byte(OpGetLocal), 1, 0}},
+		{OpClosure, []int{1, 300}, []byte{byte(OpWide), byte(OpClosure), 0, 1, 1, 44}},
 	}
 
 	for _, tt := range tests {
@@ -40,12 +43,14 @@ func TestInstructionString(t *testing.T) {
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
 		Make(OpClosure, 65535, 255),
+		Make(OpCall, 1000),
 	}
 	expected := `0000 OpAdd
 0001 OpGetLocal 1
 0003 OpConstant 2
 0006 OpConstant 65535
 0009 OpClosure 65535 255
+0013 OpWide OpCall 1000
 `
 	concatted := Instructions{}
 	for _, ins := range instructions {
@@ -83,3 +88,49 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestReadInstruction(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		length   int
+	}{
+		{OpAdd, []int{}, 1},
+		{OpGetLocal, []int{255}, 2},
+		{OpGetLocal, []int{65535}, 4},
+		{OpClosure, []int{7, 256}, 6},
+	}
+	for _, tt := range tests {
+		op, operands, n, err := ReadInstruction(Make(tt.op, tt.operands...))
+		if err != nil {
+			t.Fatalf("ReadInstruction failed: %s", err)
+		}
+		if op != tt.op || n != tt.length {
+			t.Fatalf("wrong instruction. want=%d (%d bytes), got=%d (%d bytes)", tt.op, tt.length, op, n)
+		}
+		for i, want := range tt.operands {
+			if operands[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operands[i])
+			}
+		}
+	}
+}
+
+func TestMakeCheckedRejectsOutOfRangeOperands(t *testing.T) {
+	for _, operand := range []int{-1, 65536} {
+		if _, err := MakeChecked(OpGetLocal, operand); err == nil {
+			t.Errorf("MakeChecked(OpGetLocal, %d) returned no error", operand)
+		} else if _, ok := err.(*OperandError); !ok {
+			t.Errorf("MakeChecked(OpGetLocal, %d) returned %T, want *OperandError", operand, err)
+		}
+		// Make truncates instead, as it always has.
+		Make(OpGetLocal, operand)
+	}
+	ins, err := MakeChecked(OpGetLocal, 300)
+	if err != nil {
+		t.Fatalf("MakeChecked(OpGetLocal, 300) failed: %s", err)
+	}
+	if !bytes.Equal(ins, Make(OpGetLocal, 300)) {
+		t.Errorf("MakeChecked and Make disagree. got=%v, want=%v", ins, Make(OpGetLocal, 300))
+	}
+}
@@ -3,10 +3,14 @@ package compiler
 import (
 	"fmt"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/GhostNet-Dev/gscript/ast"
 	"github.com/GhostNet-Dev/gscript/code"
+	"github.com/GhostNet-Dev/gscript/gtoken"
 	"github.com/GhostNet-Dev/gscript/object"
+	"github.com/GhostNet-Dev/gscript/typecheck"
 )
 
 type EmittedInstruction struct {
@@ -18,15 +22,71 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+
+	// depth is the number of values on the operand stack at the end of
+	// instructions, above the function's locals.
+	depth    int
+	handlers []code.Handler
+	tries    []*pendingTry
+
+	// lines is the line table of instructions and locals the names of its
+	// local slots; a local whose block is still open has End -1.
+	lines  []code.Line
+	locals []code.Local
+}
+
+// pendingTry is a try statement whose body or catch clause is being
+// compiled.
+type pendingTry struct {
+	finally *ast.BlockStatement
+	// holes are the ranges of finally blocks inlined before a return,
+	// which the try's handlers must not cover.
+	holes [][2]int
 }
 
 type Compiler struct {
 	constants []object.Object
+	// interned maps the key of every interned constant to its index.
+	interned map[constantKey]int
+	// shared is the number of constants from earlier compilations the
+	// pool started with, which code outside this compiler refers to.
+	shared int
 
 	symbolTable *SymbolTable
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	opts Options
+
+	// err is the first operand that could not be encoded. emit records it
+	// and Compile returns it.
+	err error
+}
+
+// Error is an error in the program compiled, found at Token.
+type Error struct {
+	Token   gtoken.Token
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func errorAt(tok gtoken.Token, format string, a ...interface{}) error {
+	return &Error{Token: tok, Message: fmt.Sprintf(format, a...)}
+}
+
+// Options configures a Compiler created by NewCompilerWithOptions.
+type Options struct {
+	// Optimize runs the optimizer (-O) over the code of every function:
+	// constant folding, branch elimination, dead code removal, jump
+	// threading and OpPop elimination.
+	Optimize bool
+	// TypeCheck checks a program against its type annotations before
+	// compiling it, failing on the first mismatch; see package typecheck.
+	TypeCheck bool
 }
 
 func NewCompiler() *Compiler {
@@ -41,22 +101,43 @@ func NewCompiler() *Compiler {
 	}
 	return &Compiler{
 		constants:   []object.Object{},
+		interned:    map[constantKey]int{},
 		symbolTable: symbolTable,
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
 	}
 }
 
+func NewCompilerWithOptions(opts Options) *Compiler {
+	compiler := NewCompiler()
+	compiler.opts = opts
+	return compiler
+}
+
 func NewCompilerWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	compiler := NewCompiler()
 	compiler.symbolTable = s
 	compiler.constants = constants
+	compiler.shared = len(constants)
+	for i, constant := range constants {
+		if key, ok := internKey(constant); ok {
+			if _, seen := compiler.interned[key]; !seen {
+				compiler.interned[key] = i
+			}
+		}
+	}
 	return compiler
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
+		if c.opts.TypeCheck {
+			if mismatches := typecheck.Check(node); len(mismatches) > 0 {
+				pos := mismatches[0].Pos
+				return errorAt(gtoken.Token{Line: pos.Line, Column: pos.Column}, "%s", mismatches[0].Message)
+			}
+		}
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
@@ -64,6 +145,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.ExpressionStatement:
+		c.markLine(node.Token.Line)
 		err := c.Compile(node.Expression)
 		if err != nil {
 			return err
@@ -74,28 +156,31 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
-		if err := c.Compile(node.Consequence); err != nil {
+		depth := c.scopes[c.scopeIndex].depth
+		if err := c.compileBlockValue(node.Consequence); err != nil {
 			return err
 		}
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
 
 		jumpPos := c.emit(code.OpJump, 9999)
 		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
 
+		consequenceDepth := c.scopes[c.scopeIndex].depth
+		c.scopes[c.scopeIndex].depth = depth
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		} else {
-			if err := c.Compile(node.Alternative); err != nil {
+			if err := c.compileBlockValue(node.Alternative); err != nil {
 				return err
 			}
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+		}
+		// A branch that returned leaves less on the stack than the other.
+		if consequenceDepth > c.scopes[c.scopeIndex].depth {
+			c.scopes[c.scopeIndex].depth = consequenceDepth
 		}
 		c.changeOperand(jumpPos, len(c.currentInstructions()))
 	case *ast.BlockStatement:
+		c.enterBlock()
+		defer c.leaveBlock()
 		for _, s := range node.Statements {
 			if err := c.Compile(s); err != nil {
 				return err
@@ -111,20 +196,49 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return errorAt(node.Token, "unknown operator %s", node.Operator)
+		}
+	case *ast.ForExpression:
+		// The loop's variables live in a block around it. Each iteration
+		// binds them anew, so the closures created in one keep its
+		// variables.
+		c.enterBlock()
+		defer c.leaveBlock()
+		if node.Init != nil {
+			if err := c.Compile(node.Init); err != nil {
+				return err
+			}
 		}
-	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
-			if err != nil {
+		loopStart := len(c.currentInstructions())
+		c.markLine(node.Token.Line)
+		if node.Condition != nil {
+			if err := c.Compile(node.Condition); err != nil {
 				return err
 			}
-			err = c.Compile(node.Left)
-			if err != nil {
+		} else {
+			c.emit(code.OpTrue)
+		}
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		if err := c.Compile(node.Consequence); err != nil {
+			return err
+		}
+		if createsClosures(node.Condition, node.Consequence, node.Increment) {
+			c.renewLocals()
+		}
+		if node.Increment != nil {
+			if err := c.Compile(node.Increment); err != nil {
 				return err
 			}
-			c.emit(code.OpGreaterThan)
-			return nil
+			c.emit(code.OpPop)
+		}
+		c.emit(code.OpJump, loopStart)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.emit(code.OpNull)
+	case *ast.ForInExpression:
+		return c.compileForIn(node)
+	case *ast.InfixExpression:
+		if node.Operator == "=" {
+			return c.compileAssignment(node)
 		}
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -145,16 +259,24 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpDiv)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case "<":
+			c.emit(code.OpLessThan)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return errorAt(node.Token, "unknown operator %s", node.Operator)
 		}
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
-		c.emit(code.OpConstant, c.addConstant(integer))
+		index, err := c.addConstant(integer)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpConstant, index)
+	case *ast.Null:
+		c.emit(code.OpNull)
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -162,18 +284,26 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpFalse)
 		}
 	case *ast.LetStatement:
-		symbol := c.symbolTable.Define(node.Name.Value)
+		c.markLine(node.Token.Line)
+		// The value is compiled first: in let x = x + 1, the x on the
+		// right is the one the new x shadows.
 		if err := c.Compile(node.Value); err != nil {
 			return err
 		}
+		symbol := c.symbolTable.Define(node.Name.Value)
 		if symbol.Scope == GlobalScope {
 			c.emit(code.OpSetGlobal, symbol.Index)
 		} else {
 			c.emit(code.OpSetLocal, symbol.Index)
+			c.nameLocal(symbol)
 		}
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
-		c.emit(code.OpConstant, c.addConstant(str))
+		index, err := c.addConstant(str)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpConstant, index)
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
 			if err := c.Compile(el); err != nil {
@@ -181,15 +311,15 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 		c.emit(code.OpArray, len(node.Elements))
-	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
+	case *ast.TupleLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
 		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-		for _, k := range keys {
+		c.emit(code.OpTuple, len(node.Elements))
+	case *ast.HashLiteral:
+		for _, k := range node.Keys() {
 			if err := c.Compile(k); err != nil {
 				return err
 			}
@@ -209,7 +339,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return errorAt(node.Token, "undefined variable %s", node.Value)
 		}
 		c.loadSymbol(symbol)
 	case *ast.FunctionLiteral:
@@ -220,49 +350,286 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		for _, p := range node.Parameters {
-			c.symbolTable.Define(p.Value)
+			c.nameLocal(c.symbolTable.Define(p.Value))
 		}
 
 		if err := c.Compile(node.Body); err != nil {
 			return err
 		}
-		if c.lastInstructionIs(code.OpPop) {
+		if endsWithExpression(node.Body) && c.lastInstructionIs(code.OpPop) {
 			c.replaceLastPopWithReturn()
 		}
-		if !c.lastInstructionIs(code.OpReturnValue) {
+		if !c.lastInstructionReturns() {
 			c.emit(code.OpReturn)
 		}
 		freeSymbols := c.symbolTable.FreeSymbols
-		numLocals := c.symbolTable.numDefinitions
-		instructions := c.leaveScope()
+		numLocals := c.symbolTable.numLocals
+		c.closeLocals(0)
+		scope := c.scopes[c.scopeIndex]
+		c.leaveScope()
+		if c.opts.Optimize {
+			c.optimize(&scope, false)
+		}
 
-		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+		free := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			c.captureSymbol(s)
+			free[i] = s.Name
 		}
 
 		compiledFn := &object.CompiledFunction{
-			Instructions:  instructions,
+			Instructions:  scope.instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			Handlers:      scope.handlers,
+			Lines:         scope.lines,
+			Locals:        scope.locals,
+			Free:          free,
+		}
+		index, err := c.addConstant(compiledFn)
+		if err != nil {
+			return err
 		}
-		c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+		c.emit(code.OpClosure, index, len(freeSymbols))
 	case *ast.ReturnStatement:
+		c.markLine(node.Token.Line)
+		if call, ok := node.ReturnValue.(*ast.CallExpression); ok && c.inTailPosition() {
+			return c.compileCall(call, code.OpTailCall)
+		}
 		if err := c.Compile(node.ReturnValue); err != nil {
 			return err
 		}
+		if err := c.compilePendingFinally(); err != nil {
+			return err
+		}
 		c.emit(code.OpReturnValue)
+	case *ast.PropagateExpression:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		// OpPropagate jumps over the return unless the value is an error.
+		depth := c.scopes[c.scopeIndex].depth
+		propagatePos := c.emit(code.OpPropagate, 9999)
+		if err := c.compilePendingFinally(); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+		c.changeOperand(propagatePos, len(c.currentInstructions()))
+		c.scopes[c.scopeIndex].depth = depth
+	case *ast.ThrowStatement:
+		c.markLine(node.Token.Line)
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	case *ast.TryStatement:
+		c.markLine(node.Token.Line)
+		return c.compileTry(node)
 	case *ast.CallExpression:
-		if err := c.Compile(node.Function); err != nil {
+		return c.compileCall(node, code.OpCall)
+	case *ast.BadStmt:
+		return errorAt(node.From, "cannot compile a statement with syntax errors")
+	case *ast.BadExpr:
+		return errorAt(node.From, "cannot compile an expression with syntax errors")
+	// Structs only run on the evaluator.
+	case *ast.TypeStatement:
+		return errorAt(node.Token, "structs are not supported by the compiler")
+	case *ast.TypeIdentifier:
+		return errorAt(node.Token, "structs are not supported by the compiler")
+	}
+
+	return c.err
+}
+
+// compileCall compiles a call with op, OpCall or OpTailCall.
+func (c *Compiler) compileCall(node *ast.CallExpression, op code.Opcode) error {
+	if err := c.Compile(node.Function); err != nil {
+		return err
+	}
+	for _, a := range node.Arguments {
+		if err := c.Compile(a); err != nil {
 			return err
 		}
-		for _, a := range node.Arguments {
-			if err := c.Compile(a); err != nil {
-				return err
-			}
+	}
+	c.emit(op, len(node.Arguments))
+	return c.err
+}
+
+// inTailPosition reports whether a call returned here may replace the
+// current frame: it must be inside a function, and no try statement may
+// be waiting for it to finish.
+func (c *Compiler) inTailPosition() bool {
+	return c.scopeIndex > 0 && len(c.scopes[c.scopeIndex].tries) == 0
+}
+
+// lastInstructionReturns reports whether the last instruction leaves the
+// function.
+func (c *Compiler) lastInstructionReturns() bool {
+	return c.lastInstructionIs(code.OpReturnValue) || c.lastInstructionIs(code.OpTailCall)
+}
+
+func (c *Compiler) compileAssignment(node *ast.InfixExpression) error {
+	ident, ok := node.Left.(*ast.Identifier)
+	if !ok {
+		return errorAt(node.Token, "cannot assign to %s", node.Left.String())
+	}
+	symbol, ok := c.symbolTable.Resolve(ident.Value)
+	if !ok {
+		return errorAt(ident.Token, "undefined variable %s", ident.Value)
+	}
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, symbol.Index)
+	case LocalScope:
+		c.emit(code.OpAssignLocal, symbol.Index)
+	case FreeScope:
+		if origin := c.symbolTable.origin(symbol); origin.Scope != LocalScope {
+			return errorAt(ident.Token, "cannot assign to %s variable %s",
+				strings.ToLower(string(origin.Scope)), ident.Value)
+		}
+		c.emit(code.OpSetFree, symbol.Index)
+	default:
+		return errorAt(ident.Token, "cannot assign to %s variable %s",
+			strings.ToLower(string(symbol.Scope)), ident.Value)
+	}
+	c.loadSymbol(symbol)
+	return nil
+}
+
+// compileBlockValue compiles block so that it leaves its value, the value
+// of its last expression statement or null, on the stack.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	if endsWithExpression(block) && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else if !c.lastInstructionReturns() {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+func endsWithExpression(block *ast.BlockStatement) bool {
+	if len(block.Statements) == 0 {
+		return false
+	}
+	_, ok := block.Statements[len(block.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// compileTry lays out a try statement as
+//
+//	body; jump end
+//	catch: bind error; catch block; jump end
+//	rethrow: finally block; throw
+//	end: finally block
+//
+// with handlers sending errors in the body to catch (or rethrow without
+// a catch clause) and errors in the catch block to rethrow.
+func (c *Compiler) compileTry(node *ast.TryStatement) error {
+	scope := &c.scopes[c.scopeIndex]
+	depth := scope.depth
+	try := &pendingTry{finally: node.Finally}
+	scope.tries = append(scope.tries, try)
+
+	start := len(c.currentInstructions())
+	if err := c.Compile(node.Block); err != nil {
+		return err
+	}
+	end := len(c.currentInstructions())
+	exits := []int{c.emit(code.OpJump, 9999)}
+
+	catchStart, catchEnd := -1, -1
+	if node.Catch != nil {
+		catchStart = len(c.currentInstructions())
+		c.addHandlers(try, start, end, catchStart, depth)
+		c.scopes[c.scopeIndex].depth = depth + 1
+		c.enterBlock()
+		if node.Param != nil {
+			symbol := c.symbolTable.Define(node.Param.Value)
+			c.emit(code.OpSetLocal, symbol.Index)
+			c.nameLocal(symbol)
+		} else {
+			c.emit(code.OpPop)
+		}
+		err := c.Compile(node.Catch)
+		c.leaveBlock()
+		if err != nil {
+			return err
+		}
+		catchEnd = len(c.currentInstructions())
+		exits = append(exits, c.emit(code.OpJump, 9999))
+	}
+	c.scopes[c.scopeIndex].tries = c.scopes[c.scopeIndex].tries[:len(c.scopes[c.scopeIndex].tries)-1]
+
+	if node.Finally != nil {
+		rethrow := len(c.currentInstructions())
+		if node.Catch != nil {
+			c.addHandlers(try, catchStart, catchEnd, rethrow, depth)
+		} else {
+			c.addHandlers(try, start, end, rethrow, depth)
+		}
+		c.scopes[c.scopeIndex].depth = depth + 1
+		if err := c.Compile(node.Finally); err != nil {
+			return err
 		}
-		c.emit(code.OpCall, len(node.Arguments))
+		c.emit(code.OpThrow)
 	}
 
+	for _, pos := range exits {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	if node.Finally != nil {
+		if err := c.Compile(node.Finally); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addHandlers covers [start, end) but the holes of try with a handler.
+func (c *Compiler) addHandlers(try *pendingTry, start, end, target, depth int) {
+	scope := &c.scopes[c.scopeIndex]
+	for _, hole := range try.holes {
+		if hole[0] < start || hole[1] > end {
+			continue
+		}
+		if start < hole[0] {
+			scope.handlers = append(scope.handlers, code.Handler{Start: start, End: hole[0], Target: target, Depth: depth})
+		}
+		start = hole[1]
+	}
+	if start < end {
+		scope.handlers = append(scope.handlers, code.Handler{Start: start, End: end, Target: target, Depth: depth})
+	}
+}
+
+// compilePendingFinally inlines the finally blocks of the enclosing try
+// statements before a return, innermost first. Each block runs outside the
+// handlers of its own try and of the tries nested in it.
+func (c *Compiler) compilePendingFinally() error {
+	tries := c.scopes[c.scopeIndex].tries
+	defer func() { c.scopes[c.scopeIndex].tries = tries }()
+
+	for i := len(tries) - 1; i >= 0; i-- {
+		if tries[i].finally == nil {
+			continue
+		}
+		c.scopes[c.scopeIndex].tries = tries[:i]
+		start := len(c.currentInstructions())
+		if err := c.Compile(tries[i].finally); err != nil {
+			return err
+		}
+		hole := [2]int{start, len(c.currentInstructions())}
+		for _, try := range tries[i:] {
+			try.holes = append(try.holes, hole)
+		}
+	}
 	return nil
 }
 
@@ -281,13 +648,78 @@ func (c *Compiler) loadSymbol(s Symbol) {
 	}
 }
 
-func (c *Compiler) addConstant(obj object.Object) int {
+// captureSymbol pushes the variable s for OpClosure: the cell of a local
+// or free variable, which the closure shares with the enclosing function,
+// or the enclosing function itself.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+// MaxConstants is the number of constants a program may have, as the
+// operand of OpConstant and OpClosure is two bytes wide.
+const MaxConstants = 1 << 16
+
+// addConstant returns the index of obj in the constant pool, adding it
+// unless an equal integer, string or function is already there.
+func (c *Compiler) addConstant(obj object.Object) (int, error) {
+	key, internable := internKey(obj)
+	if internable {
+		if index, ok := c.interned[key]; ok {
+			return index, nil
+		}
+	}
+	if len(c.constants) >= MaxConstants {
+		return 0, fmt.Errorf("too many constants: a program may have at most %d", MaxConstants)
+	}
 	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
+	index := len(c.constants) - 1
+	if internable {
+		c.interned[key] = index
+	}
+	return index, nil
+}
+
+// constantKey identifies a constant by value.
+type constantKey struct {
+	typ   object.ObjectType
+	value string
+}
+
+// internKey returns the key under which obj is interned. Two functions
+// with the same key behave the same, since the constants their
+// instructions refer to are interned too. The line and local tables are
+// left out, so a function repeated on another line or with other
+// parameter names shares the constant, and the debug tables, of the
+// first.
+func internKey(obj object.Object) (constantKey, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constantKey{obj.Type(), strconv.FormatInt(obj.Value, 10)}, true
+	case *object.String:
+		return constantKey{obj.Type(), obj.Value}, true
+	case *object.CompiledFunction:
+		value := fmt.Sprintf("%s/%d/%d/%v/%x/%v",
+			obj.Name, obj.NumLocals, obj.NumParameters, obj.Handlers, []byte(obj.Instructions),
+			obj.Free)
+		return constantKey{obj.Type(), value}, true
+	}
+	return constantKey{}, false
 }
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
+	ins, err := code.MakeChecked(op, operands...)
+	if err != nil {
+		c.fail(op, err)
+		return len(c.currentInstructions())
+	}
 	pos := c.addInstruction(ins)
+	c.scopes[c.scopeIndex].depth += stackEffect(op, operands)
 
 	c.setLastInstruction(op, pos)
 	return pos
@@ -307,6 +739,12 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].depth++
+	for i, l := range c.scopes[c.scopeIndex].locals {
+		if l.End > len(new) {
+			c.scopes[c.scopeIndex].locals[i].End = len(new)
+		}
+	}
 }
 func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	if len(c.currentInstructions()) == 0 {
@@ -336,12 +774,79 @@ func (c *Compiler) replaceLastPopWithReturn() {
 	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
 	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
-func (c *Compiler) changeOperand(opPos int, operand int) {
+func (c *Compiler) changeOperand(opPos int, operands ...int) {
 	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+	newInstruction, err := code.MakeChecked(op, operands...)
+	if err != nil {
+		c.fail(op, err)
+		return
+	}
 	c.replaceInstruction(opPos, newInstruction)
 }
 
+// limits describes what the operand of each opcode counts and how many
+// of those a program may have, for the error reported past the limit.
+var limits = map[code.Opcode]struct {
+	what string
+	max  int
+}{
+	code.OpGetLocal:      {"local variables in a function", code.MaxOperand + 1},
+	code.OpSetLocal:      {"local variables in a function", code.MaxOperand + 1},
+	code.OpGetGlobal:     {"global variables", code.MaxOperand + 1},
+	code.OpSetGlobal:     {"global variables", code.MaxOperand + 1},
+	code.OpGetFree:       {"free variables in a function", code.MaxOperand + 1},
+	code.OpSetFree:       {"free variables in a function", code.MaxOperand + 1},
+	code.OpAssignLocal:   {"local variables in a function", code.MaxOperand + 1},
+	code.OpCaptureLocal:  {"local variables in a function", code.MaxOperand + 1},
+	code.OpCaptureFree:   {"free variables in a function", code.MaxOperand + 1},
+	code.OpClosure:       {"free variables in a function", code.MaxOperand},
+	code.OpCall:          {"arguments in a call", code.MaxOperand},
+	code.OpTailCall:      {"arguments in a call", code.MaxOperand},
+	code.OpArray:         {"elements in an array literal", code.MaxOperand},
+	code.OpTuple:         {"elements in a tuple literal", code.MaxOperand},
+	code.OpHash:          {"pairs in a hash literal", code.MaxOperand / 2},
+	code.OpJump:          {"bytes of instructions in a function", code.MaxOperand},
+	code.OpJumpNotTruthy: {"bytes of instructions in a function", code.MaxOperand},
+	code.OpPropagate:     {"bytes of instructions in a function", code.MaxOperand},
+	code.OpIterNext:      {"bytes of instructions in a function", code.MaxOperand},
+}
+
+// fail records the first operand the compiler cannot encode.
+func (c *Compiler) fail(op code.Opcode, err error) {
+	if c.err != nil {
+		return
+	}
+	if limit, ok := limits[op]; ok {
+		err = fmt.Errorf("too many %s: the limit is %d", limit.what, limit.max)
+	}
+	c.err = err
+}
+
+// stackEffect is the net number of values op pushes onto the stack.
+func stackEffect(op code.Opcode, operands []int) int {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree,
+		code.OpCurrentClosure, code.OpCaptureLocal, code.OpCaptureFree:
+		return 1
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan, code.OpIndex,
+		code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetLocal,
+		code.OpAssignLocal, code.OpSetFree, code.OpReturnValue, code.OpThrow:
+		return -1
+	case code.OpArray, code.OpTuple, code.OpHash:
+		return 1 - operands[0]
+	case code.OpCall, code.OpTailCall:
+		return -operands[0]
+	case code.OpClosure:
+		return 1 - operands[1]
+	case code.OpIterNext:
+		// While the loop goes on; it pops the loop when it ends.
+		return operands[1]
+	}
+	return 0
+}
+
 func (c *Compiler) enterScope() {
 	scope := CompilationScope{
 		instructions:        code.Instructions{},
@@ -353,6 +858,118 @@ func (c *Compiler) enterScope() {
 	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
 }
 
+// compileForIn compiles a for-in loop. The loop's state stays on the
+// stack under the body, which leaves it as it found it; the variables
+// live in a block around the body, like those of a for loop.
+func (c *Compiler) compileForIn(node *ast.ForInExpression) error {
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+	depth := c.scopes[c.scopeIndex].depth
+	c.enterBlock()
+	defer c.leaveBlock()
+	loopStart := len(c.currentInstructions())
+	c.markLine(node.Token.Line)
+	vars := len(node.Variables)
+	iterNextPos := c.emit(code.OpIterNext, 9999, vars)
+	symbols := make([]Symbol, vars)
+	for i, v := range node.Variables {
+		symbols[i] = c.symbolTable.Define(v.Value)
+	}
+	for i := vars - 1; i >= 0; i-- {
+		c.emit(code.OpSetLocal, symbols[i].Index)
+	}
+	for _, s := range symbols {
+		c.nameLocal(s)
+	}
+	if err := c.Compile(node.Consequence); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, loopStart)
+	c.changeOperand(iterNextPos, len(c.currentInstructions()), vars)
+	c.scopes[c.scopeIndex].depth = depth - 1
+	c.emit(code.OpNull)
+	return nil
+}
+
+// renewLocals binds the locals of the current block anew to their
+// values, as the next iteration of a loop does: the cells closures
+// captured them in stay with those closures.
+func (c *Compiler) renewLocals() {
+	var symbols []Symbol
+	for _, s := range c.symbolTable.store {
+		if s.Scope == LocalScope {
+			symbols = append(symbols, s)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Index < symbols[j].Index })
+	for _, s := range symbols {
+		c.emit(code.OpGetLocal, s.Index)
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// createsClosures reports whether any of nodes contains a function
+// literal.
+func createsClosures(nodes ...ast.Node) bool {
+	found := false
+	for _, node := range nodes {
+		if ast.IsNil(node) {
+			continue
+		}
+		ast.Inspect(node, func(n ast.Node) bool {
+			if _, ok := n.(*ast.FunctionLiteral); ok {
+				found = true
+			}
+			return !found
+		})
+	}
+	return found
+}
+
+// enterBlock opens the scope of a block within the current function.
+func (c *Compiler) enterBlock() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveBlock() {
+	c.closeLocals(c.symbolTable.Outer.nextLocal)
+	c.symbolTable = c.symbolTable.Outer
+}
+
+// markLine records that a statement on line starts at the next
+// instruction. Of the statements starting at the same instruction, the
+// innermost one gives the line.
+func (c *Compiler) markLine(line int) {
+	scope := &c.scopes[c.scopeIndex]
+	pos := len(scope.instructions)
+	if n := len(scope.lines); n > 0 && scope.lines[n-1].Pos == pos {
+		scope.lines[n-1].Line = line
+		return
+	}
+	scope.lines = append(scope.lines, code.Line{Pos: pos, Line: line})
+}
+
+// nameLocal records that the slot of the local s holds s.Name from the
+// next instruction on, until its block ends.
+func (c *Compiler) nameLocal(s Symbol) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.locals = append(scope.locals, code.Local{
+		Name: s.Name, Index: s.Index, Start: len(scope.instructions), End: -1,
+	})
+}
+
+// closeLocals ends the open ranges of the locals in slots first and up.
+func (c *Compiler) closeLocals(first int) {
+	scope := &c.scopes[c.scopeIndex]
+	for i, l := range scope.locals {
+		if l.End < 0 && l.Index >= first {
+			scope.locals[i].End = len(scope.instructions)
+		}
+	}
+}
+
 func (c *Compiler) leaveScope() code.Instructions {
 	instructions := c.currentInstructions()
 	c.scopes = c.scopes[:len(c.scopes)-1]
@@ -361,14 +978,37 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
+// Bytecode returns the compiled program. With Optimize set, the main
+// program is optimized in place and the constants folding left unused are
+// dropped.
 func (c *Compiler) Bytecode() *Bytecode {
+	if c.opts.Optimize {
+		c.optimize(&c.scopes[c.scopeIndex], true)
+		c.compactConstants()
+	}
+	scope := c.scopes[c.scopeIndex]
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: scope.instructions,
 		Constants:    c.constants,
+		Handlers:     scope.handlers,
+		NumLocals:    c.symbolTable.function().numLocals,
+		Lines:        scope.lines,
+		Locals:       scope.locals,
+		Globals:      c.symbolTable.function().globalNames(),
 	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	// Handlers is the exception table of Instructions.
+	Handlers []code.Handler
+	// NumLocals is the number of local slots of the main program, used by
+	// the variables of its blocks.
+	NumLocals int
+	// Lines and Locals are the debug information of Instructions, and
+	// Globals the names of the global slots.
+	Lines   []code.Line
+	Locals  []code.Local
+	Globals []string
 }
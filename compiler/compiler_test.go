@@ -2,6 +2,8 @@ package compiler
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/GhostNet-Dev/gscript/ast"
@@ -17,6 +19,245 @@ type compilerTestCase struct {
 	expectedInstructions []code.Instructions
 }
 
+func TestConstantInterning(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"key"; 1; "key"; 1; "other"`,
+			expectedConstants: []interface{}{"key", 1, "other"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { a + 1 }; fn(b) { b + 1 }; fn(a) { a + 2 }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+
+	// A REPL compiles each line with the constants of the previous ones.
+	symbolTable := NewSymbolTable()
+	var constants []object.Object
+	for i := 0; i < 3; i++ {
+		compiler := NewCompilerWithState(symbolTable, constants)
+		if err := compiler.Compile(parse(`"key" + "key"`)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		constants = compiler.Bytecode().Constants
+		if len(constants) != 1 {
+			t.Fatalf("line %d: wrong number of constants. want=1, got=%d", i, len(constants))
+		}
+	}
+}
+
+func TestTooManyConstants(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i <= MaxConstants; i++ {
+		fmt.Fprintf(&input, "%d;", i)
+	}
+	err := NewCompiler().Compile(parse(input.String()))
+	if err == nil {
+		t.Fatalf("expected a compile error")
+	}
+	want := fmt.Sprintf("too many constants: a program may have at most %d", MaxConstants)
+	if err.Error() != want {
+		t.Fatalf("wrong error. want=%q, got=%q", want, err)
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	args := strings.Repeat("0, ", code.MaxOperand) + "0"
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"len(" + args + ")", "too many arguments in a call: the limit is 65535"},
+		{"[" + args + "]", "too many elements in an array literal: the limit is 65535"},
+	}
+	for _, tt := range tests {
+		err := NewCompiler().Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("wrong error. want=%q, got=%v", tt.want, err)
+		}
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	input := "let n = 1;\nlet s: string = n + 1;"
+	if err := NewCompiler().Compile(parse(input)); err != nil {
+		t.Fatalf("compile error without TypeCheck: %s", err)
+	}
+	err := NewCompilerWithOptions(Options{TypeCheck: true}).Compile(parse(input))
+	cerr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("err is not *Error. got=%T (%v)", err, err)
+	}
+	if cerr.Token.Line != 2 || cerr.Message != "cannot use int as string in let s" {
+		t.Errorf("wrong error at line %d: %q", cerr.Token.Line, cerr.Message)
+	}
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "try { throw 1; } catch (e) { e }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpThrow),
+				// 0004
+				code.Make(code.OpJump, 15),
+				// 0007
+				code.Make(code.OpSetLocal, 0),
+				// 0009
+				code.Make(code.OpGetLocal, 0),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpJump, 15),
+			},
+		},
+		{
+			input:             "try { 1 } finally { 2 }",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpPop),
+				// 0004
+				code.Make(code.OpJump, 12),
+				// 0007
+				code.Make(code.OpConstant, 1),
+				// 0010
+				code.Make(code.OpPop),
+				// 0011
+				code.Make(code.OpThrow),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+
+	handlers := []struct {
+		input    string
+		expected []code.Handler
+	}{
+		{"try { throw 1; } catch (e) { e }", []code.Handler{{Start: 0, End: 4, Target: 7, Depth: 0}}},
+		{"try { 1 } finally { 2 }", []code.Handler{{Start: 0, End: 4, Target: 7, Depth: 0}}},
+		{"[1, if (true) { try { 2 } catch { 3 } }]", []code.Handler{{Start: 7, End: 11, Target: 14, Depth: 1}}},
+		{
+			"try { try { 1 } catch { 2 } } catch { 3 } finally { 4 }",
+			[]code.Handler{
+				{Start: 0, End: 4, Target: 7, Depth: 0},
+				{Start: 0, End: 15, Target: 18, Depth: 0},
+				{Start: 18, End: 23, Target: 26, Depth: 0},
+			},
+		},
+	}
+	for _, tt := range handlers {
+		compiler := NewCompiler()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		got := compiler.Bytecode().Handlers
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: wrong handlers.\nwant=%+v\ngot =%+v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestLoopsAndAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let a = 1; a = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "for (let i = 0; i > 1; i = 2) { 3 }",
+			expectedConstants: []interface{}{0, 1, 3, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetLocal, 0),
+				// 0005
+				code.Make(code.OpGetLocal, 0),
+				// 0007
+				code.Make(code.OpConstant, 1),
+				// 0010
+				code.Make(code.OpGreaterThan),
+				// 0011
+				code.Make(code.OpJumpNotTruthy, 29),
+				// 0014
+				code.Make(code.OpConstant, 2),
+				// 0017
+				code.Make(code.OpPop),
+				// 0018
+				code.Make(code.OpConstant, 3),
+				// 0021
+				code.Make(code.OpAssignLocal, 0),
+				// 0023
+				code.Make(code.OpGetLocal, 0),
+				// 0025
+				code.Make(code.OpPop),
+				// 0026
+				code.Make(code.OpJump, 5),
+				// 0029
+				code.Make(code.OpNull),
+				// 0030
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
 func TestRecursiveFunctions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -30,12 +271,12 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpSub),
 					code.Make(code.OpCall, 1),
 					code.Make(code.OpReturnValue),
-				}, 1,
+				},
 			}, expectedInstructions: []code.Instructions{
 				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpCall, 1),
 				code.Make(code.OpPop),
 			},
@@ -54,16 +295,16 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpSub),
 					code.Make(code.OpCall, 1),
 					code.Make(code.OpReturnValue),
-				}, 1, []code.Instructions{
+				}, []code.Instructions{
 					code.Make(code.OpClosure, 1, 0),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 2),
+					code.Make(code.OpConstant, 0),
 					code.Make(code.OpCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			}, expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -90,7 +331,7 @@ func TestClosures(t *testing.T) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -126,14 +367,14 @@ func TestClosures(t *testing.T) {
 				}, []code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				}, []code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -144,10 +385,43 @@ func TestClosures(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input: `
+			fn() {
+				let c = 0;
+				fn() { c = c + 1 }
+			}`, expectedConstants: []interface{}{
+				0, 1, []code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				}, []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpReturnValue),
+				},
+			}, expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 	runCompilerTests(t, tests)
 }
 
+func TestAssignToCapturedFunction(t *testing.T) {
+	input := "let f = fn() { let g = fn() { f = 1 }; g() };"
+	want := "cannot assign to function variable f"
+	if err := NewCompiler().Compile(parse(input)); err == nil || err.Error() != want {
+		t.Fatalf("wrong error. want=%q, got=%v", want, err)
+	}
+}
+
 func TestBuiltins(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -253,6 +527,104 @@ func TestLetStatementScopes(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(f) { return f(1); }",
+			expectedConstants: []interface{}{
+				1, []code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTailCall, 1),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// A try statement needs the frame until the call returns.
+			input: "fn(f) { try { return f(); } catch { 1 } }",
+			expectedConstants: []interface{}{
+				1, []code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpJump, 16),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpJump, 16),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "return len([]);",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestDebugInfo(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+  let y = x;
+  y
+};
+{ let b = a; b }`
+	bytecode := compileDebug(t, input, Options{})
+	wantLines := []code.Line{{Pos: 0, Line: 1}, {Pos: 6, Line: 2}, {Pos: 13, Line: 6}, {Pos: 18, Line: 6}}
+	if !reflect.DeepEqual(bytecode.Lines, wantLines) {
+		t.Errorf("wrong main lines. want=%v, got=%v", wantLines, bytecode.Lines)
+	}
+	wantLocals := []code.Local{{Name: "b", Index: 0, Start: 18, End: 21}}
+	if !reflect.DeepEqual(bytecode.Locals, wantLocals) {
+		t.Errorf("wrong main locals. want=%v, got=%v", wantLocals, bytecode.Locals)
+	}
+	if want := []string{"a", "f"}; !reflect.DeepEqual(bytecode.Globals, want) {
+		t.Errorf("wrong globals. want=%v, got=%v", want, bytecode.Globals)
+	}
+
+	fn := bytecode.Constants[1].(*object.CompiledFunction)
+	wantLines = []code.Line{{Pos: 0, Line: 3}, {Pos: 4, Line: 4}}
+	if !reflect.DeepEqual(fn.Lines, wantLines) {
+		t.Errorf("wrong function lines. want=%v, got=%v", wantLines, fn.Lines)
+	}
+	wantLocals = []code.Local{{Name: "x", Index: 0, Start: 0, End: 7}, {Name: "y", Index: 1, Start: 4, End: 7}}
+	if !reflect.DeepEqual(fn.Locals, wantLocals) {
+		t.Errorf("wrong function locals. want=%v, got=%v", wantLocals, fn.Locals)
+	}
+
+	// The optimizer moves the line table along with the instructions.
+	bytecode = compileDebug(t, "let a = 1 + 2;\nlet b = a;", Options{Optimize: true})
+	wantLines = []code.Line{{Pos: 0, Line: 1}, {Pos: 6, Line: 2}}
+	if !reflect.DeepEqual(bytecode.Lines, wantLines) {
+		t.Errorf("wrong optimized lines. want=%v, got=%v", wantLines, bytecode.Lines)
+	}
+}
+
+func compileDebug(t *testing.T, input string, opts Options) *Bytecode {
+	t.Helper()
+	compiler := NewCompilerWithOptions(opts)
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return compiler.Bytecode()
+}
+
 func TestFunctionCalls(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -443,14 +815,14 @@ func TestIndexExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1, 2, 3][1 + 1]",
-			expectedConstants: []interface{}{1, 2, 3, 1, 1},
+			expectedConstants: []interface{}{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -458,13 +830,13 @@ func TestIndexExpressions(t *testing.T) {
 		},
 		{
 			input:             "{1: 2}[2 - 1]",
-			expectedConstants: []interface{}{1, 2, 2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSub),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -560,6 +932,95 @@ func TestArrayLiterals(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestForInExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "for (x in [1]) { x }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpIterNext, 19, 1),
+				// 0011
+				code.Make(code.OpSetLocal, 0),
+				// 0013
+				code.Make(code.OpGetLocal, 0),
+				// 0015
+				code.Make(code.OpPop),
+				// 0016
+				code.Make(code.OpJump, 7),
+				// 0019
+				code.Make(code.OpNull),
+				// 0020
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let h = {}; for (k, v in h) { v }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpHash, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpIter),
+				// 0010
+				code.Make(code.OpIterNext, 24, 2),
+				// 0014
+				code.Make(code.OpSetLocal, 1),
+				// 0016
+				code.Make(code.OpSetLocal, 0),
+				// 0018
+				code.Make(code.OpGetLocal, 1),
+				// 0020
+				code.Make(code.OpPop),
+				// 0021
+				code.Make(code.OpJump, 10),
+				// 0024
+				code.Make(code.OpNull),
+				// 0025
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestTupleLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "(1, 2 + 3)",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpTuple, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "(1,)",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpTuple, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
 func TestStringExpressions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -690,11 +1151,11 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
 				code.Make(code.OpPop),
 			},
 		},
@@ -0,0 +1,32 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/parser"
+)
+
+func FuzzCompiler(f *testing.F) {
+	for _, seed := range []string{
+		"let x = 5; x + 10;",
+		"let f = fn(a, b) { if (a < b) { return a; } else { b } }; f(1, 2)",
+		`let h = {"a": [1, 2]}; h["a"][0]`,
+		"let n = 0; for (let i = 0; i < 3; i = i + 1) { if (i > 1) { n = n * 2; }; n = n + i; }; n",
+		"try { throw 1; } catch (e) { e } finally { 2 }",
+		"let f = fn() { let x = error(\"e\"); x?; 1 }; f()",
+		"let x: int = 1; let y: string = x;",
+		"1 / 0; if (true) { 1 } else { 2 }; !-3",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+		for _, opts := range []Options{{}, {Optimize: true}, {TypeCheck: true}} {
+			c := NewCompilerWithOptions(opts)
+			if err := c.Compile(program); err == nil {
+				c.Bytecode()
+			}
+		}
+	})
+}
@@ -0,0 +1,578 @@
+package compiler
+
+import (
+	"github.com/GhostNet-Dev/gscript/code"
+	"github.com/GhostNet-Dev/gscript/object"
+)
+
+// instruction is a decoded instruction. While the optimizer runs, jump
+// operands refer to instruction indices rather than byte positions.
+type instruction struct {
+	op       code.Opcode
+	operands []int
+	dead     bool
+}
+
+// optimizer rewrites the instructions of one function. Removed
+// instructions are only marked dead, so indices stay valid; a jump to a
+// dead instruction lands on the next live one.
+type optimizer struct {
+	c        *Compiler
+	ins      []instruction
+	handlers []code.Handler // Start, End and Target are instruction indices
+	lines    []code.Line    // Pos is an instruction index
+	locals   []code.Local   // Start and End are instruction indices
+	// keep is the index of an OpPop that must stay, or -1. The last value
+	// popped by the main program is its result.
+	keep   int
+	labels map[int]bool
+}
+
+// optimize runs the optimizer passes over the instructions of scope until
+// none applies: constant folding, branch elimination, OpPop elimination,
+// jump threading and dead code removal. The exception table, line table
+// and local names of scope are translated to the new positions.
+func (c *Compiler) optimize(scope *CompilationScope, main bool) {
+	o := &optimizer{c: c, keep: -1}
+
+	ins := scope.instructions
+	index := make(map[int]int)
+	for pos := 0; pos < len(ins); {
+		op, operands, read, err := code.ReadInstruction(ins[pos:])
+		if err != nil {
+			return
+		}
+		index[pos] = len(o.ins)
+		o.ins = append(o.ins, instruction{op: op, operands: operands})
+		pos += read
+	}
+	index[len(ins)] = len(o.ins)
+	for i := range o.ins {
+		if isJump(o.ins[i].op) {
+			o.ins[i].operands[0] = index[o.ins[i].operands[0]]
+		}
+	}
+	for _, h := range scope.handlers {
+		o.handlers = append(o.handlers, code.Handler{
+			Start: index[h.Start], End: index[h.End], Target: index[h.Target], Depth: h.Depth,
+		})
+	}
+	for _, l := range scope.lines {
+		o.lines = append(o.lines, code.Line{Pos: index[l.Pos], Line: l.Line})
+	}
+	for _, l := range scope.locals {
+		o.locals = append(o.locals, code.Local{
+			Name: l.Name, Index: l.Index, Start: index[l.Start], End: index[l.End],
+		})
+	}
+	if last := len(o.ins) - 1; main && last >= 0 && o.ins[last].op == code.OpPop {
+		o.keep = last
+	}
+
+	for changed := true; changed; {
+		changed = o.fold()
+		changed = o.thread() || changed
+		changed = o.removeDeadCode() || changed
+	}
+	o.encode(scope)
+}
+
+func isJump(op code.Opcode) bool {
+	return op == code.OpJump || op == code.OpJumpNotTruthy || op == code.OpPropagate ||
+		op == code.OpIterNext
+}
+
+// resolve returns the index of the first live instruction at or after i.
+func (o *optimizer) resolve(i int) int {
+	for i < len(o.ins) && o.ins[i].dead {
+		i++
+	}
+	return i
+}
+
+func (o *optimizer) next(i int) int {
+	return o.resolve(i + 1)
+}
+
+// findLabels marks the instructions control may arrive at other than by
+// falling through, which patterns must not merge across.
+func (o *optimizer) findLabels() {
+	o.labels = make(map[int]bool)
+	for _, ins := range o.ins {
+		if !ins.dead && isJump(ins.op) {
+			o.labels[o.resolve(ins.operands[0])] = true
+		}
+	}
+	for _, h := range o.handlers {
+		o.labels[o.resolve(h.Start)] = true
+		o.labels[o.resolve(h.End)] = true
+		o.labels[o.resolve(h.Target)] = true
+	}
+}
+
+func (o *optimizer) fold() bool {
+	changed := false
+	o.findLabels()
+	n := len(o.ins)
+	for i := o.resolve(0); i < n; i = o.resolve(i) {
+		j := o.next(i)
+		k := n
+		if j < n {
+			k = o.next(j)
+		}
+		switch {
+		case k < n && !o.labels[j] && !o.labels[k] && o.foldBinary(i, j, k):
+		case k < n && !o.labels[j] && !o.labels[k] && k != o.keep && o.foldAssignment(i, j, k):
+		case j < n && !o.labels[j] && o.foldUnary(i, j):
+		case j < n && !o.labels[j] && o.foldBranch(i, j):
+		case j < n && !o.labels[j] && j != o.keep && o.foldPop(i, j):
+		default:
+			i = j
+			continue
+		}
+		changed = true
+	}
+	return changed
+}
+
+// literal returns the value pushed by a constant-pushing instruction.
+func (o *optimizer) literal(i int) (object.Object, bool) {
+	switch o.ins[i].op {
+	case code.OpConstant:
+		switch constant := o.c.constants[o.ins[i].operands[0]].(type) {
+		case *object.Integer, *object.String:
+			return constant, true
+		}
+	case code.OpTrue:
+		return trueValue, true
+	case code.OpFalse:
+		return falseValue, true
+	case code.OpNull:
+		return nullValue, true
+	}
+	return nil, false
+}
+
+// Literal values the optimizer reasons about; the VM has its own.
+var (
+	trueValue  = &object.Boolean{Value: true}
+	falseValue = &object.Boolean{Value: false}
+	nullValue  = &object.Null{}
+)
+
+// push replaces instruction i with one pushing value. It fails if the
+// constant pool is full.
+func (o *optimizer) push(i int, value object.Object) bool {
+	switch value := value.(type) {
+	case *object.Boolean:
+		if value.Value {
+			o.ins[i] = instruction{op: code.OpTrue}
+		} else {
+			o.ins[i] = instruction{op: code.OpFalse}
+		}
+	default:
+		index, err := o.c.addConstant(value)
+		if err != nil {
+			return false
+		}
+		o.ins[i] = instruction{op: code.OpConstant, operands: []int{index}}
+	}
+	return true
+}
+
+func nativeBool(b bool) *object.Boolean {
+	if b {
+		return trueValue
+	}
+	return falseValue
+}
+
+// foldBinary evaluates a binary operator applied to two literals. Anything
+// that would fail at runtime is left alone.
+func (o *optimizer) foldBinary(i, j, k int) bool {
+	left, ok := o.literal(i)
+	if !ok {
+		return false
+	}
+	right, ok := o.literal(j)
+	if !ok {
+		return false
+	}
+	op := o.ins[k].op
+
+	var result object.Object
+	switch left := left.(type) {
+	case *object.Integer:
+		right, ok := right.(*object.Integer)
+		if !ok {
+			return false
+		}
+		switch op {
+		case code.OpAdd:
+			result = &object.Integer{Value: left.Value + right.Value}
+		case code.OpSub:
+			result = &object.Integer{Value: left.Value - right.Value}
+		case code.OpMul:
+			result = &object.Integer{Value: left.Value * right.Value}
+		case code.OpDiv:
+			if right.Value == 0 {
+				return false
+			}
+			result = &object.Integer{Value: left.Value / right.Value}
+		case code.OpEqual:
+			result = nativeBool(left.Value == right.Value)
+		case code.OpNotEqual:
+			result = nativeBool(left.Value != right.Value)
+		case code.OpGreaterThan:
+			result = nativeBool(left.Value > right.Value)
+		case code.OpLessThan:
+			result = nativeBool(left.Value < right.Value)
+		}
+	case *object.String:
+		right, ok := right.(*object.String)
+		if !ok {
+			return false
+		}
+		switch op {
+		case code.OpAdd:
+			result = &object.String{Value: left.Value + right.Value}
+		case code.OpEqual:
+			result = nativeBool(left.Value == right.Value)
+		case code.OpNotEqual:
+			result = nativeBool(left.Value != right.Value)
+		case code.OpGreaterThan:
+			result = nativeBool(left.Value > right.Value)
+		case code.OpLessThan:
+			result = nativeBool(left.Value < right.Value)
+		}
+	case *object.Boolean:
+		if _, ok := right.(*object.Boolean); !ok {
+			return false
+		}
+		switch op {
+		case code.OpEqual:
+			result = nativeBool(left == right)
+		case code.OpNotEqual:
+			result = nativeBool(left != right)
+		}
+	}
+	if result == nil {
+		return false
+	}
+	if !o.push(i, result) {
+		return false
+	}
+	o.ins[j].dead = true
+	o.ins[k].dead = true
+	return true
+}
+
+func (o *optimizer) foldUnary(i, j int) bool {
+	value, ok := o.literal(i)
+	if !ok {
+		return false
+	}
+	var result object.Object
+	switch o.ins[j].op {
+	case code.OpBang:
+		result = nativeBool(value == falseValue || value == nullValue)
+	case code.OpMinus:
+		integer, ok := value.(*object.Integer)
+		if !ok {
+			return false
+		}
+		result = &object.Integer{Value: -integer.Value}
+	default:
+		return false
+	}
+	if !o.push(i, result) {
+		return false
+	}
+	o.ins[j].dead = true
+	return true
+}
+
+// foldBranch removes a conditional jump on a literal, or makes it
+// unconditional.
+func (o *optimizer) foldBranch(i, j int) bool {
+	value, ok := o.literal(i)
+	if !ok || o.ins[j].op != code.OpJumpNotTruthy {
+		return false
+	}
+	if value == falseValue || value == nullValue {
+		target := o.ins[j].operands[0]
+		o.ins[i] = instruction{op: code.OpJump, operands: []int{target}}
+		o.labels[o.resolve(target)] = true
+	} else {
+		o.ins[i].dead = true
+	}
+	o.ins[j].dead = true
+	return true
+}
+
+// foldPop removes a value pushed only to be popped again.
+func (o *optimizer) foldPop(i, j int) bool {
+	if o.ins[j].op != code.OpPop {
+		return false
+	}
+	switch o.ins[i].op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree,
+		code.OpCurrentClosure:
+		o.ins[i].dead = true
+		o.ins[j].dead = true
+		return true
+	}
+	return false
+}
+
+// foldAssignment drops the value of an assignment used as a statement.
+func (o *optimizer) foldAssignment(i, j, k int) bool {
+	set, get := o.ins[i], o.ins[j]
+	if o.ins[k].op != code.OpPop {
+		return false
+	}
+	if !(set.op == code.OpSetGlobal && get.op == code.OpGetGlobal) &&
+		!(set.op == code.OpSetLocal && get.op == code.OpGetLocal) &&
+		!(set.op == code.OpAssignLocal && get.op == code.OpGetLocal) &&
+		!(set.op == code.OpSetFree && get.op == code.OpGetFree) {
+		return false
+	}
+	if set.operands[0] != get.operands[0] {
+		return false
+	}
+	o.ins[j].dead = true
+	o.ins[k].dead = true
+	return true
+}
+
+// thread points jumps to jumps at their final target and removes jumps to
+// the next instruction.
+func (o *optimizer) thread() bool {
+	changed := false
+	for i := range o.ins {
+		ins := &o.ins[i]
+		if ins.dead || !isJump(ins.op) {
+			continue
+		}
+		target := o.resolve(ins.operands[0])
+		seen := map[int]bool{i: true}
+		for target < len(o.ins) && o.ins[target].op == code.OpJump && !seen[target] {
+			seen[target] = true
+			target = o.resolve(o.ins[target].operands[0])
+		}
+		if seen[target] {
+			// A loop of jumps; leave it as it is.
+			continue
+		}
+		if target != ins.operands[0] {
+			ins.operands[0] = target
+			changed = true
+		}
+		if target != o.next(i) {
+			continue
+		}
+		switch ins.op {
+		case code.OpJump:
+			ins.dead = true
+			changed = true
+		case code.OpJumpNotTruthy:
+			// Either way control goes on here; only the pop remains.
+			*ins = instruction{op: code.OpPop}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeDeadCode removes the instructions control never reaches. A
+// handler's target is reachable once an instruction it covers is.
+func (o *optimizer) removeDeadCode() bool {
+	reachable := make([]bool, len(o.ins))
+	var visit func(i int)
+	visit = func(i int) {
+		for i = o.resolve(i); i < len(o.ins) && !reachable[i]; i = o.next(i) {
+			reachable[i] = true
+			ins := o.ins[i]
+			if isJump(ins.op) {
+				visit(ins.operands[0])
+			}
+			switch ins.op {
+			case code.OpJump, code.OpReturnValue, code.OpReturn, code.OpThrow, code.OpTailCall:
+				return
+			}
+		}
+	}
+	visit(0)
+	for found := true; found; {
+		found = false
+		for _, h := range o.handlers {
+			target := o.resolve(h.Target)
+			if target >= len(o.ins) || reachable[target] {
+				continue
+			}
+			for i := o.resolve(h.Start); i < h.End; i = o.next(i) {
+				if reachable[i] {
+					visit(target)
+					found = true
+					break
+				}
+			}
+		}
+	}
+
+	changed := false
+	for i := range o.ins {
+		if !o.ins[i].dead && !reachable[i] {
+			o.ins[i].dead = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// encode replaces the instructions of scope and the tables describing
+// them with the optimized ones.
+func (o *optimizer) encode(scope *CompilationScope) {
+	positions := make([]int, len(o.ins)+1)
+	pos := 0
+	for i, ins := range o.ins {
+		positions[i] = pos
+		if !ins.dead {
+			pos += len(code.Make(ins.op, ins.operands...))
+		}
+	}
+	positions[len(o.ins)] = pos
+	position := func(i int) int { return positions[o.resolve(i)] }
+
+	out := code.Instructions{}
+	for _, ins := range o.ins {
+		if ins.dead {
+			continue
+		}
+		operands := ins.operands
+		if isJump(ins.op) {
+			operands = append([]int{position(operands[0])}, operands[1:]...)
+		}
+		out = append(out, code.Make(ins.op, operands...)...)
+	}
+
+	var handlers []code.Handler
+	for _, h := range o.handlers {
+		start, end := position(h.Start), position(h.End)
+		if start >= end {
+			continue
+		}
+		handlers = append(handlers, code.Handler{Start: start, End: end, Target: position(h.Target), Depth: h.Depth})
+	}
+
+	var lines []code.Line
+	for _, l := range o.lines {
+		pos := position(l.Pos)
+		if n := len(lines); n > 0 && lines[n-1].Pos == pos {
+			// The statements before were removed entirely.
+			lines[n-1].Line = l.Line
+			continue
+		}
+		lines = append(lines, code.Line{Pos: pos, Line: l.Line})
+	}
+
+	var locals []code.Local
+	for _, l := range o.locals {
+		start, end := position(l.Start), position(l.End)
+		if start >= end {
+			continue
+		}
+		locals = append(locals, code.Local{Name: l.Name, Index: l.Index, Start: start, End: end})
+	}
+
+	scope.instructions, scope.handlers = out, handlers
+	scope.lines, scope.locals = lines, locals
+}
+
+// compactConstants drops the constants no instruction refers to any more,
+// such as the operands of folded expressions, and renumbers the rest in
+// the main program and the functions in the pool. Shared constants are
+// kept as they are.
+func (c *Compiler) compactConstants() {
+	used := make([]bool, len(c.constants))
+	var mark func(ins code.Instructions)
+	mark = func(ins code.Instructions) {
+		forEachConstant(ins, func(pos, index int) {
+			if index < c.shared || used[index] {
+				return
+			}
+			used[index] = true
+			if fn, ok := c.constants[index].(*object.CompiledFunction); ok {
+				mark(fn.Instructions)
+			}
+		})
+	}
+	main := &c.scopes[c.scopeIndex]
+	mark(main.instructions)
+
+	renumbered := make([]int, len(c.constants))
+	constants := c.constants[:c.shared:c.shared]
+	for i := range c.constants {
+		switch {
+		case i < c.shared:
+			renumbered[i] = i
+		case used[i]:
+			renumbered[i] = len(constants)
+			constants = append(constants, c.constants[i])
+		}
+	}
+	if len(constants) == len(c.constants) {
+		return
+	}
+
+	// Renumbering keeps the length of every instruction, so the
+	// positions in jumps and the debug tables stay valid.
+	renumber := func(ins code.Instructions) code.Instructions {
+		out := append(code.Instructions{}, ins...)
+		forEachConstant(ins, func(pos, index int) {
+			if index < c.shared {
+				return
+			}
+			op, operands, _, _ := code.ReadInstruction(ins[pos:])
+			operands[0] = renumbered[index]
+			copy(out[pos:], code.Make(op, operands...))
+		})
+		return out
+	}
+	main.instructions = renumber(main.instructions)
+	for _, constant := range constants[c.shared:] {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fn.Instructions = renumber(fn.Instructions)
+		}
+	}
+
+	c.constants = constants
+	for key, index := range c.interned {
+		if index >= c.shared {
+			delete(c.interned, key)
+		}
+	}
+	for i := c.shared; i < len(constants); i++ {
+		if key, ok := internKey(constants[i]); ok {
+			if _, seen := c.interned[key]; !seen {
+				c.interned[key] = i
+			}
+		}
+	}
+}
+
+// forEachConstant calls f with the position and constant index of every
+// instruction of ins that refers to a constant.
+func forEachConstant(ins code.Instructions, f func(pos, index int)) {
+	for pos := 0; pos < len(ins); {
+		op, operands, read, err := code.ReadInstruction(ins[pos:])
+		if err != nil {
+			return
+		}
+		if op == code.OpConstant || op == code.OpClosure {
+			f(pos, operands[0])
+		}
+		pos += read
+	}
+}
@@ -0,0 +1,176 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/code"
+	"github.com/GhostNet-Dev/gscript/object"
+)
+
+func TestOptimizer(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "!true; -5 < 2",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" + "b"; "a" == "a"`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" < "b"`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 / 0",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = if (1 > 2) { 10 };",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input:             "let a = 1; a = 2; a;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { return 1; 2; 3 }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "for (; false;) { 1 }; 2",
+			expectedConstants: []interface{}{2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Functions in the pool refer to the renumbered constants.
+			input: "let f = fn() { 1 + 2 }; 3 + f()",
+			expectedConstants: []interface{}{
+				3,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	for _, tt := range tests {
+		comp := NewCompilerWithOptions(Options{Optimize: true})
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		if err := testInstructions(tt.expectedInstructions, bytecode.Instructions); err != nil {
+			t.Fatalf("%q: testInstructions failed: %s", tt.input, err)
+		}
+		if err := testConstants(t, tt.expectedConstants, bytecode.Constants); err != nil {
+			t.Fatalf("%q: testConstants failed: %s", tt.input, err)
+		}
+	}
+}
+
+func TestOptimizerThreadsJumps(t *testing.T) {
+	inputs := []string{
+		"let x = true; let y = if (x) { if (x) { 1 } else { 2 } } else { 3 }; y",
+		"let f = fn(x) { if (x) { if (x) { if (x) { 1 } } } }; f(true)",
+		"let x = 0; for (; x < 3;) { if (x > 1) { x = x + 2 } else { x = x + 1 } }; x",
+	}
+	for _, input := range inputs {
+		comp := NewCompilerWithOptions(Options{Optimize: true})
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		all := []code.Instructions{bytecode.Instructions}
+		for _, constant := range bytecode.Constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				all = append(all, fn.Instructions)
+			}
+		}
+		for _, ins := range all {
+			for pos := 0; pos < len(ins); {
+				def, _ := code.Lookup(ins[pos])
+				operands, read := code.ReadOperands(def, ins[pos+1:])
+				op := code.Opcode(ins[pos])
+				if op == code.OpJump || op == code.OpJumpNotTruthy {
+					target := operands[0]
+					if target < len(ins) && code.Opcode(ins[target]) == code.OpJump {
+						t.Errorf("%q: jump at %d to a jump at %d\n%s", input, pos, target, ins)
+					}
+					if op == code.OpJump && target == pos+1+read {
+						t.Errorf("%q: jump at %d to the next instruction\n%s", input, pos, ins)
+					}
+				}
+				pos += 1 + read
+			}
+		}
+	}
+}
@@ -21,6 +21,15 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinitions int
 	FreeSymbols    []Symbol
+
+	// block is set on the table of a block, whose locals take slots of the
+	// enclosing function.
+	block bool
+	// nextLocal is the first local slot not taken by this table or the
+	// blocks around it; numLocals, on a function's table, is the most
+	// slots it ever had taken at once.
+	nextLocal int
+	numLocals int
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -35,20 +44,61 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return s
 }
 
+// NewBlockSymbolTable returns the table of a block inside outer's
+// function. The slots of its locals are free again once the block ends,
+// for the next block to reuse.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	s.block = true
+	s.nextLocal = outer.nextLocal
+	return s
+}
+
 func (s *SymbolTable) Define(name string) Symbol {
 	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: GlobalScope}
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
 	} else {
 		symbol.Scope = LocalScope
+		symbol.Index = s.nextLocal
+		s.nextLocal++
+		if fn := s.function(); fn.numLocals < s.nextLocal {
+			fn.numLocals = s.nextLocal
+		}
 	}
 	s.store[name] = symbol
 	s.numDefinitions++
 	return symbol
 }
 
+// function returns the table of the function s belongs to; for the
+// blocks of the main program, that is the global table.
+func (s *SymbolTable) function() *SymbolTable {
+	for s.block {
+		s = s.Outer
+	}
+	return s
+}
+
+// globalNames returns the names of the global slots of s, with "" for
+// the slots of shadowed globals.
+func (s *SymbolTable) globalNames() []string {
+	names := make([]string, s.numDefinitions)
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope && symbol.Index < len(names) {
+			names[symbol.Index] = symbol.Name
+		}
+	}
+	return names
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
+	if !ok && s.block {
+		// A block shares the locals and free variables of its function.
+		return s.Outer.Resolve(name)
+	}
 	if !ok && s.Outer != nil {
 		obj, ok = s.Outer.Resolve(name)
 		if !ok {
@@ -75,6 +125,17 @@ func (s *SymbolTable) DefineFunctionName(name string) Symbol {
 	return symbol
 }
 
+// origin returns the symbol a free variable of s refers to in the
+// function that defines it.
+func (s *SymbolTable) origin(free Symbol) Symbol {
+	fn := s.function()
+	original := fn.FreeSymbols[free.Index]
+	if original.Scope == FreeScope {
+		return fn.Outer.origin(original)
+	}
+	return original
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
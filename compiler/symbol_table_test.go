@@ -290,3 +290,43 @@ func TestResolveGlobal(t *testing.T) {
 		}
 	}
 }
+
+func TestDefineInBlocks(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	fn := NewEnclosedSymbolTable(global)
+	fn.Define("b")
+
+	first := NewBlockSymbolTable(fn)
+	expected := []Symbol{
+		first.Define("c"),
+		NewBlockSymbolTable(first).Define("d"),
+		// A sibling block reuses the slots of a block that has ended.
+		NewBlockSymbolTable(fn).Define("e"),
+		// The blocks of the main program take local slots too.
+		NewBlockSymbolTable(global).Define("f"),
+	}
+	want := []Symbol{
+		{Name: "c", Scope: LocalScope, Index: 1},
+		{Name: "d", Scope: LocalScope, Index: 2},
+		{Name: "e", Scope: LocalScope, Index: 1},
+		{Name: "f", Scope: LocalScope, Index: 0},
+	}
+	for i, symbol := range expected {
+		if symbol != want[i] {
+			t.Errorf("expected %+v, got=%+v", want[i], symbol)
+		}
+	}
+	if fn.numLocals != 3 {
+		t.Errorf("wrong numLocals. want=3, got=%d", fn.numLocals)
+	}
+
+	// Blocks resolve the locals of their function without making them free.
+	inner := NewBlockSymbolTable(NewBlockSymbolTable(fn))
+	if b, ok := inner.Resolve("b"); !ok || b != (Symbol{Name: "b", Scope: LocalScope, Index: 0}) {
+		t.Errorf("wrong symbol for b: %+v", b)
+	}
+	if len(fn.FreeSymbols) != 0 {
+		t.Errorf("unexpected free symbols: %+v", fn.FreeSymbols)
+	}
+}
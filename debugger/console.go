@@ -0,0 +1,207 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/GhostNet-Dev/gscript/compiler"
+	"github.com/GhostNet-Dev/gscript/object"
+	"github.com/GhostNet-Dev/gscript/vm"
+)
+
+const PROMPT = "(debug) "
+
+const help = `break N, b N      set a breakpoint on line N
+delete N, d N     delete the breakpoint on line N
+continue, c       run to the next breakpoint
+step, s           step to the next statement, into calls
+next, n           step to the next statement, over calls
+out, o            run until the current function returns
+print NAME, p     print the variable NAME
+locals, l         print the variables of the current function
+globals, g        print the global variables
+stack             print the values on the stack, top first
+frames, bt        print the frame chain, innermost first
+quit, q           stop the program
+`
+
+// console reads debugger commands from in while the program is paused.
+type console struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+	name    string
+	lines   []string
+	session *Session
+	// statements are the lines breakpoints can be set on.
+	statements map[int]bool
+}
+
+// Start runs bytecode, compiled from the file name holding source, under
+// an interactive debugger reading commands from in. It stops before the
+// first statement so breakpoints can be set. It returns the error the
+// program failed with, or nil if it finished or the user quit.
+func Start(in io.Reader, out io.Writer, name, source string, bytecode *compiler.Bytecode) error {
+	c := &console{
+		scanner:    bufio.NewScanner(in),
+		out:        out,
+		name:       name,
+		lines:      strings.Split(source, "\n"),
+		statements: make(map[int]bool),
+	}
+	for _, line := range StatementLines(bytecode) {
+		c.statements[line] = true
+	}
+	c.session = NewSession(c.pause)
+	c.session.StopOnEntry = true
+
+	machine := vm.NewVMWithOptions(bytecode, vm.Options{Debugger: c.session})
+	err := machine.Run()
+	if err == ErrStopped {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "program finished")
+	return nil
+}
+
+func (c *console) pause(machine *vm.VM, reason Reason) error {
+	frames := machine.Frames()
+	frame := frames[len(frames)-1]
+	if reason == Breakpoint {
+		fmt.Fprintf(c.out, "breakpoint at ")
+	}
+	fmt.Fprintf(c.out, "%s:%d: %s\n", c.name, frame.Line(), c.source(frame.Line()))
+
+	for {
+		fmt.Fprint(c.out, PROMPT)
+		if !c.scanner.Scan() {
+			return ErrStopped
+		}
+		fields := strings.Fields(c.scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "continue", "c":
+			c.session.Continue()
+			return nil
+		case "step", "s":
+			c.session.StepInto()
+			return nil
+		case "next", "n":
+			c.session.StepOver()
+			return nil
+		case "out", "o":
+			c.session.StepOut()
+			return nil
+		case "quit", "q":
+			return ErrStopped
+		case "break", "b":
+			if line, ok := c.line(fields); ok {
+				c.session.SetBreakpoint(line)
+				fmt.Fprintf(c.out, "breakpoint set on line %d\n", line)
+			}
+		case "delete", "d":
+			if line, ok := c.line(fields); ok {
+				c.session.ClearBreakpoint(line)
+				fmt.Fprintf(c.out, "breakpoint deleted on line %d\n", line)
+			}
+		case "print", "p":
+			if len(fields) != 2 {
+				fmt.Fprintln(c.out, "usage: print NAME")
+				continue
+			}
+			c.print(machine, frame, fields[1])
+		case "locals", "l":
+			c.printVariables(machine.Locals(frame))
+		case "globals", "g":
+			c.printVariables(machine.Globals())
+		case "stack":
+			stack := machine.Stack()
+			for i := len(stack) - 1; i >= 0; i-- {
+				fmt.Fprintf(c.out, "%4d  %s\n", i, inspect(stack[i]))
+			}
+		case "frames", "bt":
+			for i := len(frames) - 1; i >= 0; i-- {
+				fmt.Fprintf(c.out, "#%d  %s at %s:%d\n", len(frames)-1-i, frameName(frames, i), c.name, frames[i].Line())
+			}
+		case "help", "h":
+			fmt.Fprint(c.out, help)
+		default:
+			fmt.Fprintf(c.out, "unknown command %q; try help\n", fields[0])
+		}
+	}
+}
+
+// line parses the line number argument of a breakpoint command.
+func (c *console) line(fields []string) (int, bool) {
+	if len(fields) != 2 {
+		fmt.Fprintf(c.out, "usage: %s N\n", fields[0])
+		return 0, false
+	}
+	line, err := strconv.Atoi(fields[1])
+	if err != nil {
+		fmt.Fprintf(c.out, "bad line number %q\n", fields[1])
+		return 0, false
+	}
+	if !c.statements[line] && fields[0] != "delete" && fields[0] != "d" {
+		fmt.Fprintf(c.out, "no statement starts on line %d\n", line)
+		return 0, false
+	}
+	return line, true
+}
+
+func (c *console) print(machine *vm.VM, frame *vm.Frame, name string) {
+	for _, vars := range [][]vm.Variable{machine.Locals(frame), machine.Globals()} {
+		for _, v := range vars {
+			if v.Name == name {
+				fmt.Fprintf(c.out, "%s = %s\n", v.Name, inspect(v.Value))
+				return
+			}
+		}
+	}
+	fmt.Fprintf(c.out, "no variable %s\n", name)
+}
+
+func (c *console) printVariables(vars []vm.Variable) {
+	for _, v := range vars {
+		fmt.Fprintf(c.out, "%s = %s\n", v.Name, inspect(v.Value))
+	}
+}
+
+// source returns the text of line, without surrounding whitespace.
+func (c *console) source(line int) string {
+	if line < 1 || line > len(c.lines) {
+		return ""
+	}
+	return strings.TrimSpace(c.lines[line-1])
+}
+
+func frameName(frames []*vm.Frame, i int) string {
+	switch {
+	case i == 0:
+		return "<main>"
+	case frames[i].Name() == "":
+		return "<fn>"
+	}
+	return frames[i].Name()
+}
+
+// inspect shows a value; a local slot not set yet holds nil.
+func inspect(obj object.Object) string {
+	switch obj := obj.(type) {
+	case nil:
+		return "<unset>"
+	case *object.Closure:
+		if obj.Fn.Name != "" {
+			return "fn " + obj.Fn.Name
+		}
+		return "fn"
+	}
+	return obj.Inspect()
+}
@@ -0,0 +1,492 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/GhostNet-Dev/gscript/compiler"
+	"github.com/GhostNet-Dev/gscript/internal/framing"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/object"
+	"github.com/GhostNet-Dev/gscript/parser"
+	"github.com/GhostNet-Dev/gscript/vm"
+)
+
+// threadID is the one thread a program has.
+const threadID = 1
+
+// request, response and event are the messages of the Debug Adapter
+// Protocol.
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// The program reports to the server with these, from its own goroutine.
+type (
+	stopped struct {
+		machine *vm.VM
+		reason  Reason
+	}
+	output string
+	exited struct{ err error }
+)
+
+// dapServer debugs one program for a client of the Debug Adapter
+// Protocol. Only the goroutine of ServeDAP touches it; the program runs
+// on another and talks to it through events and resume.
+type dapServer struct {
+	out io.Writer
+	seq int
+
+	program  string
+	bytecode *compiler.Bytecode
+	// statements are the lines breakpoints can be set on.
+	statements  map[int]bool
+	stopOnEntry bool
+	session     *Session
+
+	running bool
+	events  chan interface{}
+	resume  chan error
+
+	// machine and frames are those of the paused program, or nil while it
+	// runs. refs are the variables listed by the variablesReference i+1;
+	// they are only valid until it resumes.
+	machine *vm.VM
+	frames  []*vm.Frame
+	refs    [][]vm.Variable
+}
+
+// ServeDAP speaks the Debug Adapter Protocol on in and out, as an editor's
+// debug adapter: it launches the program the client names, and stops and
+// steps it as the client asks. It returns once the client disconnects or
+// in ends.
+func ServeDAP(in io.Reader, out io.Writer) error {
+	s := &dapServer{
+		out:    out,
+		events: make(chan interface{}),
+		resume: make(chan error),
+	}
+
+	requests := make(chan request)
+	done := make(chan struct{})
+	defer close(done)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(requests)
+		r := bufio.NewReader(in)
+		for {
+			data, err := framing.Read(r)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			var req request
+			if err := json.Unmarshal(data, &req); err != nil {
+				readErr <- fmt.Errorf("bad message: %w", err)
+				return
+			}
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				s.stop()
+				if err := <-readErr; err != io.EOF {
+					return err
+				}
+				return nil
+			}
+			if s.handle(req) {
+				return nil
+			}
+		case e := <-s.events:
+			s.report(e)
+		}
+	}
+}
+
+// handle answers req, reporting whether the session is over.
+func (s *dapServer) handle(req request) bool {
+	var (
+		body interface{}
+		err  error
+	)
+	switch req.Command {
+	case "initialize":
+		body = map[string]interface{}{"supportsConfigurationDoneRequest": true}
+	case "launch":
+		err = s.launch(req.Arguments)
+		if err == nil {
+			s.respond(req, nil, nil)
+			s.send("initialized", nil)
+			return false
+		}
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req.Arguments)
+	case "configurationDone":
+		err = s.start()
+		if err == nil {
+			// The program may stop only once the client knows it is done.
+			s.respond(req, nil, nil)
+			go s.run()
+			return false
+		}
+	case "threads":
+		body = map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": threadID, "name": "main"}},
+		}
+	case "stackTrace":
+		body, err = s.stackTrace()
+	case "scopes":
+		body, err = s.scopes(req.Arguments)
+	case "variables":
+		body, err = s.variables(req.Arguments)
+	case "continue", "next", "stepIn", "stepOut":
+		if s.machine == nil {
+			err = fmt.Errorf("the program is not paused")
+			break
+		}
+		switch req.Command {
+		case "continue":
+			s.session.Continue()
+			body = map[string]interface{}{"allThreadsContinued": true}
+		case "next":
+			s.session.StepOver()
+		case "stepIn":
+			s.session.StepInto()
+		case "stepOut":
+			s.session.StepOut()
+		}
+		s.respond(req, body, nil)
+		s.machine, s.frames, s.refs = nil, nil, nil
+		s.resume <- nil
+		return false
+	case "terminate":
+		// The program reports that it exited as it stops.
+		s.terminate()
+	case "disconnect":
+		s.stop()
+		s.respond(req, nil, nil)
+		return true
+	default:
+		err = fmt.Errorf("unsupported request %s", req.Command)
+	}
+	s.respond(req, body, err)
+	return false
+}
+
+// launch compiles the program named by the launch arguments.
+func (s *dapServer) launch(arguments json.RawMessage) error {
+	var args struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return err
+	}
+	if s.bytecode != nil {
+		return fmt.Errorf("a program is already launched")
+	}
+	input, err := os.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	p := parser.NewParser(lexer.NewLexer(string(input)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return fmt.Errorf("%s: %s", args.Program, strings.Join(p.Errors(), "; "))
+	}
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(program); err != nil {
+		return fmt.Errorf("compilation failed: %w", err)
+	}
+
+	s.program = args.Program
+	s.bytecode = comp.Bytecode()
+	s.statements = make(map[int]bool)
+	for _, line := range StatementLines(s.bytecode) {
+		s.statements[line] = true
+	}
+	s.stopOnEntry = args.StopOnEntry
+	s.session = NewSession(func(machine *vm.VM, reason Reason) error {
+		s.events <- stopped{machine, reason}
+		return <-s.resume
+	})
+	return nil
+}
+
+// setBreakpoints replaces the breakpoints of the program; those in other
+// sources or on lines without a statement are not verified.
+func (s *dapServer) setBreakpoints(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	if s.session == nil {
+		return nil, fmt.Errorf("no program is launched")
+	}
+
+	ours := samePath(args.Source.Path, s.program)
+	if ours {
+		s.session.ClearBreakpoints()
+	}
+	breakpoints := []map[string]interface{}{}
+	for _, bp := range args.Breakpoints {
+		result := map[string]interface{}{"line": bp.Line, "verified": false}
+		switch {
+		case !ours:
+			result["message"] = "not part of the program"
+		case !s.statements[bp.Line]:
+			result["message"] = "no statement starts on this line"
+		default:
+			s.session.SetBreakpoint(bp.Line)
+			result["verified"] = true
+		}
+		breakpoints = append(breakpoints, result)
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+func (s *dapServer) start() error {
+	if s.session == nil {
+		return fmt.Errorf("no program is launched")
+	}
+	if s.running {
+		return fmt.Errorf("the program is already running")
+	}
+	s.running = true
+	s.session.StopOnEntry = s.stopOnEntry
+	return nil
+}
+
+// run runs the program on its own goroutine.
+func (s *dapServer) run() {
+	opts := vm.Options{Debugger: s.session, Stdout: outputWriter(s.events)}
+	err := vm.NewVMWithOptions(s.bytecode, opts).Run()
+	s.events <- exited{err}
+}
+
+// outputWriter sends what the program prints to the client.
+type outputWriter chan<- interface{}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w <- output(p)
+	return len(p), nil
+}
+
+// report passes on an event of the program to the client.
+func (s *dapServer) report(e interface{}) {
+	switch e := e.(type) {
+	case stopped:
+		s.machine, s.frames, s.refs = e.machine, e.machine.Frames(), nil
+		s.send("stopped", map[string]interface{}{
+			"reason": string(e.reason), "threadId": threadID, "allThreadsStopped": true,
+		})
+	case output:
+		s.send("output", map[string]interface{}{"category": "stdout", "output": string(e)})
+	case exited:
+		s.running = false
+		s.machine, s.frames, s.refs = nil, nil, nil
+		exitCode := 0
+		if e.err != nil {
+			exitCode = 1
+			if e.err != ErrStopped {
+				s.send("output", map[string]interface{}{"category": "stderr", "output": e.err.Error() + "\n"})
+			}
+		}
+		s.send("exited", map[string]interface{}{"exitCode": exitCode})
+		s.send("terminated", nil)
+	}
+}
+
+// terminate makes a running program stop.
+func (s *dapServer) terminate() {
+	if !s.running {
+		return
+	}
+	s.session.Stop()
+	if s.machine != nil {
+		s.machine, s.frames, s.refs = nil, nil, nil
+		s.resume <- ErrStopped
+	}
+}
+
+// stop ends a running program without telling the client.
+func (s *dapServer) stop() {
+	s.terminate()
+	for s.running {
+		switch (<-s.events).(type) {
+		case stopped:
+			s.resume <- ErrStopped
+		case exited:
+			s.running = false
+		}
+	}
+}
+
+func (s *dapServer) stackTrace() (interface{}, error) {
+	if s.machine == nil {
+		return nil, fmt.Errorf("the program is not paused")
+	}
+	source := map[string]interface{}{"name": filepath.Base(s.program), "path": s.program}
+	frames := []map[string]interface{}{}
+	for i := len(s.frames) - 1; i >= 0; i-- {
+		frames = append(frames, map[string]interface{}{
+			"id":     len(s.frames) - i,
+			"name":   frameName(s.frames, i),
+			"line":   s.frames[i].Line(),
+			"column": 1,
+			"source": source,
+		})
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// scopes lists the locals and the globals of a frame, numbered from the
+// innermost one up from 1 as in stackTrace.
+func (s *dapServer) scopes(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	if s.machine == nil {
+		return nil, fmt.Errorf("the program is not paused")
+	}
+	if args.FrameID < 1 || args.FrameID > len(s.frames) {
+		return nil, fmt.Errorf("no frame %d", args.FrameID)
+	}
+	frame := s.frames[len(s.frames)-args.FrameID]
+	scope := func(name string, vars []vm.Variable) map[string]interface{} {
+		return map[string]interface{}{"name": name, "variablesReference": s.reference(vars), "expensive": false}
+	}
+	return map[string]interface{}{"scopes": []map[string]interface{}{
+		scope("Locals", s.machine.Locals(frame)),
+		scope("Globals", s.machine.Globals()),
+	}}, nil
+}
+
+func (s *dapServer) variables(arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	if s.machine == nil {
+		return nil, fmt.Errorf("the program is not paused")
+	}
+	ref := args.VariablesReference
+	if ref < 1 || ref > len(s.refs) {
+		return nil, fmt.Errorf("no variables %d", ref)
+	}
+	variables := []map[string]interface{}{}
+	for _, v := range s.refs[ref-1] {
+		variables = append(variables, map[string]interface{}{
+			"name":               v.Name,
+			"value":              inspect(v.Value),
+			"type":               string(v.Value.Type()),
+			"variablesReference": s.reference(elements(v.Value)),
+		})
+	}
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+// reference returns the variablesReference listing vars, or 0 if there
+// are none.
+func (s *dapServer) reference(vars []vm.Variable) int {
+	if len(vars) == 0 {
+		return 0
+	}
+	s.refs = append(s.refs, vars)
+	return len(s.refs)
+}
+
+// elements returns the elements of an array, tuple or hash, named by
+// index or key.
+func elements(obj object.Object) []vm.Variable {
+	var vars []vm.Variable
+	switch obj := obj.(type) {
+	case *object.Array:
+		for i, e := range obj.Elements {
+			vars = append(vars, vm.Variable{Name: "[" + strconv.Itoa(i) + "]", Value: e})
+		}
+	case *object.Tuple:
+		for i, e := range obj.Elements {
+			vars = append(vars, vm.Variable{Name: "[" + strconv.Itoa(i) + "]", Value: e})
+		}
+	case *object.Hash:
+		for _, pair := range obj.Pairs() {
+			vars = append(vars, vm.Variable{Name: pair.Key.Inspect(), Value: pair.Value})
+		}
+	}
+	return vars
+}
+
+func (s *dapServer) respond(req request, body interface{}, err error) {
+	s.seq++
+	resp := response{
+		Seq: s.seq, Type: "response", RequestSeq: req.Seq,
+		Success: err == nil, Command: req.Command, Body: body,
+	}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	framing.Write(s.out, resp)
+}
+
+func (s *dapServer) send(name string, body interface{}) {
+	s.seq++
+	framing.Write(s.out, event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
@@ -0,0 +1,94 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GhostNet-Dev/gscript/internal/framing"
+)
+
+// TestDAP replays the sessions recorded in testdata: it sends the requests
+// in turn and expects the server to answer with the responses and events
+// that follow each of them.
+func TestDAP(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.dap.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no recorded sessions: %v", err)
+	}
+	for _, file := range files {
+		replay(t, file)
+	}
+}
+
+func replay(t *testing.T, file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []map[string]interface{}
+	if err := json.Unmarshal(data, &messages); err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeDAP(inR, outW)
+		outW.Close()
+	}()
+	defer inW.Close()
+	defer outR.Close()
+
+	received := make(chan map[string]interface{})
+	go func() {
+		defer close(received)
+		r := bufio.NewReader(outR)
+		for {
+			data, err := framing.Read(r)
+			if err != nil {
+				return
+			}
+			var msg map[string]interface{}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Errorf("%s: bad message %q", file, data)
+				return
+			}
+			received <- msg
+		}
+	}()
+
+	for i, want := range messages {
+		if want["type"] == "request" {
+			if err := framing.Write(inW, want); err != nil {
+				t.Fatalf("%s: sending message %d: %s", file, i, err)
+			}
+			continue
+		}
+		select {
+		case got, ok := <-received:
+			if !ok {
+				t.Fatalf("%s: server stopped before message %d", file, i)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: wrong message %d.\nwant=%v\ngot=%v", file, i, want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: timed out waiting for message %d", file, i)
+		}
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("%s: server error: %s", file, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: server did not finish", file)
+	}
+}
@@ -0,0 +1,169 @@
+package debugger
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/compiler"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/parser"
+	"github.com/GhostNet-Dev/gscript/vm"
+)
+
+const program = `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let total = 0;
+for (let i = 0; i < 2; i = i + 1) {
+  total = add(total, i);
+}
+total;`
+
+// stop is where a program stopped, and why.
+type stop struct {
+	line   int
+	depth  int
+	reason Reason
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		name        string
+		breakpoints []int
+		actions     []func(*Session)
+		expected    []stop
+	}{
+		{
+			name: "step over",
+			actions: []func(*Session){
+				(*Session).StepOver, (*Session).StepOver, (*Session).StepOver,
+				(*Session).StepOver, (*Session).StepOver, (*Session).StepOver,
+			},
+			expected: []stop{
+				{1, 1, Entry}, {5, 1, Step}, {6, 1, Step}, {7, 1, Step},
+				{6, 1, Step}, {7, 1, Step}, {6, 1, Step},
+			},
+		},
+		{
+			name: "step into and out",
+			actions: []func(*Session){
+				(*Session).Continue, (*Session).StepInto, (*Session).StepInto,
+				(*Session).StepOut, (*Session).StepOver,
+			},
+			breakpoints: []int{7},
+			expected: []stop{
+				{1, 1, Entry}, {7, 1, Breakpoint}, {2, 2, Step}, {3, 2, Step},
+				{7, 1, Step}, {6, 1, Step}, {7, 1, Breakpoint},
+			},
+		},
+		{
+			name:        "breakpoints",
+			breakpoints: []int{3},
+			actions: []func(*Session){
+				(*Session).Continue, (*Session).Continue, (*Session).Continue,
+			},
+			expected: []stop{{1, 1, Entry}, {3, 2, Breakpoint}, {3, 2, Breakpoint}},
+		},
+	}
+
+	for _, tt := range tests {
+		var stops []stop
+		session := NewSession(nil)
+		session.StopOnEntry = true
+		session.Pause = func(machine *vm.VM, reason Reason) error {
+			frames := machine.Frames()
+			stops = append(stops, stop{frames[len(frames)-1].Line(), len(frames), reason})
+			if len(stops) <= len(tt.actions) {
+				tt.actions[len(stops)-1](session)
+			}
+			return nil
+		}
+		for _, line := range tt.breakpoints {
+			session.SetBreakpoint(line)
+		}
+		machine := vm.NewVMWithOptions(compile(t, program), vm.Options{Debugger: session})
+		if err := machine.Run(); err != nil {
+			t.Fatalf("%s: vm error: %s", tt.name, err)
+		}
+		if !reflect.DeepEqual(stops, tt.expected) {
+			t.Errorf("%s: wrong stops.\nwant=%v\ngot=%v", tt.name, tt.expected, stops)
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	session := NewSession(nil)
+	session.SetBreakpoint(3)
+	var locals, globals []vm.Variable
+	session.Pause = func(machine *vm.VM, reason Reason) error {
+		frames := machine.Frames()
+		locals = machine.Locals(frames[len(frames)-1])
+		globals = machine.Globals()
+		session.ClearBreakpoint(3)
+		return nil
+	}
+	machine := vm.NewVMWithOptions(compile(t, program), vm.Options{Debugger: session})
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	names := func(vars []vm.Variable) string {
+		var out []string
+		for _, v := range vars {
+			out = append(out, v.Name+"="+v.Value.Inspect())
+		}
+		return strings.Join(out, " ")
+	}
+	if got, want := names(locals), "a=0 b=0 sum=0"; got != want {
+		t.Errorf("wrong locals. want=%q, got=%q", want, got)
+	}
+	if got, want := names(globals[1:]), "total=0"; got != want {
+		t.Errorf("wrong globals. want=%q, got=%q", want, got)
+	}
+}
+
+func TestConsole(t *testing.T) {
+	input := strings.Join([]string{
+		"b 4", "b 3", "c", "p sum", "p total", "p nothing", "bt",
+		"d 3", "o", "n", "l", "c",
+	}, "\n")
+	var out bytes.Buffer
+	if err := Start(strings.NewReader(input), &out, "add.gs", program, compile(t, program)); err != nil {
+		t.Fatalf("debugger error: %s", err)
+	}
+	expected := `add.gs:1: let add = fn(a, b) {
+(debug) no statement starts on line 4
+(debug) breakpoint set on line 3
+(debug) breakpoint at add.gs:3: sum
+(debug) sum = 0
+(debug) total = 0
+(debug) no variable nothing
+(debug) #0  add at add.gs:3
+#1  <main> at add.gs:7
+(debug) breakpoint deleted on line 3
+(debug) add.gs:7: total = add(total, i);
+(debug) add.gs:6: for (let i = 0; i < 2; i = i + 1) {
+(debug) i = 1
+(debug) program finished
+`
+	if out.String() != expected {
+		t.Errorf("wrong output.\nwant=%q\ngot=%q", expected, out.String())
+	}
+}
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
@@ -0,0 +1,211 @@
+// Package debugger runs compiled programs under control: it stops them at
+// breakpoints and after steps, one statement at a time, and hands the
+// paused VM to a callback to inspect. Start debugs a program from a
+// terminal, and ServeDAP from an editor.
+package debugger
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/GhostNet-Dev/gscript/code"
+	"github.com/GhostNet-Dev/gscript/compiler"
+	"github.com/GhostNet-Dev/gscript/object"
+	"github.com/GhostNet-Dev/gscript/vm"
+)
+
+// Reason tells why a program stopped.
+type Reason string
+
+const (
+	Entry      Reason = "entry"
+	Step       Reason = "step"
+	Breakpoint Reason = "breakpoint"
+)
+
+// ErrStopped is the error a program stopped by the debugger fails with.
+var ErrStopped = errors.New("stopped by the debugger")
+
+type mode int
+
+const (
+	continuing mode = iota
+	steppingInto
+	steppingOver
+	steppingOut
+)
+
+// Session is a vm.Debugger. Programs stop only where a statement starts
+// a new line: one on another line than the statement before, or the same
+// line entered again, as a loop does. A step also ends when the function
+// it started in returns. Breakpoints may be changed and Stop called while
+// the program runs.
+type Session struct {
+	// Pause is called with the VM whenever the program stops. It picks
+	// how the program goes on by calling Continue or one of the Step
+	// methods before it returns; by default it continues. An error stops
+	// the program.
+	Pause func(machine *vm.VM, reason Reason) error
+	// StopOnEntry stops the program before its first statement.
+	StopOnEntry bool
+
+	mu          sync.Mutex
+	breakpoints map[int]bool
+	stopped     bool
+
+	started bool
+	entry   bool
+	mode    mode
+	// depth is the number of frames when the program last stopped.
+	depth int
+	// frame, line and ip locate the last statement started.
+	frame *vm.Frame
+	line  int
+	ip    int
+}
+
+func NewSession(pause func(machine *vm.VM, reason Reason) error) *Session {
+	return &Session{Pause: pause, breakpoints: make(map[int]bool)}
+}
+
+func (s *Session) SetBreakpoint(line int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breakpoints[line] = true
+}
+
+func (s *Session) ClearBreakpoint(line int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.breakpoints, line)
+}
+
+// ClearBreakpoints deletes every breakpoint.
+func (s *Session) ClearBreakpoints() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breakpoints = make(map[int]bool)
+}
+
+// Breakpoints returns the lines with a breakpoint, in order.
+func (s *Session) Breakpoints() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := make([]int, 0, len(s.breakpoints))
+	for line := range s.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Continue runs the program until the next breakpoint.
+func (s *Session) Continue() { s.mode = continuing }
+
+// StepInto stops at the next statement, in whatever function it is.
+func (s *Session) StepInto() { s.mode = steppingInto }
+
+// StepOver stops at the next statement of the current function or one of
+// its callers, running through calls made before it.
+func (s *Session) StepOver() { s.mode = steppingOver }
+
+// StepOut stops once the current function returns.
+func (s *Session) StepOut() { s.mode = steppingOut }
+
+// Stop makes the program fail with ErrStopped before its next
+// instruction.
+func (s *Session) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+}
+
+func (s *Session) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+func (s *Session) hasBreakpoint(line int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.breakpoints[line]
+}
+
+func (s *Session) Before(machine *vm.VM) error {
+	if s.isStopped() {
+		return ErrStopped
+	}
+	if !s.started {
+		s.started = true
+		if s.StopOnEntry {
+			s.mode = steppingInto
+			s.entry = true
+		}
+	}
+	frames := machine.Frames()
+	depth := len(frames)
+	reason, stop := s.stopAt(frames[depth-1], depth)
+	if !stop {
+		return nil
+	}
+	if s.entry {
+		s.entry = false
+		reason = Entry
+	}
+	s.mode = continuing
+	s.depth = depth
+	if s.Pause == nil {
+		return nil
+	}
+	return s.Pause(machine, reason)
+}
+
+// stopAt reports whether the program stops before the instruction frame
+// is at, depth frames deep.
+func (s *Session) stopAt(frame *vm.Frame, depth int) (Reason, bool) {
+	if s.mode != continuing && depth < s.depth {
+		return Step, true
+	}
+	if !frame.AtStatement() {
+		return "", false
+	}
+	line, ip := frame.Line(), frame.IP()
+	newLine := frame != s.frame || line != s.line || ip <= s.ip
+	s.frame, s.line, s.ip = frame, line, ip
+	switch {
+	case !newLine:
+		return "", false
+	case s.hasBreakpoint(line):
+		return Breakpoint, true
+	case s.mode == steppingInto:
+		return Step, true
+	case s.mode == steppingOver && depth <= s.depth:
+		return Step, true
+	}
+	return "", false
+}
+
+// StatementLines returns the lines on which a statement of bytecode
+// starts, in order; a breakpoint anywhere else is never hit.
+func StatementLines(bytecode *compiler.Bytecode) []int {
+	seen := make(map[int]bool)
+	add := func(lines []code.Line) {
+		for _, l := range lines {
+			seen[l.Line] = true
+		}
+	}
+	add(bytecode.Lines)
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			add(fn.Lines)
+		}
+	}
+	lines := make([]int, 0, len(seen))
+	for line := range seen {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
@@ -0,0 +1,10 @@
+let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let xs = [1, 2];
+let total = 0;
+for (let i = 0; i < 2; i = i + 1) {
+  total = add(total, xs[i]);
+}
+puts(total);
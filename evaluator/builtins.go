@@ -13,6 +13,15 @@ var builtins = map[string]*object.Builtin{
 	"puts":   object.GetBuiltinByName("puts"),
 	"int":    object.GetBuiltinByName("int"),
 	"string": object.GetBuiltinByName("string"),
+	"map":    object.GetBuiltinByName("map"),
+	"filter": object.GetBuiltinByName("filter"),
+
+	"error":    object.GetBuiltinByName("error"),
+	"is_error": object.GetBuiltinByName("is_error"),
+
+	"delete": object.GetBuiltinByName("delete"),
+	"freeze": object.GetBuiltinByName("freeze"),
+	"range":  object.GetBuiltinByName("range"),
 }
 
 func AddBuiltIn(name string, builtin *object.Builtin) {
@@ -1,6 +1,8 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/GhostNet-Dev/gscript/ast"
@@ -28,9 +30,55 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
+// ErrMeteringUnsupported is returned for a metered run. The evaluator has
+// no deterministic cost model; metered scripts must run on the VM.
+var ErrMeteringUnsupported = errors.New("gas metering is not supported by the evaluator")
+
+// Options configures EvalWithOptions.
+type Options struct {
+	// GasLimit requests a metered run, which the evaluator refuses with
+	// ErrMeteringUnsupported.
+	GasLimit uint64
+
+	// MemoryLimit caps the approximate bytes allocated for arrays,
+	// hashes, strings and functions when non-zero. Evaluation stops with
+	// object.ErrMemoryLimit once it is reached.
+	MemoryLimit uint64
+}
+
+// EvalContext evaluates node like Eval, but checks ctx on every loop
+// iteration and function call. When ctx is done evaluation stops and
+// object.ErrCanceled or object.ErrDeadlineExceeded is returned.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment) (object.Object, error) {
+	return EvalWithOptions(ctx, node, env, Options{})
+}
+
+// EvalWithOptions is EvalContext with execution limits.
+func EvalWithOptions(ctx context.Context, node ast.Node, env *object.Environment, opts Options) (object.Object, error) {
+	if opts.GasLimit > 0 {
+		return nil, ErrMeteringUnsupported
+	}
+	in := &interpreter{env: env, ctx: ctx}
+	if opts.MemoryLimit > 0 {
+		in.mem = object.NewMemoryMeter(opts.MemoryLimit)
+	}
+	previous := env.Caller
+	env.Caller = in
+	defer func() { env.Caller = previous }()
+
+	result := Eval(node, env)
+	if in.err != nil {
+		return nil, in.err
+	}
+	return result, nil
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
+		if env.GetCaller() == nil {
+			env.Caller = &interpreter{env: env, ctx: context.Background()}
+		}
 		return evalProgram(node, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
@@ -40,7 +88,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalTypeExpression(node, env)
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		if ident, ok := val.(*object.Identifier); ok {
@@ -60,19 +108,27 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return &object.Null{}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
+	case *ast.BadStmt, *ast.BadExpr:
+		return newError("cannot evaluate code with syntax errors")
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isAbrupt(elements[0]) {
+			return elements[0]
+		}
+		return allocate(env, &object.Array{Elements: elements})
+	case *ast.TupleLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return allocate(env, &object.Tuple{Elements: elements})
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isAbrupt(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
@@ -88,25 +144,51 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalIfExpression(node, env)
 	case *ast.ForExpression:
 		return evalForExpression(node, env)
+	case *ast.ForInExpression:
+		return evalForInExpression(node, env)
 
 	//Function Expressiont
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.PropagateExpression:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		errValue := val
+		if ident, ok := val.(*object.Identifier); ok {
+			errValue = ident.Value
+		}
+		if _, ok := errValue.(*object.ErrorValue); ok {
+			return &object.ReturnValue{Value: errValue}
+		}
+		return val
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if ident, ok := val.(*object.Identifier); ok {
+			val = ident.Value
+		}
+		return object.Throw(val)
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body, Name: node.Name}
+		return allocate(env, &object.Function{Parameters: params, Env: env, Body: body, Name: node.Name})
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isAbrupt(function) {
 			return function
 		}
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
 		return applyFunction(function, args, env)
@@ -114,17 +196,21 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	// Prefix / Infix
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return evalPrefixExpression(node.Operator, unwrapIdentifier(right))
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
+		if node.Operator != "=" {
+			// Read the left operand before the right one can assign it.
+			left = unwrapIdentifier(left)
+		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right, env)
@@ -133,27 +219,27 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
+	hash := object.NewHash(len(node.Pairs))
+	for _, keyNode := range node.Keys() {
 		key := Eval(keyNode, env)
-		if isError(key) {
+		if isAbrupt(key) {
 			return key
 		}
 		if ident, ok := key.(*object.Identifier); ok {
 			key = ident.Value
 		}
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+		hashKey, err := object.KeyOf(key)
+		if err != nil {
+			return newError("%s", err)
 		}
-		value := Eval(valueNode, env)
-		if isError(value) {
+		value := Eval(node.Pairs[keyNode], env)
+		if isAbrupt(value) {
 			return value
 		}
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		value = unwrapIdentifier(value)
+		hash.Set(hashKey, value)
 	}
-	return &object.Hash{Pairs: pairs}
+	return allocate(env, hash)
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
@@ -167,8 +253,15 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.TUPLE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalTupleIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.ERROR_VALUE_OBJ && index.Type() == object.STRING_OBJ:
+		if field := left.(*object.ErrorValue).Field(index.(*object.String).Value); field != nil {
+			return field
+		}
+		return NULL
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -184,20 +277,29 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
+func evalTupleIndexExpression(tuple, index object.Object) object.Object {
+	tupleObject := tuple.(*object.Tuple)
+	idx := index.(*object.Integer).Value
+	if idx < 0 || idx >= int64(len(tupleObject.Elements)) {
+		return NULL
+	}
+	return tupleObject.Elements[idx]
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	if ident, ok := hash.(*object.Identifier); ok {
 		hash = ident.Value
 	}
 	hashObject := hash.(*object.Hash)
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+	key, err := object.KeyOf(index)
+	if err != nil {
+		return newError("%s", err)
 	}
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
-	return pair.Value
+	return value
 }
 
 func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
@@ -206,23 +308,145 @@ func applyFunction(fn object.Object, args []object.Object, env *object.Environme
 	}
 	switch fn := fn.(type) {
 	case *object.Function:
+		if err := checkContext(env); err != nil {
+			return err
+		}
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d",
+				len(fn.Parameters), len(args))
+		}
+		in, _ := env.GetCaller().(*interpreter)
+		if in != nil {
+			if len(in.calls) >= MaxCallDepth {
+				return newError("maximum call depth exceeded")
+			}
+			name := fn.Name
+			if name == "" {
+				name = object.AnonymousFrame
+			}
+			in.calls = append(in.calls, name)
+			defer func() { in.calls = in.calls[:len(in.calls)-1] }()
+		}
 		extendedEnv := extendFunctionEnv(fn, args)
 		evaluated := Eval(fn.Body, extendedEnv)
+		if errObj, ok := evaluated.(*object.Error); ok && in != nil {
+			in.raise(errObj)
+		}
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		if result := fn.Fn(env, args...); result != nil {
-			return result
+		if env.GetCaller() == nil {
+			env.Caller = &interpreter{env: env, ctx: context.Background()}
 		}
-		return NULL
+		values := make([]object.Object, len(args))
+		for i, arg := range args {
+			if ident, ok := arg.(*object.Identifier); ok {
+				arg = ident.Value
+			}
+			values[i] = arg
+		}
+		result := fn.Fn(env, values...)
+		if result == nil {
+			return NULL
+		}
+		switch result := result.(type) {
+		case *object.Error:
+			if result.Kind == "" {
+				result.Kind = object.BuiltinError
+			}
+		case *object.Boolean:
+			return nativeBoolToBooleanObject(result.Value)
+		}
+		return result
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// MaxCallDepth is the deepest the evaluator nests function calls. It is
+// the VM's default frame limit less its main frame; the VM grows its stack
+// to fit the frames, so both engines raise "maximum call depth exceeded"
+// on the same call.
+const MaxCallDepth = 1023
+
+// interpreter is the object.Caller the evaluator attaches to environments,
+// letting builtins call back into script functions. It also carries the
+// context of the current EvalContext run and the names of the functions
+// being applied, for error traces.
+type interpreter struct {
+	env *object.Environment
+	ctx context.Context
+	mem *object.MemoryMeter
+	err error
+	// calls are the names of the active functions, outermost first; its
+	// length is the call depth.
+	calls []string
+}
+
+func (in *interpreter) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	result := applyFunction(fn, args, in.env)
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, errObj
+	}
+	if ident, ok := result.(*object.Identifier); ok {
+		result = ident.Value
+	}
+	return result, nil
+}
+
+// raise records the functions active when err was raised as its trace,
+// unless it already has one.
+func (in *interpreter) raise(err *object.Error) {
+	if err.Trace != nil {
+		return
+	}
+	err.Trace = make([]string, 0, len(in.calls)+1)
+	for i := len(in.calls) - 1; i >= 0; i-- {
+		err.Trace = append(err.Trace, in.calls[i])
+	}
+	err.Trace = append(err.Trace, object.MainFrame)
+}
+
+// Allocate makes the interpreter an object.Allocator enforcing the memory
+// limit of the current run.
+func (in *interpreter) Allocate(obj object.Object) error {
+	if err := in.mem.Allocate(obj); err != nil {
+		in.err = err
+		return err
+	}
+	return nil
+}
+
+// allocate charges obj to the memory limit of the running evaluation,
+// returning an error object in its place once the limit is exceeded.
+func allocate(env *object.Environment, obj object.Object) object.Object {
+	if isAbrupt(obj) {
+		return obj
+	}
+	if err := env.Allocate(obj); err != nil {
+		return newError("%s", err)
+	}
+	return obj
+}
+
+// checkContext returns an error object once the context of the running
+// EvalContext is done, recording the cause for EvalContext to report.
+func checkContext(env *object.Environment) *object.Error {
+	in, ok := env.GetCaller().(*interpreter)
+	if !ok {
+		return nil
+	}
+	if err := object.ContextError(in.ctx); err != nil {
+		in.err = err
+		return newError("%s", err)
+	}
+	return nil
+}
+
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
 	env := object.NewEnclosedEnvironment(fn.Env)
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		name := param.Value
+		env.Set(name, &object.Identifier{Name: name, Value: args[paramIdx]})
 	}
 	return env
 }
@@ -234,14 +458,24 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
+// unwrapIdentifier returns the value bound to a variable. Evaluating an
+// identifier yields its binding, so that assignment can update it; values
+// stored in arrays, hashes and parameters must not alias it.
+func unwrapIdentifier(obj object.Object) object.Object {
+	if ident, ok := obj.(*object.Identifier); ok {
+		return ident.Value
+	}
+	return obj
+}
+
 func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 	for _, e := range exps {
 		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
-		result = append(result, evaluated)
+		result = append(result, unwrapIdentifier(evaluated))
 	}
 	return result
 }
@@ -259,7 +493,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+	if isAbrupt(condition) {
 		return condition
 	}
 
@@ -273,7 +507,7 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 }
 
 func isTruthy(obj object.Object) bool {
-	switch obj {
+	switch unwrapIdentifier(obj) {
 	case NULL:
 		return false
 	case TRUE:
@@ -297,7 +531,6 @@ func evalInfixExpression(operator string, left, right object.Object, env *object
 		} else {
 			ident.Value = right
 		}
-		env.Set(ident.Name, ident)
 		return ident
 	}
 	if left.Type() == object.IDENTFIER_OBJ {
@@ -310,14 +543,14 @@ func evalInfixExpression(operator string, left, right object.Object, env *object
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntergerInfixExpression(operator, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
+		return allocate(env, evalStringInfixExpression(operator, left, right))
 	default:
 		return newError("unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -335,6 +568,9 @@ func evalIntergerInfixExpression(operator string, left, right object.Object) obj
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
@@ -390,6 +626,7 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
+	env = object.NewEnclosedEnvironment(env)
 
 	for _, statement := range block.Statements {
 		result = Eval(statement, env)
@@ -404,21 +641,31 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 }
 
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+	return &object.Error{Message: fmt.Sprintf(format, a...), Kind: object.RuntimeError}
 }
 
-func isError(obj object.Object) bool {
+// isAbrupt reports whether obj ends the evaluation of the enclosing
+// expression: an error, or a value returned from within it by `?` or a
+// return statement in an if block.
+func isAbrupt(obj object.Object) bool {
 	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+		t := obj.Type()
+		return t == object.ERROR_OBJ || t == object.RETURN_VALUE_OBJ
 	}
 	return false
 }
@@ -33,17 +33,117 @@ func evalObjectBlockStatement(block *ast.ObjectBlockStatement, env *object.Envir
 	return result
 }
 
+// evalForExpression evaluates a loop in a scope of its own, a copy of
+// which each iteration gets, like a block; see NewIterationEnvironment.
 func evalForExpression(ie *ast.ForExpression, env *object.Environment) object.Object {
-	Eval(ie.Init, env)
+	env = object.NewEnclosedEnvironment(env)
+	if init := Eval(ie.Init, env); isAbrupt(init) {
+		return init
+	}
+	env = object.NewIterationEnvironment(env)
 	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+	if isAbrupt(condition) {
 		return condition
 	}
 
 	for isTruthy(condition) {
-		Eval(ie.Consequence, env)
-		Eval(ie.Increment, env)
+		if err := checkContext(env); err != nil {
+			return err
+		}
+		result := Eval(ie.Consequence, env)
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+		env = object.NewIterationEnvironment(env)
+		if increment := Eval(ie.Increment, env); isAbrupt(increment) {
+			return increment
+		}
 		condition = Eval(ie.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
 	}
 	return NULL
 }
+
+// evalForInExpression evaluates a loop over a collection. Each iteration
+// binds the variables in a scope of its own, which the closures created in
+// it keep.
+func evalForInExpression(node *ast.ForInExpression, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+	iter, err := object.Iterate(unwrapIdentifier(iterable))
+	if err != nil {
+		return newError("%s", err)
+	}
+	vars := len(node.Variables)
+	for {
+		if err := checkContext(env); err != nil {
+			return err
+		}
+		key, value, ok := iter.NextFor(vars)
+		if !ok {
+			return NULL
+		}
+		loopEnv := object.NewEnclosedEnvironment(env)
+		bound := []object.Object{value}
+		if vars == 2 {
+			bound = []object.Object{key, value}
+		}
+		for i, v := range node.Variables {
+			loopEnv.Set(v.Value, &object.Identifier{Name: v.Value, Value: orNull(bound[i])})
+		}
+		result := Eval(node.Consequence, loopEnv)
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+	}
+}
+
+// orNull returns obj, or NULL in place of nil.
+func orNull(obj object.Object) object.Object {
+	if obj == nil {
+		return NULL
+	}
+	return obj
+}
+
+func evalTryStatement(node *ast.TryStatement, env *object.Environment) object.Object {
+	result := Eval(node.Block, env)
+	if errObj, ok := result.(*object.Error); ok && node.Catch != nil && catchable(env) {
+		if in, ok := env.GetCaller().(*interpreter); ok {
+			in.raise(errObj)
+		}
+		catchEnv := object.NewEnclosedEnvironment(env)
+		if node.Param != nil {
+			name := node.Param.Value
+			catchEnv.Set(name, &object.Identifier{Name: name, Value: errObj.Catch()})
+		}
+		result = Eval(node.Catch, catchEnv)
+	}
+	if node.Finally != nil {
+		finally := Eval(node.Finally, env)
+		if isAbrupt(finally) {
+			return finally
+		}
+	}
+	if isAbrupt(result) {
+		return result
+	}
+	return NULL
+}
+
+// catchable reports whether an error may be handled by the script; an
+// evaluation aborted by its context or memory limit may not.
+func catchable(env *object.Environment) bool {
+	in, ok := env.GetCaller().(*interpreter)
+	return !ok || in.err == nil
+}
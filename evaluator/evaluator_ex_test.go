@@ -1,9 +1,15 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/GhostNet-Dev/gscript/lexer"
 	"github.com/GhostNet-Dev/gscript/object"
+	"github.com/GhostNet-Dev/gscript/parser"
 )
 
 func TestNull(t *testing.T) {
@@ -120,7 +126,7 @@ func TestForExpressions(t *testing.T) {
 		input    string
 		expected int64
 	}{
-		{"for (let a = 0;a < 5;a = a + 1) {};a;", 5},
+		{"let b = 0;for (let a = 0;a < 5;a = a + 1) {b = b + 1;};b;", 5},
 		{"let a = 0;for (a = 0;a < 5;a = a + 1) {};a;", 5},
 		{"let a = 0;let b = 0;for (a = 0;a < 5;a = a + 1) {b = b + 1;};b;", 5},
 	}
@@ -129,6 +135,302 @@ func TestForExpressions(t *testing.T) {
 		testIntegerObject(t, testEval(tt.input), tt.expected, i)
 	}
 }
+func TestForExpressionControlFlow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let f = fn() { for (let i = 0; true; i = i + 1) { if (i > 3) { return i; } } }; f()", 4},
+		{"let i = 0; for (; i < 3;) { i = i + 1 }; i", 3},
+	}
+	for i, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected, i)
+	}
+	evaluated := testEval("for (let i = 0; i < 3; i = i + 1) { i + true }")
+	if _, ok := evaluated.(*object.Error); !ok {
+		t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+}
+
+func TestForInExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + x }; s", "6"},
+		{"let s = 0; for (i, x in [10, 20, 30]) { s = s + i * x }; s", "80"},
+		{"let s = 0; for (i, x in (5, 6)) { s = s + i + x }; s", "12"},
+		{`let s = ""; for (k in {"a": 1, "b": 2}) { s = s + k }; s`, "ab"},
+		{`let s = 0; for (k, v in {"a": 1, "b": 2}) { s = s + v }; s`, "3"},
+		{`let s = ""; for (c in "héllo") { s = c + s }; s`, "olléh"},
+		{"let s = []; for (i in range(3, 0, -1)) { s = push(s, i) }; s", "[3, 2, 1]"},
+		{"let s = 0; for (i in range(0, 10, 2)) { s = s + i }; s", "20"},
+		{"for (x in [1]) { x }", "null"},
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x } } }; f([1, 5, 7])", "5"},
+		{"let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }) }; fs[0]() * 10 + fs[1]()", "12"},
+		{"let x = 5; for (x in [1]) { x }; x", "5"},
+		{"let s = 0; for (x in [1, 2, 3]) { try { if (x == 2) { throw x } s = s + x } catch (e) { s = s + 10 } }; s", "14"},
+		{"for (x in 1) { x }", "ERROR: INTEGER is not iterable"},
+		{"for (x in [1, 2]) { x + true }", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestBlockScopes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 1; if (true) { let x = 2; }; x", 1},
+		{"let x = 1; { let x = 2; x = 3; }; x", 1},
+		{"let x = 1; { x = 2; }; x", 2},
+		{"let x = 1; { let x = x + 1; x }", 2},
+		{"let x = 1; { let y = 2; { let x = y + 10; x } }", 12},
+		{"let f = fn(a) { let a = a * 2; a }; f(3)", 6},
+		{"let f = fn() { let r = 0; { let a = 1; r = r + a; }; { let b = 2; r = r + b; }; r }; f()", 3},
+		{"let e = 5; try { throw 1; } catch (e) { }; e", 5},
+		{"let a = 0; for (let a = 10; a < 12; a = a + 1) { }; a", 0},
+		{"let fs = []; for (let i = 0; i < 3; i = i + 1) { fs = push(fs, fn() { i }); }; fs[0]() + fs[1]() * 10 + fs[2]() * 100", 210},
+		{"let f = fn() { let fs = []; for (let i = 0; i < 3; i = i + 1) { let j = i * 2; fs = push(fs, fn() { j }); }; fs[1]() + fs[2]() }; f()", 6},
+	}
+	for i, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected, i)
+	}
+
+	for _, input := range []string{
+		"if (true) { let y = 1; }; y",
+		"for (let i = 0; i < 1; i = i + 1) { }; i",
+		"{ let y = 1; }; y",
+		"try { throw 1; } catch (e) { }; e",
+	} {
+		errObj, ok := testEval(input).(*object.Error)
+		if !ok || !strings.HasPrefix(errObj.Message, "identifier not found") {
+			t.Errorf("%q: expected an identifier not found error, got %v", input, errObj)
+		}
+	}
+}
+
+func TestClosureAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`let g = fn() { let x = 1; let f = fn() { x }; x = 2; f() }; g()`, 2},
+		{`let k = fn() { let c = 0; let inc = fn() { c = c + 1 }; inc(); c }; k()`, 1},
+		{`let counter = fn() { let n = 0; fn() { n = n + 1; n } }; let a = counter(); a(); a(); a() + counter()()`, 4},
+		{`let f = fn() { let v = 1; let mid = fn() { let inner = fn() { v = v * 10 }; inner(); v }; mid() + v }; f()`, 20},
+		{`let fs = []; for (let i = 0; i < 3; i = i + 1) { fs = push(fs, fn() { i = i + 10; i }); }; fs[0]() + fs[0]() + fs[1]()`, 41},
+		{`let f = fn(n) { let acc = 0; for (x in range(n)) { let add = fn() { acc = acc + x }; add(); }; acc }; f(5)`, 10},
+	}
+	for i, tt := range tests {
+		evaluated := testEval(tt.input)
+		if ident, ok := evaluated.(*object.Identifier); ok {
+			evaluated = ident.Value
+		}
+		testIntegerObject(t, evaluated, tt.expected, i)
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let r = 0; try { throw 1; } catch (e) { r = e["value"]; }; r`, 1},
+		{`let r = ""; try { throw "boom"; } catch (e) { r = e["message"]; }; r`, "boom"},
+		{`let r = ""; try { 1 + true; } catch (e) { r = e["kind"]; }; r`, "runtime"},
+		{`let r = ""; try { len(1); } catch (e) { r = e["kind"] + ": " + e["message"]; }; r`,
+			"builtin: argument to 'len' not supported, got INTEGER"},
+		{`let r = ""; try { [1][true]; } catch (e) { r = e["kind"]; }; r`, "runtime"},
+		{`let r = 0; try { throw 1; } catch { r = 2; }; r`, 2},
+		{`let r = 0; try { r = 1; } catch (e) { r = 2; }; r`, 1},
+		{`let r = 0; try { throw 1; } catch (e) { r = 1; } finally { r = r + 10; }; r`, 11},
+		{`let r = 0; try { r = 1; } finally { r = r + 10; }; r`, 11},
+		{`let r = 0; try { try { throw 1; } finally { r = 5; } } catch (e) { r = r + e["value"]; }; r`, 6},
+		{`let r = 0; try { try { throw 1; } catch (e) { throw 2; } finally { r = 5; } } catch (e) { r = r + e["value"]; }; r`, 7},
+		{`let r = ""; try { try { throw 1; } catch (e) { throw e; } } catch (e) { r = e["kind"]; }; r`, "throw"},
+		{`let f = fn() { throw "boom"; }; let g = fn() { f() + 1 }; let r = ""; try { g(); } catch (e) { r = e["message"]; }; r`, "boom"},
+		{`let f = fn() { throw "boom"; }; let g = fn() { f() + 1 }; let r = 0; try { g(); } catch (e) { r = e["trace"]; }; r[0] + r[1] + r[2]`, "fg<main>"},
+		{`let r = 0; try { map([1], fn(x) { x + true }); } catch (e) { r = e["kind"] + e["trace"][0]; }; r`, "runtime<anonymous>"},
+		{`let f = fn(a) { let b = 2; try { a + [1, 2, a + true]; } catch (e) { b = 3; }; a + b }; f(1)`, 4},
+		{`let f = fn(x) { try { if (x > 0) { throw x; } } catch (e) { return e["value"] * 2; }; 0 }; f(1) + f(0)`, 2},
+		{`let r = 0; [1, if (true) { try { throw 2; } catch (e) { r = e["value"]; }; r }][1]`, 2},
+		{`let r = 0; let f = fn() { try { return 1; } finally { r = 2; } }; f() + r`, 3},
+		{`let r = 0; let f = fn() { try { return 1; } catch (e) { r = 5; } finally { throw 2; } }; try { f(); } catch (e) { r = r + e["value"]; }; r`, 2},
+		{`let f = fn() { if (true) { try { 1 } finally { 2 } } }; f()`, nil},
+		{`throw "boom";`, &object.Error{Message: "boom"}},
+		{`let f = fn() { try { throw 1; } finally { 2; } }; f()`, &object.Error{Message: "1"}},
+		{`let f = fn(x) { f(x) }; f(1)`, &object.Error{Message: "maximum call depth exceeded"}},
+		{`let f = fn(x) { f(x) + 1 }; let r = ""; try { f(1); } catch (e) { r = e["message"]; }; r`, "maximum call depth exceeded"},
+		{`let g = fn(n) { if (n == 0) { 0 } else { 1 + g(n - 1) } }; g(1022)`, 1022},
+		{`let g = fn(n) { if (n == 0) { 0 } else { 1 + g(n - 1) } }; g(1023)`, &object.Error{Message: "maximum call depth exceeded"}},
+	}
+	for i, tt := range tests {
+		evaluated := testEval(tt.input)
+		if ident, ok := evaluated.(*object.Identifier); ok {
+			evaluated = ident.Value
+		}
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected), i)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("[%d] object is not String. got=%T (%+v)", i, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("[%d] wrong string. expected=%q, got=%q", i, expected, str.Value)
+			}
+		case *object.Error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("[%d] no error object returned. got=%T(%+v)", i, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected.Message {
+				t.Errorf("[%d] wrong error message. expected=%q, got=%q", i, expected.Message, errObj.Message)
+			}
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+
+	program := parser.NewParser(lexer.NewLexer(`let r = 0; try { for (;true;) {} } catch (e) { r = 1; }; r`)).ParseProgram()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := EvalContext(ctx, program, object.NewEnvironment(nil)); !errors.Is(err, object.ErrDeadlineExceeded) {
+		t.Fatalf("wrong eval error: want=%q, got=%v", object.ErrDeadlineExceeded, err)
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`is_error(error("x"))`, true},
+		{`is_error(1)`, false},
+		{`error("x")["message"]`, "x"},
+		{`error("x")["kind"]`, "error"},
+		{`let e = error("x"); 1`, 1},
+		{`error(1)`, &object.Error{Message: "argument to 'error' must be STRING, got INTEGER"}},
+		{`let f = fn(x) { if (x > 0) { return error("bad"); }; x }; let g = fn(x) { let v = f(x)?; v + 1 }; g(0)`, 1},
+		{`let f = fn(x) { if (x > 0) { return error("bad"); }; x }; let g = fn(x) { let v = f(x)?; v + 1 }; g(1)["message"]`, "bad"},
+		{`let f = fn(x) { if (x > 0) { return error("bad"); }; x }; let g = fn(x) { 1 + f(x)? * 2 }; g(0)`, 1},
+		{`let f = fn(x) { if (x > 0) { return error("bad"); }; x }; let g = fn(x) { 1 + f(x)? * 2 }; is_error(g(1))`, true},
+		{`let f = fn() { [1, 2]?[1] }; f()`, 2},
+		{`let f = fn(e) { map([1, 2], fn(x) { e? }) }; is_error(f(error("x"))[1])`, true},
+		{`let r = 0; let f = fn() { try { error("e")?; r = 5; } finally { r = r + 1; } }; f(); r`, 1},
+		{`let r = ""; try { throw error("bad"); } catch (e) { r = e["kind"] + e["message"]; }; r`, "errorbad"},
+		{`let r = false; try { 1 + true; } catch (e) { r = is_error(e); }; r`, true},
+		{`error("top")?; 5`, &object.ErrorValue{Message: "top"}},
+		{`let f = fn() { 1 }; f()?; 5`, 5},
+	}
+	for i, tt := range tests {
+		evaluated := testEval(tt.input)
+		if ident, ok := evaluated.(*object.Identifier); ok {
+			evaluated = ident.Value
+		}
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected), i)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("[%d] object is not String. got=%T (%+v)", i, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("[%d] wrong string. expected=%q, got=%q", i, expected, str.Value)
+			}
+		case *object.ErrorValue:
+			errValue, ok := evaluated.(*object.ErrorValue)
+			if !ok || errValue.Message != expected.Message {
+				t.Errorf("[%d] wrong error value. expected=%q, got=%T (%+v)", i, expected.Message, evaluated, evaluated)
+			}
+		case *object.Error:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected.Message {
+				t.Errorf("[%d] wrong error. expected=%q, got=%T (%+v)", i, expected.Message, evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected error
+	}{
+		{"for (;true;) {}", object.ErrDeadlineExceeded},
+		{"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) + f(n - 1) } }; f(40)", object.ErrDeadlineExceeded},
+		{"map([1], fn(x) { for (;true;) {} })", object.ErrDeadlineExceeded},
+		{"let a = 0; for (let i = 0; i < 10; i = i + 1) { a = a + i }; a", nil},
+	}
+	for _, tt := range tests {
+		program := parser.NewParser(lexer.NewLexer(tt.input)).ParseProgram()
+		env := object.NewEnvironment(nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := EvalContext(ctx, program, env)
+		cancel()
+		if tt.expected == nil {
+			if err != nil {
+				t.Fatalf("eval error: %s", err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.expected) {
+			t.Fatalf("wrong eval error: want=%q, got=%v", tt.expected, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	program := parser.NewParser(lexer.NewLexer("let f = fn() { 1 }; f()")).ParseProgram()
+	if _, err := EvalContext(ctx, program, object.NewEnvironment(nil)); !errors.Is(err, object.ErrCanceled) {
+		t.Fatalf("wrong eval error: want=%q, got=%v", object.ErrCanceled, err)
+	}
+}
+
+func TestEvalRefusesMetering(t *testing.T) {
+	program := parser.NewParser(lexer.NewLexer("1 + 2")).ParseProgram()
+	opts := Options{GasLimit: 1000}
+	_, err := EvalWithOptions(context.Background(), program, object.NewEnvironment(nil), opts)
+	if !errors.Is(err, ErrMeteringUnsupported) {
+		t.Fatalf("wrong eval error: want=%q, got=%v", ErrMeteringUnsupported, err)
+	}
+}
+
+func TestEvalMemoryLimit(t *testing.T) {
+	tests := []string{
+		`let a = []; for (;true;) { a = push(a, 1) }`,
+		`let s = "a"; for (;true;) { s = s + s }`,
+		`let f = fn(h) { {1: h} }; let h = {}; for (;true;) { h = f(h) }`,
+		`let a = [1, 2, 3]; for (;true;) { a = map(a, fn(x) { [x] }) }`,
+	}
+	for _, input := range tests {
+		program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+		opts := Options{MemoryLimit: 4096}
+		_, err := EvalWithOptions(context.Background(), program, object.NewEnvironment(nil), opts)
+		if !errors.Is(err, object.ErrMemoryLimit) {
+			t.Fatalf("%q: wrong eval error: want=%q, got=%v", input, object.ErrMemoryLimit, err)
+		}
+	}
+
+	program := parser.NewParser(lexer.NewLexer(`let a = [1, 2]; "ab" + "c"`)).ParseProgram()
+	opts := Options{MemoryLimit: 4096}
+	if _, err := EvalWithOptions(context.Background(), program, object.NewEnvironment(nil), opts); err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+}
+
 func TestFunctionNameObject(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -143,3 +445,34 @@ func TestFunctionNameObject(t *testing.T) {
 		testIntegerObject(t, testEval(tt.input), tt.expected, i)
 	}
 }
+
+func TestBuiltinCallbacks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"map([1, 2, 3], fn(x) { x * 2 })[2]", 6},
+		{"let a = [1, 2, 3, 4]; len(filter(a, fn(x) { x > 2 }))", 2},
+		{"let inc = fn(x) { x + 1 }; map(map([1, 2], inc), inc)[1]", 4},
+		{"map([[1], [2, 3]], len)[1]", 2},
+		{"let base = 10; map([1], fn(x) { x + base })[0]", 11},
+		{"map([1], fn(x) { x + true })", "type mismatch: INTEGER + BOOLEAN"},
+		{"map([1], fn(x, y) { x })", "wrong number of arguments: want=2, got=1"},
+	}
+	for i, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected), i)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
@@ -47,25 +47,69 @@ func TestHashLiterals(t *testing.T) {
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
-	}
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
-	}
-	i := 0
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
-		if !ok {
-			t.Errorf("no pair for given key in Pairs")
+	expected := []struct {
+		key   object.Hashable
+		value int64
+	}{
+		{&object.String{Value: "one"}, 1},
+		{&object.String{Value: "two"}, 2},
+		{&object.String{Value: "three"}, 3},
+		{&object.Integer{Value: 4}, 4},
+		{TRUE, 5},
+		{FALSE, 6},
+	}
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
+	}
+	for i, pair := range result.Pairs() {
+		if !object.Equal(pair.Key, expected[i].key) {
+			t.Errorf("pair %d has wrong key. want=%s, got=%s", i, expected[i].key.Inspect(), pair.Key.Inspect())
+		}
+		testIntegerObject(t, pair.Value, expected[i].value, i)
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 4, true: 5}`, "{b: 1, a: 2, 3: 4, true: 5}"},
+		{`{"a": 1, "b": 2, "a": 3}`, "{a: 3, b: 2}"},
+		{`delete({"a": 1, "b": 2, "c": 3}, "b")`, "{a: 1, c: 3}"},
+		{`let h = {"a": 1}; delete(h, "a"); h`, "{a: 1}"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong hash for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`(1, 2)`, "(1, 2)"},
+		{`(1,)`, "(1,)"},
+		{`let t = (1, "a"); t[1]`, "a"},
+		{`(1, 2)[2]`, "null"},
+		{`len((1, 2, 3))`, "3"},
+		{`(1, (2, 3)) == (1, (2, 3))`, "true"},
+		{`{(1, 2): "a", (2, 1): "b"}[(2, 1)]`, "b"},
+		{`{(1, 2): "a", (1, 2): "b"}`, "{(1, 2): b}"},
+		{`let k = freeze([[1], (2, [3])]); {k: "deep"}[k]`, "deep"},
+		{`delete({(1, 2): 1, (3, 4): 2}, (1, 2))`, "{(3, 4): 2}"},
+		{`{[1, 2]: 1}`, "ERROR: mutable ARRAY cannot be a hash key; freeze it or use a tuple"},
+		{`let a = [1]; {"a": 1}[a]`, "ERROR: mutable ARRAY cannot be a hash key; freeze it or use a tuple"},
+		{`{({},): 1}`, "ERROR: mutable HASH cannot be a hash key"},
+		{`freeze({})`, "ERROR: cannot freeze HASH"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
 		}
-		testIntegerObject(t, pair.Value, expectedValue, i)
-		i++
 	}
 }
 
@@ -152,6 +196,34 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestValueEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`"a" != "b"`, true},
+		{`let s = "a"; s + "" == s`, true},
+		{`"abc" < "abd"`, true},
+		{`"b" > "abc"`, true},
+		{`"a" < "a"`, false},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] != [2, 1]", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"b": 1}`, false},
+		{"null == null", true},
+		{"null == false", false},
+		{`1 == "1"`, false},
+		{"let f = fn() {}; f == f", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 
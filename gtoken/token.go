@@ -3,9 +3,11 @@ package gtoken
 type TokenType string
 
 type Token struct {
-	Type    TokenType
-	Literal string
-	Line    int
+	Type    TokenType `json:"type"`
+	Literal string    `json:"literal"`
+	// Line and Column locate the first byte of the token, counting from 1.
+	Line   int `json:"line"`
+	Column int `json:"column"`
 }
 
 const (
@@ -15,6 +17,10 @@ const (
 	IDENT = "IDENT"
 	INT   = "INT"
 
+	// COMMENT is a comment from // to the end of the line. The lexer skips
+	// comments, and keeps them for tools to read back with Comments.
+	COMMENT = "COMMENT"
+
 	ASSIGN   = "="
 	PLUS     = "+"
 	MINUS    = "-"
@@ -39,6 +45,8 @@ const (
 	LBRACKET = "["
 	RBRACKET = "]"
 
+	QUESTION = "?"
+
 	// keyword
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
@@ -50,6 +58,7 @@ const (
 	RETURN   = "RETURN"
 	STRING   = "STRING"
 	FOR      = "FOR"
+	IN       = "IN"
 	BREAK    = "BREAK"
 	CLASS    = "CLASS"
 	IMPORT   = "IMPORT"
@@ -57,6 +66,10 @@ const (
 	TYPE     = "TYPE"
 	STRUCT   = "STRUCT"
 	NULL     = "NULL"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
 )
 
 var keywords = map[string]TokenType{
@@ -69,12 +82,17 @@ var keywords = map[string]TokenType{
 	"else":    ELSE,
 	"return":  RETURN,
 	"for":     FOR,
+	"in":      IN,
 	"break":   BREAK,
 	"import":  IMPORT,
 	"package": PACKAGE,
 	"type":    TYPE,
 	"struct":  STRUCT,
 	"null":    NULL,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"throw":   THROW,
 }
 
 func NewToken(tokenType TokenType, ch byte, line int) Token {
@@ -0,0 +1,137 @@
+// Package difftest runs programs on both execution engines, the
+// tree-walking evaluator and the bytecode VM, and reports where they
+// disagree. Generate makes random well-formed programs to feed it.
+package difftest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GhostNet-Dev/gscript/compiler"
+	"github.com/GhostNet-Dev/gscript/evaluator"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/object"
+	"github.com/GhostNet-Dev/gscript/parser"
+	"github.com/GhostNet-Dev/gscript/vm"
+)
+
+// Limits of each run. Generated programs always terminate, but a string
+// doubled in nested loops can grow past any memory; and the timeout keeps
+// an engine bug from hanging the harness.
+const (
+	Timeout     = 5 * time.Second
+	MemoryLimit = 16 << 20
+)
+
+// Outcome is the result of running a program on one engine: the inspected
+// value it evaluated to, or the error that stopped it.
+type Outcome struct {
+	Value string
+	Err   string
+	// Aborted is set when the run hit the memory limit. The engines
+	// account for memory differently, so such a run is not compared.
+	Aborted bool
+}
+
+func (o Outcome) String() string {
+	if o.Err != "" {
+		return "error: " + o.Err
+	}
+	return o.Value
+}
+
+// Divergence is a program on which the engines disagree.
+type Divergence struct {
+	Input string
+	Eval  Outcome
+	VM    Outcome
+	// Optimized is set when the VM ran optimized bytecode.
+	Optimized bool
+}
+
+func (d *Divergence) Error() string {
+	engine := "vm"
+	if d.Optimized {
+		engine = "vm -O"
+	}
+	return fmt.Sprintf("engines diverge\neval:  %s\n%-6s %s\ninput:\n%s", d.Eval, engine+":", d.VM, d.Input)
+}
+
+// Check runs input on the evaluator and on the VM, with and without the
+// optimizer, and returns a *Divergence if they do not agree. Both engines
+// must produce the same value, or fail with the same message. Input that
+// does not parse is an error.
+func Check(input string) error {
+	program := parser.NewParser(lexer.NewLexer(input))
+	if program.ParseProgram(); len(program.Errors()) != 0 {
+		return fmt.Errorf("parse error: %s", program.Errors()[0])
+	}
+
+	eval := RunEval(input)
+	for _, optimize := range []bool{false, true} {
+		machine := RunVM(input, optimize)
+		if !agree(eval, machine) {
+			return &Divergence{Input: input, Eval: eval, VM: machine, Optimized: optimize}
+		}
+	}
+	return nil
+}
+
+// agree reports whether a and b are the same value or the same failure.
+// Runs cut short by the memory limit agree with anything.
+func agree(a, b Outcome) bool {
+	if a.Aborted || b.Aborted {
+		return true
+	}
+	return a.Err == b.Err && a.Value == b.Value
+}
+
+// RunEval runs input on the tree-walking evaluator.
+func RunEval(input string) Outcome {
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	opts := evaluator.Options{MemoryLimit: MemoryLimit}
+	result, err := evaluator.EvalWithOptions(ctx, program, object.NewEnvironment(nil), opts)
+	if err != nil {
+		return failure(err)
+	}
+	if ident, ok := result.(*object.Identifier); ok {
+		result = ident.Value
+	}
+	if errObj, ok := result.(*object.Error); ok {
+		return Outcome{Err: errObj.Message}
+	}
+	return inspect(result)
+}
+
+// RunVM compiles input, optimized if asked, and runs it on the VM.
+func RunVM(input string, optimize bool) Outcome {
+	program := parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+	comp := compiler.NewCompilerWithOptions(compiler.Options{Optimize: optimize})
+	if err := comp.Compile(program); err != nil {
+		return Outcome{Err: err.Error()}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	machine := vm.NewVMWithOptions(comp.Bytecode(), vm.Options{MemoryLimit: MemoryLimit})
+	if err := machine.RunContext(ctx); err != nil {
+		return failure(err)
+	}
+	return inspect(machine.LastPoppedStackElem())
+}
+
+func failure(err error) Outcome {
+	return Outcome{Err: err.Error(), Aborted: errors.Is(err, object.ErrMemoryLimit)}
+}
+
+func inspect(obj object.Object) Outcome {
+	if obj == nil {
+		return Outcome{Value: "<nil>"}
+	}
+	return Outcome{Value: obj.Inspect()}
+}
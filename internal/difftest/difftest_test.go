@@ -0,0 +1,105 @@
+package difftest
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/parser"
+)
+
+// TestRegressions holds divergences the harness found, each fixed since.
+func TestRegressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// Division by zero panicked both engines.
+		{"1 / 0", "error: division by zero"},
+		{"let a = 0; let f = fn(x) { 10 / x }; f(a)", "error: division by zero"},
+		{`let r = ""; try { 1 / 0; } catch (e) { r = e["message"]; }; r`, "division by zero"},
+		// The evaluator stored variables, not their values, in arrays.
+		{"let a = false; let b = a; [a, b]", "[false, false]"},
+		{"let a = 1; let b = [a]; a = 2; b", "[1]"},
+		{`let a = 1; let h = {"k": a}; a = 2; h["k"]`, "1"},
+		// ... and passed them to parameters, which could not be assigned.
+		{"let f = fn(x) { x = 5; x }; let a = 1; [f(a), a]", "[5, 1]"},
+		// ... and took a variable for true and -a for an error.
+		{"let b = false; if (b) { 1 } else { 2 }", "2"},
+		{"let b = false; !b", "true"},
+		{"let a = 3; -a", "-3"},
+		// ... and read the left operand after the right one ran.
+		{"let v = 0; let f = fn() { v = -1; return v; }; v + f()", "-1"},
+		// Strings, arrays and hashes compared by identity, differently in
+		// each engine.
+		{`"a" == "a"`, "true"},
+		{`let s = "a"; s + "b" == "ab"`, "true"},
+		{"[1, [2]] == [1, [2]]", "true"},
+		{`{"a": [1]} != {"a": [1]}`, "false"},
+		{"null == null", "true"},
+		// The VM copied captured variables into closures, and could not
+		// assign them.
+		{"let g = fn() { let x = 1; let f = fn() { x }; x = 2; f() }; g()", "2"},
+		{"let k = fn() { let c = 0; let inc = fn() { c = c + 1 }; inc(); c }; k()", "1"},
+		{"let r = 0; for (let i = 0; i < 2; i = i + 1) { let v = 7; let c = fn() { v = v + 1; v }; r = r + c() + v; }; r", "32"},
+		// The evaluator overflowed the Go stack on unbounded recursion.
+		{"let f = fn(x) { f(x) }; f(1)", "error: maximum call depth exceeded"},
+		// Both engines allow the same call depth, whatever a frame holds.
+		{"let g = fn(n) { if (n == 0) { 0 } else { 1 + g(n - 1) } }; g(1022)", "1022"},
+		{"let g = fn(n) { if (n == 0) { 0 } else { 1 + g(n - 1) } }; g(1023)", "error: maximum call depth exceeded"},
+		{"let g = fn(n, a, b) { let c = a + b; if (n == 0) { c } else { 1 + g(n - 1, b, c) } }; g(1022, 0, 0)", "1022"},
+		{"let g = fn(n, a, b) { let c = a + b; if (n == 0) { c } else { 1 + g(n - 1, b, c) } }; g(1023, 0, 0)", "error: maximum call depth exceeded"},
+		// The engines worded the same runtime errors differently.
+		{`let r = ""; try { 1 + true; } catch (e) { r = e["message"]; }; r`, "type mismatch: INTEGER + BOOLEAN"},
+		{`let r = ""; try { 1 < "a"; } catch (e) { r = e["message"]; }; r`, "type mismatch: INTEGER < STRING"},
+		{`let r = ""; try { "a" - "b"; } catch (e) { r = e["message"]; }; r`, "unknown operator: STRING - STRING"},
+		{`let r = ""; try { true > false; } catch (e) { r = e["message"]; }; r`, "unknown operator: BOOLEAN > BOOLEAN"},
+		{`let r = ""; try { -"a"; } catch (e) { r = e["message"]; }; r`, "unknown operator: -STRING"},
+		{`let r = ""; try { 5(1); } catch (e) { r = e["message"]; }; r`, "not a function: INTEGER"},
+		{`let r = ""; try { 1[0]; } catch (e) { r = e["message"]; }; r`, "index operator not supported: INTEGER"},
+	}
+	for _, tt := range tests {
+		if err := Check(tt.input); err != nil {
+			t.Errorf("%s", err)
+			continue
+		}
+		if got := RunVM(tt.input, false).String(); got != tt.expected {
+			t.Errorf("wrong result for %q: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for seed := int64(0); seed < 100; seed++ {
+		input := Generate(rand.New(rand.NewSource(seed)))
+		p := parser.NewParser(lexer.NewLexer(input))
+		p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("seed %d: generated program does not parse: %s\n%s", seed, p.Errors()[0], input)
+		}
+		if input != Generate(rand.New(rand.NewSource(seed))) {
+			t.Fatalf("seed %d: program differs between runs", seed)
+		}
+	}
+}
+
+func TestDifferential(t *testing.T) {
+	n := int64(1000)
+	if testing.Short() {
+		n = 100
+	}
+	for seed := int64(0); seed < n; seed++ {
+		checkSeed(t, seed)
+	}
+}
+
+func FuzzDifferential(f *testing.F) {
+	f.Add(int64(0))
+	f.Fuzz(checkSeed)
+}
+
+func checkSeed(t *testing.T, seed int64) {
+	if err := Check(Generate(rand.New(rand.NewSource(seed)))); err != nil {
+		t.Fatalf("seed %d: %s", seed, err)
+	}
+}
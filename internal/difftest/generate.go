@@ -0,0 +1,481 @@
+package difftest
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// typ is the static type of a generated expression.
+type typ int
+
+const (
+	intType typ = iota
+	boolType
+	stringType
+	arrayType
+	hashType
+	numTypes
+	// closureType is the type of a closure bound to a variable; it is
+	// only ever called, never picked for an expression.
+	closureType
+)
+
+// Limits of a generated program, which keep it small and every run short.
+const (
+	maxDepth     = 3
+	maxFuncs     = 3
+	maxStmts     = 6
+	maxLoopCount = 5
+	maxClosures  = 2
+)
+
+type variable struct {
+	name string
+	typ  typ
+	// counter marks a loop counter, which is never assigned so the loop
+	// ends.
+	counter bool
+	// arity is the number of parameters of a closure.
+	arity int
+}
+
+// function is a global function, named f_a, f_b, ... A recursive one
+// counts its first parameter down to 0, so calls pass a small count.
+type function struct {
+	arity     int
+	recursive bool
+}
+
+// generator writes one random program. Every name it emits is in scope
+// and of the type its use expects, so most programs run to the end;
+// failures come from runtime checks, such as division by zero or an index
+// out of range, that both engines must agree on.
+type generator struct {
+	r      *rand.Rand
+	out    strings.Builder
+	indent int
+	names  int
+
+	// scopes holds the variables visible at each block level.
+	scopes [][]variable
+	// funcs holds every global function defined so far.
+	funcs []function
+	// inFunc is set while generating a function body, where return is
+	// allowed.
+	inFunc bool
+	// closures is the number of closures being generated around the
+	// current statement.
+	closures int
+}
+
+// Generate returns a random well-formed program that terminates. Its
+// value is an array of some of its globals, so a divergence in any of them
+// shows.
+//
+// Programs use integers, booleans, strings, arrays, hashes, functions,
+// bounded recursion, closures that assign the variables they capture, if,
+// bounded for loops, try/catch and throw, assignment, comparisons and the
+// builtins that operate on these. Calls never form a cycle other than a recursive
+// function calling itself: global functions are only called from outside
+// any function, and a closure only calls the closures defined before it.
+func Generate(r *rand.Rand) string {
+	g := &generator{r: r, scopes: [][]variable{nil}}
+
+	for i, n := 0, 1+r.Intn(maxStmts); i < n; i++ {
+		if len(g.funcs) < maxFuncs && r.Intn(3) == 0 {
+			g.function()
+		} else {
+			g.statement(0)
+		}
+	}
+
+	var result []string
+	for _, v := range g.scopes[0] {
+		if v.typ == closureType {
+			continue
+		}
+		if len(result) < 2 || r.Intn(2) == 0 {
+			result = append(result, v.name)
+		}
+	}
+	result = append(result, g.expr(typ(r.Intn(int(numTypes))), 0))
+	g.line("[%s]", strings.Join(result, ", "))
+	return g.out.String()
+}
+
+func (g *generator) line(format string, a ...interface{}) {
+	g.out.WriteString(strings.Repeat("\t", g.indent))
+	fmt.Fprintf(&g.out, format, a...)
+	g.out.WriteString("\n")
+}
+
+// name returns a fresh name. Identifiers cannot contain digits, so names
+// are numbered in letters, after an underscore that keeps them from
+// spelling a keyword: v_a, v_b, ..., v_z, v_ba.
+func (g *generator) name(prefix string) string {
+	g.names++
+	return prefix + "_" + letters(g.names)
+}
+
+func letters(n int) string {
+	s := string(rune('a' + n%26))
+	for n /= 26; n > 0; n /= 26 {
+		s = string(rune('a'+n%26)) + s
+	}
+	return s
+}
+
+func (g *generator) declare(name string, t typ) {
+	g.declareVariable(variable{name: name, typ: t})
+}
+
+func (g *generator) declareVariable(v variable) {
+	top := len(g.scopes) - 1
+	g.scopes[top] = append(g.scopes[top], v)
+}
+
+// variables returns the visible variables of type t, without loop counters
+// if assignable is set. A name shadowed by an inner scope is listed once,
+// with its innermost type.
+func (g *generator) variables(t typ, assignable bool) []string {
+	var names []string
+	for _, v := range g.visible(t, assignable) {
+		names = append(names, v.name)
+	}
+	return names
+}
+
+func (g *generator) visible(t typ, assignable bool) []variable {
+	seen := map[string]bool{}
+	var vars []variable
+	for i := len(g.scopes) - 1; i >= 0; i-- {
+		for j := len(g.scopes[i]) - 1; j >= 0; j-- {
+			v := g.scopes[i][j]
+			if seen[v.name] {
+				continue
+			}
+			seen[v.name] = true
+			if v.typ == t && !(assignable && v.counter) {
+				vars = append(vars, v)
+			}
+		}
+	}
+	return vars
+}
+
+// function defines a global function of integer parameters that returns an
+// integer. It may read the globals defined before it. A recursive one
+// returns once its first parameter is below 1 and otherwise calls itself
+// with it less one.
+func (g *generator) function() {
+	name := "f_" + letters(len(g.funcs))
+	f := function{arity: g.r.Intn(3)}
+	f.recursive = f.arity > 0 && g.r.Intn(2) == 0
+	params := make([]string, f.arity)
+	g.scopes = append(g.scopes, nil)
+	for i := range params {
+		params[i] = g.name("p")
+		g.declareVariable(variable{name: params[i], typ: intType, counter: f.recursive && i == 0})
+	}
+
+	g.line("let %s = fn(%s) {", name, strings.Join(params, ", "))
+	g.indent++
+	g.inFunc = true
+	if f.recursive {
+		g.line("if (%s < 1) { return %s; };", params[0], g.expr(intType, 0))
+	}
+	for i, n := 0, g.r.Intn(3); i < n; i++ {
+		g.statement(1)
+	}
+	if f.recursive {
+		args := []string{params[0] + " - 1"}
+		for range params[1:] {
+			args = append(args, g.expr(intType, 0))
+		}
+		g.line("return (%s(%s) + %s);", name, strings.Join(args, ", "), g.expr(intType, 0))
+	} else {
+		g.line("return %s;", g.expr(intType, 0))
+	}
+	g.inFunc = false
+	g.indent--
+	g.line("};")
+
+	g.scopes = g.scopes[:len(g.scopes)-1]
+	g.funcs = append(g.funcs, f)
+}
+
+// closure binds a closure of integer parameters that returns an integer.
+// Its body may read and assign the variables around it, and call the
+// closures defined before it.
+func (g *generator) closure(depth int) {
+	name := g.name("c")
+	arity := g.r.Intn(2)
+	params := make([]string, arity)
+	g.scopes = append(g.scopes, nil)
+	for i := range params {
+		params[i] = g.name("p")
+		g.declare(params[i], intType)
+	}
+
+	g.line("let %s = fn(%s) {", name, strings.Join(params, ", "))
+	g.indent++
+	inFunc := g.inFunc
+	g.inFunc = true
+	g.closures++
+	if names := g.variables(intType, true); len(names) > 0 {
+		target := names[g.r.Intn(len(names))]
+		g.line("%s = (%s + %s);", target, target, g.expr(intType, 0))
+	}
+	for i, n := 0, g.r.Intn(3); i < n; i++ {
+		g.statement(depth + 1)
+	}
+	g.line("return %s;", g.expr(intType, 0))
+	g.closures--
+	g.inFunc = inFunc
+	g.indent--
+	g.line("};")
+
+	g.scopes = g.scopes[:len(g.scopes)-1]
+	g.declareVariable(variable{name: name, typ: closureType, arity: arity})
+}
+
+func (g *generator) block(depth int) {
+	g.scopes = append(g.scopes, nil)
+	g.indent++
+	for i, n := 0, 1+g.r.Intn(3); i < n; i++ {
+		g.statement(depth + 1)
+	}
+	g.indent--
+	g.scopes = g.scopes[:len(g.scopes)-1]
+}
+
+func (g *generator) statement(depth int) {
+	choice := g.r.Intn(12)
+	if depth >= maxDepth {
+		choice = g.r.Intn(5)
+	}
+	switch choice {
+	case 0, 1, 2:
+		t := typ(g.r.Intn(int(numTypes)))
+		value := g.expr(t, 0)
+		name := g.name("v")
+		g.line("let %s = %s;", name, value)
+		g.declare(name, t)
+	case 3, 4:
+		t := typ(g.r.Intn(int(numTypes)))
+		names := g.variables(t, true)
+		if len(names) == 0 {
+			g.line("%s;", g.expr(t, 0))
+			return
+		}
+		g.line("%s = %s;", names[g.r.Intn(len(names))], g.expr(t, 0))
+	case 5, 6:
+		g.line("if (%s) {", g.expr(boolType, 0))
+		g.block(depth)
+		if g.r.Intn(2) == 0 {
+			g.line("} else {")
+			g.block(depth)
+		}
+		g.line("};")
+	case 7, 8:
+		var vars []variable
+		switch g.r.Intn(3) {
+		case 0:
+			counter := g.name("i")
+			g.line("for (let %s = 0; %s < %d; %s = %s + 1) {",
+				counter, counter, 1+g.r.Intn(maxLoopCount), counter, counter)
+			vars = []variable{{name: counter, typ: intType, counter: true}}
+		case 1:
+			index, value := g.name("i"), g.name("i")
+			g.line("for (%s, %s in range(%d, %d, %d)) {",
+				index, value, g.r.Intn(3), 1+g.r.Intn(maxLoopCount), 1+g.r.Intn(2))
+			vars = []variable{{name: index, typ: intType, counter: true}, {name: value, typ: intType, counter: true}}
+		case 2:
+			char := g.name("i")
+			g.line("for (%s in %s) {", char, g.expr(stringType, 0))
+			vars = []variable{{name: char, typ: stringType, counter: true}}
+		}
+		g.scopes = append(g.scopes, vars)
+		g.block(depth)
+		g.scopes = g.scopes[:len(g.scopes)-1]
+		g.line("};")
+	case 9:
+		if g.inFunc {
+			g.line("if (%s) { return %s; };", g.expr(boolType, 0), g.expr(intType, 0))
+			return
+		}
+		g.line("%s;", g.expr(intType, 0))
+	case 10:
+		if g.closures >= maxClosures {
+			g.line("%s;", g.expr(intType, 0))
+			return
+		}
+		g.closure(depth)
+	case 11:
+		g.try(depth)
+	}
+}
+
+// try wraps a block in try/catch. The block may throw a value of any
+// type; the catch block binds the message of what it caught to a string.
+func (g *generator) try(depth int) {
+	g.line("try {")
+	if g.r.Intn(2) == 0 {
+		g.indent++
+		g.line("if (%s) { throw %s; };", g.expr(boolType, 0), g.expr(typ(g.r.Intn(int(numTypes))), 0))
+		g.indent--
+	}
+	g.block(depth)
+	caught, message := g.name("e"), g.name("v")
+	g.line("} catch (%s) {", caught)
+	g.scopes = append(g.scopes, []variable{{name: message, typ: stringType}})
+	g.indent++
+	g.line("let %s = %s[\"message\"];", message, caught)
+	for i, n := 0, g.r.Intn(3); i < n; i++ {
+		g.statement(depth + 1)
+	}
+	g.indent--
+	g.scopes = g.scopes[:len(g.scopes)-1]
+	g.line("};")
+}
+
+// expr returns an expression of type t. Past maxDepth it only returns
+// literals and variables.
+func (g *generator) expr(t typ, depth int) string {
+	if depth >= maxDepth || g.r.Intn(4) == 0 {
+		if names := g.variables(t, false); len(names) > 0 && g.r.Intn(2) == 0 {
+			return names[g.r.Intn(len(names))]
+		}
+		return g.literal(t)
+	}
+	d := depth + 1
+	switch t {
+	case intType:
+		switch g.r.Intn(9) {
+		case 0:
+			return fmt.Sprintf("-%s", g.expr(intType, d))
+		case 1:
+			return fmt.Sprintf("len(%s)", g.expr(stringType, d))
+		case 2:
+			return fmt.Sprintf("len(%s)", g.expr(arrayType, d))
+		case 3:
+			return fmt.Sprintf("%s[%d]", g.expr(arrayType, d), g.r.Intn(4))
+		case 4:
+			return fmt.Sprintf("%s[%q]", g.expr(hashType, d), g.key())
+		case 5:
+			if call, ok := g.call(d); ok {
+				return call
+			}
+			fallthrough
+		case 6:
+			return fmt.Sprintf("if (%s) { %s } else { %s }",
+				g.expr(boolType, d), g.expr(intType, d), g.expr(intType, d))
+		default:
+			op := []string{"+", "-", "*", "/"}[g.r.Intn(4)]
+			return fmt.Sprintf("(%s %s %s)", g.expr(intType, d), op, g.expr(intType, d))
+		}
+	case boolType:
+		switch g.r.Intn(6) {
+		case 0:
+			return fmt.Sprintf("!%s", g.expr(boolType, d))
+		case 1:
+			op := []string{"==", "!="}[g.r.Intn(2)]
+			t := typ(g.r.Intn(int(numTypes)))
+			return fmt.Sprintf("(%s %s %s)", g.expr(t, d), op, g.expr(t, d))
+		case 2:
+			op := []string{"<", ">"}[g.r.Intn(2)]
+			return fmt.Sprintf("(%s %s %s)", g.expr(stringType, d), op, g.expr(stringType, d))
+		default:
+			op := []string{"<", ">", "==", "!="}[g.r.Intn(4)]
+			return fmt.Sprintf("(%s %s %s)", g.expr(intType, d), op, g.expr(intType, d))
+		}
+	case stringType:
+		switch g.r.Intn(3) {
+		case 0:
+			return fmt.Sprintf("string(%s)", g.expr(intType, d))
+		default:
+			return fmt.Sprintf("(%s + %s)", g.expr(stringType, d), g.expr(stringType, d))
+		}
+	case arrayType:
+		switch g.r.Intn(3) {
+		case 0:
+			return fmt.Sprintf("push(%s, %s)", g.expr(arrayType, d), g.expr(intType, d))
+		case 1:
+			return fmt.Sprintf("rest(%s)", g.expr(arrayType, d))
+		default:
+			return g.literal(arrayType)
+		}
+	case hashType:
+		if g.r.Intn(2) == 0 {
+			return fmt.Sprintf("delete(%s, %q)", g.expr(hashType, d), g.key())
+		}
+	}
+	return g.literal(t)
+}
+
+// call returns a call of a global function, outside any function, or of
+// a visible closure, if there is one to call.
+func (g *generator) call(depth int) (string, bool) {
+	closures := g.visible(closureType, false)
+	n := len(closures)
+	if !g.inFunc {
+		n += len(g.funcs)
+	}
+	if n == 0 {
+		return "", false
+	}
+	i := g.r.Intn(n)
+	if i < len(closures) {
+		c := closures[i]
+		args := make([]string, c.arity)
+		for j := range args {
+			args[j] = g.expr(intType, depth)
+		}
+		return fmt.Sprintf("%s(%s)", c.name, strings.Join(args, ", ")), true
+	}
+	i -= len(closures)
+	f := g.funcs[i]
+	args := make([]string, f.arity)
+	for j := range args {
+		if j == 0 && f.recursive {
+			args[j] = fmt.Sprint(g.r.Intn(maxLoopCount + 1))
+			continue
+		}
+		args[j] = g.expr(intType, depth)
+	}
+	return fmt.Sprintf("f_%s(%s)", letters(i), strings.Join(args, ", ")), true
+}
+
+func (g *generator) key() string {
+	return []string{"a", "b", "c"}[g.r.Intn(3)]
+}
+
+func (g *generator) literal(t typ) string {
+	switch t {
+	case intType:
+		if g.r.Intn(8) == 0 {
+			return fmt.Sprint(g.r.Int63())
+		}
+		return fmt.Sprint(g.r.Intn(10))
+	case boolType:
+		return fmt.Sprint(g.r.Intn(2) == 0)
+	case stringType:
+		return fmt.Sprintf("%q", []string{"", "a", "bc", "gs"}[g.r.Intn(4)])
+	case arrayType:
+		elements := make([]string, g.r.Intn(4))
+		for i := range elements {
+			elements[i] = g.expr(intType, maxDepth)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case hashType:
+		var pairs []string
+		for _, k := range []string{"a", "b", "c"} {
+			if g.r.Intn(2) == 0 {
+				pairs = append(pairs, fmt.Sprintf("%q: %s", k, g.expr(intType, maxDepth)))
+			}
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	}
+	panic(fmt.Sprintf("difftest: unknown type %d", t))
+}
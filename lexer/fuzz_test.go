@@ -0,0 +1,31 @@
+package lexer
+
+import (
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/gtoken"
+)
+
+func FuzzLexer(f *testing.F) {
+	for _, seed := range []string{
+		"let x = 5; x + 10;",
+		`let s = "str"; // comment`,
+		"fn(a, b) { a / b }",
+		`{"a": [1, 2], "b": !true}`,
+		`"unterminated`,
+		"1 != 2 == 3 < 4 > 5 ? @",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		l := NewLexer(input)
+		// Every token consumes at least one byte, so EOF comes within
+		// len(input)+1 tokens.
+		for i := 0; i <= len(input); i++ {
+			if l.NextTokenMake().Type == gtoken.EOF {
+				return
+			}
+		}
+		t.Fatalf("no EOF after %d tokens", len(input)+1)
+	})
+}
@@ -1,6 +1,10 @@
 package lexer
 
-import "github.com/GhostNet-Dev/gscript/gtoken"
+import (
+	"strings"
+
+	"github.com/GhostNet-Dev/gscript/gtoken"
+)
 
 type Lexer struct {
 	input            string
@@ -8,6 +12,9 @@ type Lexer struct {
 	nextReadPosition int
 	ch               byte
 	line             int
+	// lineStart is the position of the first byte of the line.
+	lineStart int
+	comments  []gtoken.Token
 }
 
 func NewLexer(input string) *Lexer {
@@ -21,17 +28,21 @@ func NewLexer(input string) *Lexer {
 
 func (l *Lexer) readChar() {
 	if l.nextReadPosition >= len(l.input) {
+		// The position stays at the end of the input, so every EOF token
+		// is in the same place.
 		l.ch = 0
+		l.position = len(l.input)
 	} else {
 		l.ch = l.input[l.nextReadPosition]
+		l.position = l.nextReadPosition
 	}
-	l.position = l.nextReadPosition
-	l.nextReadPosition++
+	l.nextReadPosition = l.position + 1
 }
 
 func (l *Lexer) NextTokenMake() gtoken.Token {
 	var tok gtoken.Token
 	l.skipWhiteSpace()
+	line, column := l.line, l.position-l.lineStart+1
 
 	switch l.ch {
 	case '=':
@@ -39,7 +50,7 @@ func (l *Lexer) NextTokenMake() gtoken.Token {
 			ch := l.ch
 			l.readChar()
 			literal := string(ch) + string(l.ch)
-			tok = gtoken.Token{Type: gtoken.EQ, Literal: literal}
+			tok = gtoken.Token{Type: gtoken.EQ, Literal: literal, Line: line}
 		} else {
 			tok = gtoken.NewToken(gtoken.ASSIGN, l.ch, l.line)
 		}
@@ -52,7 +63,7 @@ func (l *Lexer) NextTokenMake() gtoken.Token {
 			ch := l.ch
 			l.readChar()
 			literal := string(ch) + string(l.ch)
-			tok = gtoken.Token{Type: gtoken.NOT_EQ, Literal: literal}
+			tok = gtoken.Token{Type: gtoken.NOT_EQ, Literal: literal, Line: line}
 		} else {
 			tok = gtoken.NewToken(gtoken.BANG, l.ch, l.line)
 		}
@@ -82,26 +93,33 @@ func (l *Lexer) NextTokenMake() gtoken.Token {
 		tok = gtoken.NewToken(gtoken.LBRACKET, l.ch, l.line)
 	case ']':
 		tok = gtoken.NewToken(gtoken.RBRACKET, l.ch, l.line)
+	case '?':
+		tok = gtoken.NewToken(gtoken.QUESTION, l.ch, l.line)
 	case '"':
 		tok.Type = gtoken.STRING
 		tok.Literal = l.readString()
+		tok.Line = line
 	case 0:
 		tok.Literal = ""
 		tok.Type = gtoken.EOF
+		tok.Line = line
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = gtoken.LookupIdent(tok.Literal)
+			tok.Line, tok.Column = line, column
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Type = gtoken.INT
 			tok.Literal = l.readNumber()
+			tok.Line, tok.Column = line, column
 			return tok
 		} else {
 			tok = gtoken.NewToken(gtoken.ILLEGAL, l.ch, l.line)
 		}
 	}
 
+	tok.Column = column
 	l.readChar()
 	return tok
 }
@@ -115,14 +133,41 @@ func (l *Lexer) readIdentifier() string {
 }
 
 func (l *Lexer) skipWhiteSpace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' || l.ch == '\n' {
-		if l.ch == '\n' {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\r':
+		case l.ch == '\n':
 			l.line++
+			l.lineStart = l.position + 1
+		case l.ch == '/' && l.peekChar() == '/':
+			l.readComment()
+			continue
+		default:
+			return
 		}
 		l.readChar()
 	}
 }
 
+// readComment reads a comment up to the end of the line.
+func (l *Lexer) readComment() {
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	l.comments = append(l.comments, gtoken.Token{
+		Type:    gtoken.COMMENT,
+		Literal: strings.TrimRight(l.input[position:l.position], " \t\r"),
+		Line:    l.line,
+		Column:  position - l.lineStart + 1,
+	})
+}
+
+// Comments returns the comments read so far, in order.
+func (l *Lexer) Comments() []gtoken.Token {
+	return l.comments
+}
+
 func (l *Lexer) readString() string {
 	position := l.position + 1
 	for {
@@ -130,6 +175,10 @@ func (l *Lexer) readString() string {
 		if l.ch == '"' || l.ch == 0 {
 			break
 		}
+		if l.ch == '\n' {
+			l.line++
+			l.lineStart = l.position + 1
+		}
 	}
 	return l.input[position:l.position]
 }
@@ -151,3 +151,48 @@ func testLexing(t *testing.T, input string, tests []ExpectedData) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = \"a\nb\";\nx == 10\n"
+	expected := []struct {
+		literal string
+		line    int
+		column  int
+	}{
+		{"let", 1, 1}, {"x", 1, 5}, {"=", 1, 7}, {"a\nb", 1, 9}, {";", 2, 3},
+		{"x", 3, 1}, {"==", 3, 3}, {"10", 3, 6}, {"", 4, 1}, {"", 4, 1},
+	}
+	l := NewLexer(input)
+	for i, tt := range expected {
+		tok := l.NextTokenMake()
+		if tok.Literal != tt.literal || tok.Line != tt.line || tok.Column != tt.column {
+			t.Fatalf("tests[%d] - wrong token. expected=%q at %d:%d, got=%q at %d:%d",
+				i, tt.literal, tt.line, tt.column, tok.Literal, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// add\nlet x = 10 / 2; // half  \nx //\n"
+	expected := []string{"let", "x", "=", "10", "/", "2", ";", "x", ""}
+	l := NewLexer(input)
+	for i, literal := range expected {
+		if tok := l.NextTokenMake(); tok.Literal != literal {
+			t.Fatalf("tests[%d] - wrong token. expected=%q, got=%q", i, literal, tok.Literal)
+		}
+	}
+	comments := l.Comments()
+	want := []struct {
+		literal      string
+		line, column int
+	}{{"// add", 1, 1}, {"// half", 2, 17}, {"//", 3, 3}}
+	if len(comments) != len(want) {
+		t.Fatalf("wrong number of comments. want=%d, got=%d", len(want), len(comments))
+	}
+	for i, c := range comments {
+		if c.Type != gtoken.COMMENT || c.Literal != want[i].literal || c.Line != want[i].line || c.Column != want[i].column {
+			t.Errorf("comments[%d] wrong. want=%q at %d:%d, got=%q at %d:%d",
+				i, want[i].literal, want[i].line, want[i].column, c.Literal, c.Line, c.Column)
+		}
+	}
+}
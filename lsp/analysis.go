@@ -0,0 +1,437 @@
+package lsp
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/GhostNet-Dev/gscript/ast"
+	"github.com/GhostNet-Dev/gscript/compiler"
+	"github.com/GhostNet-Dev/gscript/gtoken"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/object"
+	"github.com/GhostNet-Dev/gscript/parser"
+)
+
+// pos is a position in a document, counting lines and bytes from 1.
+type pos struct {
+	line, column int
+}
+
+func (p pos) before(q pos) bool {
+	return p.line < q.line || p.line == q.line && p.column < q.column
+}
+
+func start(tok gtoken.Token) pos {
+	return pos{tok.Line, tok.Column}
+}
+
+// end returns the position just past tok, quotes and line breaks of a
+// string included.
+func end(tok gtoken.Token) pos {
+	_, e := ast.TokenSpan(tok)
+	return pos{e.Line, e.Column}
+}
+
+// definition is a name a program binds: a let binding, a parameter or a
+// caught error.
+type definition struct {
+	name  string
+	token gtoken.Token
+	// kind is what the name is, as hover shows it: global, local,
+	// parameter or error.
+	kind  string
+	value ast.Expression
+	refs  []gtoken.Token
+
+	// The name is visible from visible to the end of its scope.
+	visible, scopeEnd pos
+	// statement and last delimit the statement binding the name.
+	statement, last gtoken.Token
+	// children are the names bound in the function the name is bound to.
+	children []*definition
+}
+
+// occurrence is a name in the source and what it refers to: one of def
+// and builtin is set.
+type occurrence struct {
+	token   gtoken.Token
+	def     *definition
+	builtin string
+}
+
+// diagnostic is an error in a document.
+type diagnostic struct {
+	from, to pos
+	message  string
+}
+
+// analysis is what the server knows about a document.
+type analysis struct {
+	diagnostics []diagnostic
+	// defs are all definitions in the order they occur and symbols the
+	// outermost ones, with the others nested in them.
+	defs        []*definition
+	symbols     []*definition
+	occurrences []occurrence
+}
+
+func analyze(text string) *analysis {
+	a := &analysis{}
+	p := parser.NewParser(lexer.NewLexer(text))
+	program := p.ParseProgram()
+	for _, e := range p.ErrorList() {
+		a.diagnostics = append(a.diagnostics, diagnostic{start(e.Token), end(e.Token), e.Message})
+	}
+
+	r := &resolver{a: a, table: compiler.NewSymbolTable()}
+	for i, v := range object.Builtins {
+		r.table.DefineBuiltin(i, v.Name)
+	}
+	r.scopes = []*scope{{defs: map[string]*definition{}, end: pos{1 << 30, 0}}}
+	for _, s := range program.Statements {
+		r.statement(s)
+	}
+
+	// The compiler finds the errors the resolver does not look for, but
+	// stops at the first one.
+	if len(a.diagnostics) == 0 {
+		if err := compiler.NewCompiler().Compile(program); err != nil {
+			d := diagnostic{pos{1, 1}, pos{1, 1}, err.Error()}
+			var cerr *compiler.Error
+			if errors.As(err, &cerr) {
+				d.from, d.to = start(cerr.Token), end(cerr.Token)
+			}
+			a.diagnostics = append(a.diagnostics, d)
+		}
+	}
+	return a
+}
+
+// occurrenceAt returns the name at p.
+func (a *analysis) occurrenceAt(p pos) (occurrence, bool) {
+	for _, o := range a.occurrences {
+		if !p.before(start(o.token)) && p.before(end(o.token)) {
+			return o, true
+		}
+	}
+	return occurrence{}, false
+}
+
+// visibleAt returns the definitions that names at p refer to, by name.
+func (a *analysis) visibleAt(p pos) []*definition {
+	byName := make(map[string]*definition)
+	for _, d := range a.defs {
+		if !p.before(d.visible) && !d.scopeEnd.before(p) {
+			byName[d.name] = d
+		}
+	}
+	defs := make([]*definition, 0, len(byName))
+	for _, d := range byName {
+		defs = append(defs, d)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].name < defs[j].name })
+	return defs
+}
+
+// typeOf infers what expr evaluates to, for hover.
+func (a *analysis) typeOf(expr ast.Expression) string {
+	if ast.IsNil(expr) {
+		return "unknown"
+	}
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral:
+		return "integer"
+	case *ast.StringLiteral:
+		return "string"
+	case *ast.Boolean:
+		return "boolean"
+	case *ast.Null:
+		return "null"
+	case *ast.ArrayLiteral:
+		return "array"
+	case *ast.TupleLiteral:
+		return "tuple"
+	case *ast.HashLiteral:
+		return "hash"
+	case *ast.FunctionLiteral:
+		return signature(expr)
+	case *ast.PrefixExpression:
+		if expr.Operator == "!" {
+			return "boolean"
+		}
+		return "integer"
+	case *ast.InfixExpression:
+		switch expr.Operator {
+		case "==", "!=", "<", ">":
+			return "boolean"
+		case "=":
+			return a.typeOf(expr.Right)
+		}
+		left, right := a.typeOf(expr.Left), a.typeOf(expr.Right)
+		if left == right && (left == "integer" || expr.Operator == "+" && left == "string") {
+			return left
+		}
+	case *ast.Identifier:
+		if o, ok := a.occurrenceAt(start(expr.Token)); ok && o.def != nil && o.def.value != nil {
+			return a.typeOf(o.def.value)
+		}
+	case *ast.CallExpression:
+		if ident, ok := expr.Function.(*ast.Identifier); ok {
+			if o, ok := a.occurrenceAt(start(ident.Token)); ok && o.builtin != "" {
+				if result, ok := builtinResults[o.builtin]; ok {
+					return result
+				}
+			}
+		}
+	}
+	return "unknown"
+}
+
+// builtinResults are the types of the values builtins return, where they
+// are always the same.
+var builtinResults = map[string]string{
+	"len":      "integer",
+	"int":      "integer",
+	"string":   "string",
+	"rest":     "array",
+	"push":     "array",
+	"map":      "array",
+	"filter":   "array",
+	"error":    "error",
+	"is_error": "boolean",
+	"delete":   "hash",
+	"range":    "range",
+}
+
+func signature(fn *ast.FunctionLiteral) string {
+	params := make([]string, len(fn.Parameters))
+	for i, p := range fn.Parameters {
+		params[i] = p.Value
+	}
+	return "fn(" + strings.Join(params, ", ") + ")"
+}
+
+// scope is a block or function the resolver is in.
+type scope struct {
+	defs map[string]*definition
+	end  pos
+}
+
+// resolver finds the definitions of a program and what its names refer
+// to. It scopes names with a compiler.SymbolTable, as the compiler does,
+// and keeps the definition behind each symbol alongside.
+type resolver struct {
+	a      *analysis
+	table  *compiler.SymbolTable
+	scopes []*scope
+	// owner is the definition of the function being resolved, which the
+	// definitions in it are children of.
+	owner *definition
+}
+
+func (r *resolver) enter(table *compiler.SymbolTable, end gtoken.Token) {
+	r.table = table
+	r.scopes = append(r.scopes, &scope{defs: map[string]*definition{}, end: start(end)})
+}
+
+func (r *resolver) leave() {
+	r.table = r.table.Outer
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+// define binds name in the current scope.
+func (r *resolver) define(d *definition) {
+	symbol := r.table.Define(d.name)
+	if d.kind == "" {
+		d.kind = strings.ToLower(string(symbol.Scope))
+	}
+	d.scopeEnd = r.scopes[len(r.scopes)-1].end
+	r.scopes[len(r.scopes)-1].defs[d.name] = d
+	r.a.defs = append(r.a.defs, d)
+	if r.owner != nil {
+		r.owner.children = append(r.owner.children, d)
+	} else {
+		r.a.symbols = append(r.a.symbols, d)
+	}
+	r.a.occurrences = append(r.a.occurrences, occurrence{token: d.token, def: d})
+}
+
+// use records a name referring to a definition.
+func (r *resolver) use(ident *ast.Identifier) {
+	symbol, ok := r.table.Resolve(ident.Value)
+	if !ok {
+		r.a.diagnostics = append(r.a.diagnostics,
+			diagnostic{start(ident.Token), end(ident.Token), "undefined variable " + ident.Value})
+		return
+	}
+	if symbol.Scope == compiler.BuiltinScope {
+		r.a.occurrences = append(r.a.occurrences, occurrence{token: ident.Token, builtin: ident.Value})
+		return
+	}
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if d, ok := r.scopes[i].defs[ident.Value]; ok {
+			d.refs = append(d.refs, ident.Token)
+			r.a.occurrences = append(r.a.occurrences, occurrence{token: ident.Token, def: d})
+			return
+		}
+	}
+}
+
+func (r *resolver) statement(s ast.Statement) {
+	if ast.IsNil(s) {
+		return
+	}
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		if s.Name == nil {
+			return
+		}
+		d := &definition{name: s.Name.Value, token: s.Name.Token, value: s.Value, statement: s.Token, last: s.Name.Token}
+		if fn, ok := s.Value.(*ast.FunctionLiteral); ok && fn != nil && fn.Body != nil {
+			d.last = fn.Body.Rbrace
+			r.function(fn, d)
+		} else {
+			r.expression(s.Value)
+		}
+		d.visible = end(s.Name.Token)
+		r.define(d)
+	case *ast.ExpressionStatement:
+		r.expression(s.Expression)
+	case *ast.ReturnStatement:
+		r.expression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		r.expression(s.Value)
+	case *ast.BlockStatement:
+		r.block(s)
+	case *ast.TryStatement:
+		r.block(s.Block)
+		if s.Catch != nil {
+			r.enter(compiler.NewBlockSymbolTable(r.table), s.Catch.Rbrace)
+			if s.Param != nil {
+				r.define(&definition{
+					name: s.Param.Value, token: s.Param.Token, kind: "error",
+					visible: end(s.Param.Token), statement: s.Param.Token, last: s.Param.Token,
+				})
+			}
+			r.block(s.Catch)
+			r.leave()
+		}
+		r.block(s.Finally)
+	}
+}
+
+func (r *resolver) block(b *ast.BlockStatement) {
+	if b == nil {
+		return
+	}
+	r.enter(compiler.NewBlockSymbolTable(r.table), b.Rbrace)
+	for _, s := range b.Statements {
+		r.statement(s)
+	}
+	r.leave()
+}
+
+// function resolves fn, the value of the definition owner if it is not
+// nil.
+func (r *resolver) function(fn *ast.FunctionLiteral, owner *definition) {
+	r.enter(compiler.NewEnclosedSymbolTable(r.table), fn.Body.Rbrace)
+	outer := r.owner
+	if owner != nil {
+		r.owner = owner
+	}
+	if fn.Name != "" && owner != nil {
+		r.table.DefineFunctionName(fn.Name)
+		r.scopes[len(r.scopes)-1].defs[fn.Name] = owner
+	}
+	for _, p := range fn.Parameters {
+		r.define(&definition{
+			name: p.Value, token: p.Token, kind: "parameter",
+			visible: end(p.Token), statement: p.Token, last: p.Token,
+		})
+	}
+	r.block(fn.Body)
+	r.owner = outer
+	r.leave()
+}
+
+func (r *resolver) expression(e ast.Expression) {
+	if ast.IsNil(e) {
+		return
+	}
+	switch e := e.(type) {
+	case *ast.Identifier:
+		r.use(e)
+	case *ast.PrefixExpression:
+		r.expression(e.Right)
+	case *ast.InfixExpression:
+		r.expression(e.Left)
+		r.expression(e.Right)
+	case *ast.IfExpression:
+		r.expression(e.Condition)
+		r.block(e.Consequence)
+		r.block(e.Alternative)
+	case *ast.ForExpression:
+		if e.Consequence == nil {
+			return
+		}
+		r.enter(compiler.NewBlockSymbolTable(r.table), e.Consequence.Rbrace)
+		r.statement(e.Init)
+		r.expression(e.Condition)
+		r.block(e.Consequence)
+		r.expression(e.Increment)
+		r.leave()
+	case *ast.ForInExpression:
+		r.expression(e.Iterable)
+		if e.Consequence == nil {
+			return
+		}
+		r.enter(compiler.NewBlockSymbolTable(r.table), e.Consequence.Rbrace)
+		for _, v := range e.Variables {
+			r.define(&definition{
+				name: v.Value, token: v.Token,
+				visible: end(v.Token), statement: v.Token, last: v.Token,
+			})
+		}
+		r.block(e.Consequence)
+		r.leave()
+	case *ast.FunctionLiteral:
+		if e.Body != nil {
+			r.function(e, nil)
+		}
+	case *ast.CallExpression:
+		r.expression(e.Function)
+		for _, arg := range e.Arguments {
+			r.expression(arg)
+		}
+	case *ast.IndexExpression:
+		r.expression(e.Left)
+		r.expression(e.Index)
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			r.expression(el)
+		}
+	case *ast.TupleLiteral:
+		for _, el := range e.Elements {
+			r.expression(el)
+		}
+	case *ast.HashLiteral:
+		// Keys are resolved in source order, so references are listed in
+		// the order they occur.
+		keys := make([]ast.Expression, 0, len(e.Pairs))
+		for k := range e.Pairs {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, _ := ast.Span(keys[i])
+			b, _ := ast.Span(keys[j])
+			return a.Before(b)
+		})
+		for _, k := range keys {
+			r.expression(k)
+			r.expression(e.Pairs[k])
+		}
+	case *ast.PropagateExpression:
+		r.expression(e.Value)
+	}
+}
@@ -0,0 +1,74 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/internal/framing"
+)
+
+// TestLSP replays the sessions recorded in testdata. Each entry is a
+// message from the client or one the server is expected to send; the
+// server handles messages in turn, so all the client's messages are sent
+// at once and the replies compared in order.
+func TestLSP(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.lsp.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no recorded sessions: %v", err)
+	}
+	for _, file := range files {
+		replay(t, file)
+	}
+}
+
+func replay(t *testing.T, file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []struct {
+		Client json.RawMessage
+		Server map[string]interface{}
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+
+	var in, out bytes.Buffer
+	var expected []map[string]interface{}
+	for _, e := range entries {
+		if e.Client != nil {
+			if err := framing.Write(&in, e.Client); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected = append(expected, e.Server)
+	}
+	if err := Serve(&in, &out); err != nil {
+		t.Fatalf("%s: server error: %s", file, err)
+	}
+
+	r := bufio.NewReader(&out)
+	for i, want := range expected {
+		data, err := framing.Read(r)
+		if err != nil {
+			t.Fatalf("%s: server stopped before message %d: %s", file, i, err)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: bad message %q", file, data)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: wrong message %d.\nwant=%v\ngot=%v", file, i, want, got)
+		}
+	}
+	if data, err := framing.Read(r); err == nil {
+		t.Errorf("%s: unexpected message %s", file, data)
+	}
+}
@@ -0,0 +1,366 @@
+// Package lsp serves the Language Server Protocol for gscript, so editors
+// can show errors in scripts and navigate them.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/GhostNet-Dev/gscript/internal/framing"
+	"github.com/GhostNet-Dev/gscript/object"
+)
+
+// JSON-RPC error codes.
+const (
+	methodNotFound = -32601
+	invalidParams  = -32602
+)
+
+// Kinds of completion items and document symbols in the protocol.
+const (
+	completionFunction = 3
+	completionVariable = 6
+	symbolFunction     = 12
+	symbolVariable     = 13
+)
+
+type message struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type span struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string `json:"uri"`
+	Range span   `json:"range"`
+}
+
+type textDocument struct {
+	URI     string `json:"uri"`
+	Text    string `json:"text"`
+	Version int    `json:"version"`
+}
+
+type positionParams struct {
+	TextDocument textDocument `json:"textDocument"`
+	Position     position     `json:"position"`
+	Context      struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+// document is an open file.
+type document struct {
+	lines    []string
+	analysis *analysis
+}
+
+func newDocument(text string) *document {
+	return &document{lines: strings.Split(text, "\n"), analysis: analyze(text)}
+}
+
+// position converts p to a protocol position, which counts from 0 and in
+// UTF-16 code units.
+func (d *document) position(p pos) position {
+	line := p.line - 1
+	if line < 0 || line >= len(d.lines) {
+		return position{Line: line}
+	}
+	text := d.lines[line]
+	if p.column-1 < len(text) {
+		text = text[:p.column-1]
+	}
+	return position{Line: line, Character: len(utf16.Encode([]rune(text)))}
+}
+
+// pos converts a protocol position to a pos.
+func (d *document) pos(p position) pos {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return pos{p.Line + 1, 1}
+	}
+	text, units := d.lines[p.Line], 0
+	for i, r := range text {
+		if units >= p.Character {
+			return pos{p.Line + 1, i + 1}
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return pos{p.Line + 1, len(text) + 1}
+}
+
+func (d *document) span(from, to pos) span {
+	return span{d.position(from), d.position(to)}
+}
+
+type server struct {
+	out       io.Writer
+	documents map[string]*document
+}
+
+// Serve reads Language Server Protocol messages from in and writes the
+// replies to out, until the client exits or in ends.
+func Serve(in io.Reader, out io.Writer) error {
+	s := &server{out: out, documents: make(map[string]*document)}
+	r := bufio.NewReader(in)
+	for {
+		data, err := framing.Read(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return fmt.Errorf("bad message: %w", err)
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			// Notifications have no reply.
+			continue
+		}
+		reply := map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID}
+		if rerr, ok := err.(*rpcError); ok {
+			reply["error"] = rerr
+		} else if err != nil {
+			return err
+		} else {
+			reply["result"] = result
+		}
+		if err := framing.Write(out, reply); err != nil {
+			return err
+		}
+	}
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func (s *server) handle(msg message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+				"completionProvider":     map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "gscript"},
+		}, nil
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument textDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument   textDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		// Documents are synchronized in full, so the last change is the
+		// whole text.
+		if n := len(params.ContentChanges); n > 0 {
+			return nil, s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument textDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return nil, s.publish(params.TextDocument.URI, nil)
+	case "textDocument/definition", "textDocument/references", "textDocument/hover", "textDocument/completion":
+		var params positionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &rpcError{invalidParams, err.Error()}
+		}
+		doc, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return nil, &rpcError{invalidParams, "document not open: " + params.TextDocument.URI}
+		}
+		p := doc.pos(params.Position)
+		switch msg.Method {
+		case "textDocument/definition":
+			return gotoDefinition(params.TextDocument.URI, doc, p), nil
+		case "textDocument/references":
+			return findReferences(params.TextDocument.URI, doc, p, params.Context.IncludeDeclaration), nil
+		case "textDocument/hover":
+			return hover(doc, p), nil
+		}
+		return completion(doc, p), nil
+	case "textDocument/documentSymbol":
+		var params positionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &rpcError{invalidParams, err.Error()}
+		}
+		doc, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return nil, &rpcError{invalidParams, "document not open: " + params.TextDocument.URI}
+		}
+		return symbols(doc, doc.analysis.symbols), nil
+	}
+	if msg.ID == nil {
+		// Notifications the server does not know are ignored.
+		return nil, nil
+	}
+	return nil, &rpcError{methodNotFound, "unknown method " + msg.Method}
+}
+
+// update analyzes the new text of a document and publishes its errors.
+func (s *server) update(uri, text string) error {
+	doc := newDocument(text)
+	s.documents[uri] = doc
+	return s.publish(uri, doc)
+}
+
+func (s *server) publish(uri string, doc *document) error {
+	diagnostics := []map[string]interface{}{}
+	if doc != nil {
+		for _, d := range doc.analysis.diagnostics {
+			diagnostics = append(diagnostics, map[string]interface{}{
+				"range":    doc.span(d.from, d.to),
+				"severity": 1,
+				"source":   "gscript",
+				"message":  d.message,
+			})
+		}
+	}
+	return framing.Write(s.out, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "textDocument/publishDiagnostics",
+		"params":  map[string]interface{}{"uri": uri, "diagnostics": diagnostics},
+	})
+}
+
+func gotoDefinition(uri string, doc *document, p pos) interface{} {
+	o, ok := doc.analysis.occurrenceAt(p)
+	if !ok || o.def == nil {
+		return nil
+	}
+	return location{uri, doc.span(start(o.def.token), end(o.def.token))}
+}
+
+func findReferences(uri string, doc *document, p pos, declaration bool) []location {
+	locations := []location{}
+	o, ok := doc.analysis.occurrenceAt(p)
+	if !ok || o.def == nil {
+		return locations
+	}
+	if declaration {
+		locations = append(locations, location{uri, doc.span(start(o.def.token), end(o.def.token))})
+	}
+	for _, ref := range o.def.refs {
+		locations = append(locations, location{uri, doc.span(start(ref), end(ref))})
+	}
+	return locations
+}
+
+func hover(doc *document, p pos) interface{} {
+	o, ok := doc.analysis.occurrenceAt(p)
+	if !ok {
+		return nil
+	}
+	var text string
+	if o.builtin != "" {
+		text = fmt.Sprintf("(builtin) %s", o.builtin)
+	} else {
+		text = fmt.Sprintf("(%s) %s: %s", o.def.kind, o.def.name, doc.analysis.typeOf(o.def.value))
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{
+			"kind":  "markdown",
+			"value": "```gscript\n" + text + "\n```",
+		},
+		"range": doc.span(start(o.token), end(o.token)),
+	}
+}
+
+type completionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+func completion(doc *document, p pos) []completionItem {
+	var items []completionItem
+	for _, d := range doc.analysis.visibleAt(p) {
+		kind, detail := completionVariable, d.kind
+		if typ := doc.analysis.typeOf(d.value); strings.HasPrefix(typ, "fn(") {
+			kind, detail = completionFunction, typ
+		}
+		items = append(items, completionItem{d.name, kind, detail})
+	}
+	for _, b := range object.Builtins {
+		items = append(items, completionItem{b.Name, completionFunction, "builtin"})
+	}
+	return items
+}
+
+type documentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail"`
+	Kind           int              `json:"kind"`
+	Range          span             `json:"range"`
+	SelectionRange span             `json:"selectionRange"`
+	Children       []documentSymbol `json:"children,omitempty"`
+}
+
+func symbols(doc *document, defs []*definition) []documentSymbol {
+	out := []documentSymbol{}
+	for _, d := range defs {
+		kind, detail := symbolVariable, d.kind
+		if typ := doc.analysis.typeOf(d.value); strings.HasPrefix(typ, "fn(") {
+			kind, detail = symbolFunction, typ
+		}
+		out = append(out, documentSymbol{
+			Name:           d.name,
+			Detail:         detail,
+			Kind:           kind,
+			Range:          doc.span(start(d.statement), end(d.last)),
+			SelectionRange: doc.span(start(d.token), end(d.token)),
+			Children:       symbols(doc, d.children),
+		})
+	}
+	return out
+}
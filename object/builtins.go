@@ -2,6 +2,8 @@ package object
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strconv"
 )
 
@@ -17,6 +19,8 @@ var Builtins = []struct {
 			switch arg := args[0].(type) {
 			case *Array:
 				return &Integer{Value: int64(len(arg.Elements))}
+			case *Tuple:
+				return &Integer{Value: int64(len(arg.Elements))}
 			case *String:
 				return &Integer{Value: int64(len(arg.Value))}
 			default:
@@ -26,8 +30,12 @@ var Builtins = []struct {
 	},
 	{"puts", &Builtin{
 		Fn: func(env interface{}, args ...Object) Object {
+			out := io.Writer(os.Stdout)
+			if printer, ok := env.(Printer); ok {
+				out = printer.Stdout()
+			}
 			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+				fmt.Fprintln(out, arg.Inspect())
 			}
 			return nil
 		}},
@@ -76,7 +84,7 @@ var Builtins = []struct {
 			if length > 0 {
 				newElements := make([]Object, length-1)
 				copy(newElements, arr.Elements[1:length])
-				return &Array{Elements: newElements}
+				return allocate(env, &Array{Elements: newElements})
 			}
 			return nil
 		}},
@@ -94,7 +102,7 @@ var Builtins = []struct {
 			newElements := make([]Object, length+1)
 			copy(newElements, arr.Elements)
 			newElements[length] = args[1]
-			return &Array{Elements: newElements}
+			return allocate(env, &Array{Elements: newElements})
 		}},
 	},
 	{"int", &Builtin{
@@ -122,11 +130,170 @@ var Builtins = []struct {
 			}
 			i := args[0].(*Integer)
 			str := fmt.Sprint(i.Value)
-			return &String{Value: str}
+			return allocate(env, &String{Value: str})
+		}},
+	},
+	{"map", &Builtin{
+		Fn: func(env interface{}, args ...Object) Object {
+			if len(args) != 2 {
+				return NewError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return NewError("argument to 'map' must be ARRAY, got %s", args[0].Type())
+			}
+			caller, ok := env.(Caller)
+			if !ok {
+				return NewError("'map' cannot call functions in this context")
+			}
+			arr := args[0].(*Array)
+			newElements := make([]Object, len(arr.Elements))
+			for i, e := range arr.Elements {
+				result, err := caller.Call(args[1], e)
+				if err != nil {
+					return callError(err)
+				}
+				newElements[i] = result
+			}
+			return allocate(env, &Array{Elements: newElements})
+		}},
+	},
+	{"filter", &Builtin{
+		Fn: func(env interface{}, args ...Object) Object {
+			if len(args) != 2 {
+				return NewError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return NewError("argument to 'filter' must be ARRAY, got %s", args[0].Type())
+			}
+			caller, ok := env.(Caller)
+			if !ok {
+				return NewError("'filter' cannot call functions in this context")
+			}
+			arr := args[0].(*Array)
+			newElements := []Object{}
+			for _, e := range arr.Elements {
+				result, err := caller.Call(args[1], e)
+				if err != nil {
+					return callError(err)
+				}
+				if isTruthy(result) {
+					newElements = append(newElements, e)
+				}
+			}
+			return allocate(env, &Array{Elements: newElements})
+		}},
+	},
+	{"error", &Builtin{
+		Fn: func(env interface{}, args ...Object) Object {
+			if len(args) != 1 {
+				return NewError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			msg, ok := args[0].(*String)
+			if !ok {
+				return NewError("argument to 'error' must be STRING, got %s", args[0].Type())
+			}
+			return allocate(env, &ErrorValue{Kind: ValueError, Message: msg.Value})
+		}},
+	},
+	{"is_error", &Builtin{
+		Fn: func(env interface{}, args ...Object) Object {
+			if len(args) != 1 {
+				return NewError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			_, ok := args[0].(*ErrorValue)
+			return &Boolean{Value: ok}
+		}},
+	},
+	{"delete", &Builtin{
+		Fn: func(env interface{}, args ...Object) Object {
+			if len(args) != 2 {
+				return NewError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return NewError("argument to 'delete' must be HASH, got %s", args[0].Type())
+			}
+			key, err := KeyOf(args[1])
+			if err != nil {
+				return NewError("%s", err)
+			}
+			newHash := NewHash(hash.Len())
+			for _, pair := range hash.Pairs() {
+				newHash.Set(pair.Key.(Hashable), pair.Value)
+			}
+			newHash.Delete(key)
+			return allocate(env, newHash)
+		}},
+	},
+	{"freeze", &Builtin{
+		Fn: func(env interface{}, args ...Object) Object {
+			if len(args) != 1 {
+				return NewError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			frozen, err := Freeze(args[0])
+			if err != nil {
+				return NewError("%s", err)
+			}
+			if frozen == args[0] {
+				return frozen
+			}
+			return allocate(env, frozen)
+		}},
+	},
+	{"range", &Builtin{
+		Fn: func(env interface{}, args ...Object) Object {
+			if len(args) < 1 || len(args) > 3 {
+				return NewError("wrong number of arguments. got=%d, want=1 to 3", len(args))
+			}
+			bounds := make([]int64, len(args))
+			for i, arg := range args {
+				n, ok := arg.(*Integer)
+				if !ok {
+					return NewError("arguments to 'range' must be INTEGER, got %s", arg.Type())
+				}
+				bounds[i] = n.Value
+			}
+			r := &Range{Step: 1}
+			switch len(bounds) {
+			case 1:
+				r.Stop = bounds[0]
+			case 2:
+				r.Start, r.Stop = bounds[0], bounds[1]
+			case 3:
+				r.Start, r.Stop, r.Step = bounds[0], bounds[1], bounds[2]
+			}
+			if r.Step == 0 {
+				return NewError("range step cannot be zero")
+			}
+			return allocate(env, r)
 		}},
 	},
 }
 
+// allocate reports obj to the engine's Allocator, returning an error in
+// its place if the memory limit is exceeded.
+func allocate(context interface{}, obj Object) Object {
+	if allocator, ok := context.(Allocator); ok {
+		if err := allocator.Allocate(obj); err != nil {
+			return NewError("%s", err)
+		}
+	}
+	return obj
+}
+
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	case nil:
+		return false
+	default:
+		return true
+	}
+}
+
 func GetBuiltinByName(name string) *Builtin {
 	for _, def := range Builtins {
 		if def.Name == name {
@@ -147,6 +314,15 @@ func SetBuiltinFunction(name string, builtIn *Builtin) *Builtin {
 	return newBuiltin.Builtin
 }
 
+// callError passes an exception raised by a callback on unchanged, so it
+// keeps its kind and trace.
+func callError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return NewError("%s", err)
+}
+
 func NewError(format string, a ...interface{}) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
 }
@@ -1,10 +1,16 @@
 package object
 
+import "fmt"
+
 type Environment struct {
 	store        map[string]Object
 	typeStore    map[string]*Environment
 	outer        *Environment
 	ProgramParam interface{}
+
+	// Caller is the engine evaluating this environment. Enclosed
+	// environments resolve it through their outer chain, see GetCaller.
+	Caller Caller
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -13,6 +19,21 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 	return env
 }
 
+// NewIterationEnvironment returns the scope of a loop's next iteration: a
+// copy of env, the scope of the current one, so that closures created in
+// an iteration keep seeing its bindings.
+func NewIterationEnvironment(env *Environment) *Environment {
+	next := NewEnvironment(env.ProgramParam)
+	next.outer = env.outer
+	for name, obj := range env.store {
+		if ident, ok := obj.(*Identifier); ok {
+			obj = &Identifier{Name: ident.Name, Value: ident.Value}
+		}
+		next.store[name] = obj
+	}
+	return next
+}
+
 func NewEnvironment(programParam interface{}) *Environment {
 	s := make(map[string]Object)
 	t := make(map[string]*Environment)
@@ -34,6 +55,7 @@ func (e *Environment) Set(name string, val Object) Object {
 
 func (e *Environment) TypeDefine(name string) *Environment {
 	newEnv := NewEnvironment(e.ProgramParam)
+	newEnv.Caller = e.GetCaller()
 	e.typeStore[name] = newEnv
 	return newEnv
 }
@@ -45,3 +67,33 @@ func (e *Environment) GetType(name string) (*Environment, bool) {
 	}
 	return obj, ok
 }
+
+// GetCaller returns the nearest Caller attached to e or its outer
+// environments, or nil if there is none.
+func (e *Environment) GetCaller() Caller {
+	for env := e; env != nil; env = env.outer {
+		if env.Caller != nil {
+			return env.Caller
+		}
+	}
+	return nil
+}
+
+// Call makes Environment a Caller, so builtins handed an environment as
+// their context can invoke functions through the attached engine.
+func (e *Environment) Call(fn Object, args ...Object) (Object, error) {
+	caller := e.GetCaller()
+	if caller == nil {
+		return nil, fmt.Errorf("no caller attached to environment")
+	}
+	return caller.Call(fn, args...)
+}
+
+// Allocate makes Environment an Allocator that forwards to the attached
+// engine, if it enforces a memory limit.
+func (e *Environment) Allocate(obj Object) error {
+	if allocator, ok := e.GetCaller().(Allocator); ok {
+		return allocator.Allocate(obj)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package object
+
+// Equal reports whether a and b are equal values, as == compares them in
+// both engines. Integers, strings, booleans, ranges and null compare by
+// value; arrays, tuples, hashes and structs compare structurally, element
+// by element. Values of different types are never equal, and anything
+// else, such as a function, is equal only to itself.
+func Equal(a, b Object) bool {
+	if a == b {
+		return true
+	}
+	switch a := a.(type) {
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i, e := range a.Elements {
+			if !Equal(e, b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Tuple:
+		b, ok := b.(*Tuple)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i, e := range a.Elements {
+			if !Equal(e, b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		for _, pair := range a.Pairs() {
+			other, ok := b.Get(pair.Key.(Hashable))
+			if !ok || !Equal(pair.Value, other) {
+				return false
+			}
+		}
+		return true
+	case *Range:
+		b, ok := b.(*Range)
+		return ok && *a == *b
+	case *Struct:
+		b, ok := b.(*Struct)
+		return ok && a.TypeName == b.TypeName && a.Name == b.Name && Equal(a.Value, b.Value)
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package object
+
+// Kinds of Error raised by the execution engines.
+const (
+	// RuntimeError is raised by the engine itself, e.g. on a type mismatch.
+	RuntimeError = "runtime"
+	// BuiltinError is returned by a builtin function.
+	BuiltinError = "builtin"
+	// ThrowError is raised by a throw statement.
+	ThrowError = "throw"
+	// ValueError is an error value made by the error builtin.
+	ValueError = "error"
+)
+
+// ErrorValue is an error as an ordinary script value; it is what a catch
+// clause binds. Its fields are read by indexing it with "message", "kind",
+// "trace" and "value".
+type ErrorValue struct {
+	Kind    string
+	Message string
+	// Trace lists the functions active when the error was raised, the
+	// innermost first and "<main>" last.
+	Trace []string
+	Value Object
+}
+
+func (o *ErrorValue) Type() ObjectType { return ERROR_VALUE_OBJ }
+func (o *ErrorValue) Inspect() string  { return "error: " + o.Message }
+
+// Field returns the named field of the error, or nil if it has none.
+func (o *ErrorValue) Field(name string) Object {
+	switch name {
+	case "message":
+		return &String{Value: o.Message}
+	case "kind":
+		return &String{Value: o.Kind}
+	case "trace":
+		elements := make([]Object, len(o.Trace))
+		for i, name := range o.Trace {
+			elements[i] = &String{Value: name}
+		}
+		return &Array{Elements: elements}
+	case "value":
+		return o.Value
+	}
+	return nil
+}
+
+// Catch returns the value a catch clause binds for the exception.
+func (o *Error) Catch() *ErrorValue {
+	kind := o.Kind
+	if kind == "" {
+		kind = RuntimeError
+	}
+	return &ErrorValue{Kind: kind, Message: o.Message, Trace: o.Trace, Value: o.Value}
+}
+
+// Throw returns the exception raised by throwing value. A caught error is
+// rethrown as it was, trace included.
+func Throw(value Object) *Error {
+	if ev, ok := value.(*ErrorValue); ok {
+		return &Error{Kind: ev.Kind, Message: ev.Message, Trace: ev.Trace, Value: ev.Value}
+	}
+	return &Error{Kind: ThrowError, Message: value.Inspect(), Value: value}
+}
+
+// MainFrame names the top level of a program in an error trace, and
+// AnonymousFrame a function without a name.
+const (
+	MainFrame      = "<main>"
+	AnonymousFrame = "<anonymous>"
+)
@@ -0,0 +1,97 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+// Hash maps keys to values. Keys are found by their HashKey and compared
+// with Equal, so colliding keys are kept apart, and pairs are iterated and
+// printed in the order their keys were first set. The zero Hash is empty
+// and ready to use.
+type Hash struct {
+	pairs []HashPair
+	// index maps each HashKey to the positions in pairs of the keys that
+	// hash to it.
+	index map[HashKey][]int
+}
+
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) *Hash {
+	return &Hash{pairs: make([]HashPair, 0, size), index: make(map[HashKey][]int, size)}
+}
+
+func (o *Hash) Type() ObjectType { return HASH_OBJ }
+func (o *Hash) Inspect() string {
+	var out bytes.Buffer
+	pairs := []string{}
+	for _, pair := range o.pairs {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	}
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+
+// Len returns the number of pairs in the hash.
+func (o *Hash) Len() int { return len(o.pairs) }
+
+// Pairs returns the pairs of the hash in insertion order. The slice
+// belongs to the hash and must not be modified.
+func (o *Hash) Pairs() []HashPair { return o.pairs }
+
+// find returns the position of key in pairs, or -1.
+func (o *Hash) find(key Hashable) int {
+	for _, i := range o.index[key.HashKey()] {
+		if Equal(o.pairs[i].Key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the value of key and whether the hash has it.
+func (o *Hash) Get(key Hashable) (Object, bool) {
+	if i := o.find(key); i >= 0 {
+		return o.pairs[i].Value, true
+	}
+	return nil, false
+}
+
+// Set sets the value of key. A new key goes last in the iteration order;
+// an existing key keeps its place.
+func (o *Hash) Set(key Hashable, value Object) {
+	if i := o.find(key); i >= 0 {
+		o.pairs[i].Value = value
+		return
+	}
+	if o.index == nil {
+		o.index = map[HashKey][]int{}
+	}
+	hashed := key.HashKey()
+	o.index[hashed] = append(o.index[hashed], len(o.pairs))
+	o.pairs = append(o.pairs, HashPair{Key: key, Value: value})
+}
+
+// Delete removes key from the hash and reports whether it was there.
+func (o *Hash) Delete(key Hashable) bool {
+	i := o.find(key)
+	if i < 0 {
+		return false
+	}
+	o.pairs = append(o.pairs[:i], o.pairs[i+1:]...)
+	// Rebuild the index: the positions of every later pair moved down.
+	o.index = make(map[HashKey][]int, len(o.pairs))
+	for j, pair := range o.pairs {
+		hashed := pair.Key.(Hashable).HashKey()
+		o.index[hashed] = append(o.index[hashed], j)
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Iterator steps through the elements of a collection for a for-in loop.
+// Next returns the key and value of the next element, and false once
+// there are none left.
+type Iterator interface {
+	Next() (key, value Object, ok bool)
+}
+
+// Iterable is implemented by values other than the builtin collections
+// that for-in loops can step through, such as objects a host provides.
+type Iterable interface {
+	Object
+	Iterate() Iterator
+}
+
+// Iter is the state of a for-in loop, which the VM keeps on its stack.
+type Iter struct {
+	Iterator
+	// keys is set for a hash, whose loops of one variable bind its keys
+	// where those of other collections bind values.
+	keys bool
+}
+
+func (o *Iter) Type() ObjectType { return ITER_OBJ }
+func (o *Iter) Inspect() string  { return "iterator" }
+
+// NextFor returns the next element as a loop of vars variables binds it:
+// two variables take its key and value, and one takes its value, or its
+// key in a hash, which NextFor returns as value with a nil key. A host's
+// iterator may leave the key or value nil too.
+func (o *Iter) NextFor(vars int) (key, value Object, ok bool) {
+	key, value, ok = o.Next()
+	if vars != 1 {
+		return key, value, ok
+	}
+	if o.keys {
+		return nil, key, ok
+	}
+	return nil, value, ok
+}
+
+// Iterate returns the state of a loop over obj. Arrays and tuples yield
+// their elements, strings their characters, by rune, and ranges their
+// numbers, keyed by position; hashes yield their pairs in insertion
+// order, and structs the elements of their value.
+func Iterate(obj Object) (*Iter, error) {
+	switch obj := obj.(type) {
+	case *Array:
+		return &Iter{Iterator: &sliceIterator{elements: obj.Elements}}, nil
+	case *Tuple:
+		return &Iter{Iterator: &sliceIterator{elements: obj.Elements}}, nil
+	case *String:
+		return &Iter{Iterator: &stringIterator{reader: strings.NewReader(obj.Value)}}, nil
+	case *Range:
+		return &Iter{Iterator: &rangeIterator{r: obj, next: obj.Start}}, nil
+	case *Hash:
+		return &Iter{Iterator: &hashIterator{pairs: obj.Pairs()}, keys: true}, nil
+	case *Struct:
+		if obj.Value != nil {
+			return Iterate(obj.Value)
+		}
+	case Iterable:
+		return &Iter{Iterator: obj.Iterate()}, nil
+	}
+	return nil, fmt.Errorf("%s is not iterable", obj.Type())
+}
+
+type sliceIterator struct {
+	elements []Object
+	i        int
+}
+
+func (it *sliceIterator) Next() (Object, Object, bool) {
+	if it.i >= len(it.elements) {
+		return nil, nil, false
+	}
+	it.i++
+	return &Integer{Value: int64(it.i - 1)}, it.elements[it.i-1], true
+}
+
+type stringIterator struct {
+	reader *strings.Reader
+	i      int64
+}
+
+func (it *stringIterator) Next() (Object, Object, bool) {
+	r, _, err := it.reader.ReadRune()
+	if err != nil {
+		return nil, nil, false
+	}
+	it.i++
+	return &Integer{Value: it.i - 1}, &String{Value: string(r)}, true
+}
+
+type hashIterator struct {
+	pairs []HashPair
+	i     int
+}
+
+func (it *hashIterator) Next() (Object, Object, bool) {
+	if it.i >= len(it.pairs) {
+		return nil, nil, false
+	}
+	it.i++
+	pair := it.pairs[it.i-1]
+	return pair.Key, pair.Value, true
+}
+
+type rangeIterator struct {
+	r    *Range
+	next int64
+	i    int64
+	done bool
+}
+
+func (it *rangeIterator) Next() (Object, Object, bool) {
+	if it.done || !it.r.contains(it.next) {
+		return nil, nil, false
+	}
+	value := it.next
+	it.next += it.r.Step
+	// Stepping past the largest integer ends the range rather than
+	// wrapping around.
+	it.done = (it.r.Step > 0) != (it.next > value)
+	it.i++
+	return &Integer{Value: it.i - 1}, &Integer{Value: value}, true
+}
+
+// Range is the sequence of integers from Start up to, but not including,
+// Stop, by Step, which is never 0; a negative Step counts down.
+type Range struct {
+	Start, Stop, Step int64
+}
+
+func (o *Range) Type() ObjectType { return RANGE_OBJ }
+func (o *Range) Inspect() string {
+	return fmt.Sprintf("range(%d, %d, %d)", o.Start, o.Stop, o.Step)
+}
+
+func (o *Range) contains(n int64) bool {
+	if o.Step > 0 {
+		return n < o.Stop
+	}
+	return n > o.Stop
+}
@@ -0,0 +1,135 @@
+package object
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+// HashKey of a tuple combines the HashKeys of its elements. It is only
+// meaningful when every element is usable as a key; see KeyOf.
+func (o *Tuple) HashKey() HashKey {
+	return HashKey{Type: o.Type(), Value: combineKeys(o.Elements)}
+}
+
+// HashKey of an array combines the HashKeys of its elements. Only a
+// frozen array is usable as a key; see KeyOf.
+func (o *Array) HashKey() HashKey {
+	return HashKey{Type: o.Type(), Value: combineKeys(o.Elements)}
+}
+
+// HashKey of a struct combines its name with the HashKey of its value.
+// Only a frozen struct is usable as a key; see KeyOf.
+func (o *Struct) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(o.TypeName + "." + o.Name))
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], combineKeys([]Object{o.Value}))
+	h.Write(buf[:])
+	return HashKey{Type: o.Type(), Value: h.Sum64()}
+}
+
+func combineKeys(elements []Object) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, e := range elements {
+		key := HashKey{Type: e.Type()}
+		if e, ok := e.(Hashable); ok {
+			key = e.HashKey()
+		}
+		h.Write([]byte(key.Type))
+		binary.LittleEndian.PutUint64(buf[:], key.Value)
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// KeyOf returns obj as a hash key. Integers, strings and booleans are
+// keys, and so are tuples, frozen arrays and frozen structs of keys; a
+// value that may still change, such as a hash or an array that is not
+// frozen, is not.
+func KeyOf(obj Object) (Hashable, error) {
+	if err := checkKey(obj); err != nil {
+		return nil, err
+	}
+	return obj.(Hashable), nil
+}
+
+func checkKey(obj Object) error {
+	switch obj := obj.(type) {
+	case *Integer, *String, *Boolean:
+		return nil
+	case *Tuple:
+		return checkKeys(obj.Elements)
+	case *Array:
+		if !obj.Frozen {
+			return fmt.Errorf("mutable ARRAY cannot be a hash key; freeze it or use a tuple")
+		}
+		return checkKeys(obj.Elements)
+	case *Struct:
+		if !obj.Frozen {
+			return fmt.Errorf("mutable STRUCT cannot be a hash key; freeze it first")
+		}
+		if obj.Value == nil {
+			return nil
+		}
+		return checkKey(obj.Value)
+	case *Hash:
+		return fmt.Errorf("mutable HASH cannot be a hash key")
+	}
+	return fmt.Errorf("unusable as hash key: %s", obj.Type())
+}
+
+func checkKeys(elements []Object) error {
+	for _, e := range elements {
+		if err := checkKey(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Freeze returns a frozen copy of obj: arrays and structs in it, however
+// deeply nested, are copied and frozen. Hashes cannot be frozen.
+func Freeze(obj Object) (Object, error) {
+	switch obj := obj.(type) {
+	case *Array:
+		elements, err := freezeAll(obj.Elements)
+		if err != nil {
+			return nil, err
+		}
+		return &Array{Elements: elements, Frozen: true}, nil
+	case *Tuple:
+		elements, err := freezeAll(obj.Elements)
+		if err != nil {
+			return nil, err
+		}
+		return &Tuple{Elements: elements}, nil
+	case *Struct:
+		frozen := *obj
+		frozen.Frozen = true
+		if obj.Value != nil {
+			value, err := Freeze(obj.Value)
+			if err != nil {
+				return nil, err
+			}
+			frozen.Value = value
+		}
+		return &frozen, nil
+	case *Hash:
+		return nil, fmt.Errorf("cannot freeze HASH")
+	}
+	return obj, nil
+}
+
+func freezeAll(elements []Object) ([]Object, error) {
+	frozen := make([]Object, len(elements))
+	for i, e := range elements {
+		f, err := Freeze(e)
+		if err != nil {
+			return nil, err
+		}
+		frozen[i] = f
+	}
+	return frozen, nil
+}
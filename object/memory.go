@@ -0,0 +1,79 @@
+package object
+
+// Approximate, platform independent sizes used for memory accounting.
+const (
+	headerSize  = 16
+	elementSize = 16
+	pairSize    = 48
+)
+
+// SizeOf approximates the bytes allocated to build obj, not counting the
+// values it refers to; those are accounted when they are built.
+func SizeOf(obj Object) uint64 {
+	switch obj := obj.(type) {
+	case *String:
+		return headerSize + uint64(len(obj.Value))
+	case *Array:
+		return headerSize + elementSize*uint64(len(obj.Elements))
+	case *Tuple:
+		return headerSize + elementSize*uint64(len(obj.Elements))
+	case *Hash:
+		return headerSize + pairSize*uint64(obj.Len())
+	case *Closure:
+		return headerSize + elementSize*uint64(len(obj.Free))
+	case *Function:
+		return headerSize + elementSize*uint64(len(obj.Parameters))
+	case *Boolean, *Null:
+		return 0
+	default:
+		return headerSize
+	}
+}
+
+// Allocator is implemented by engines that enforce a memory limit.
+// Builtins report the values they build through Allocate so the limit
+// covers them too.
+type Allocator interface {
+	Allocate(obj Object) error
+}
+
+// MemoryMeter counts the approximate bytes a script allocates over its
+// whole run. It is deterministic: freed values are not given back. A nil
+// MemoryMeter accepts every allocation.
+type MemoryMeter struct {
+	limit    uint64
+	used     uint64
+	exceeded bool
+}
+
+func NewMemoryMeter(limit uint64) *MemoryMeter {
+	return &MemoryMeter{limit: limit}
+}
+
+// Allocate charges SizeOf(obj), failing with ErrMemoryLimit once the limit
+// would be exceeded.
+func (m *MemoryMeter) Allocate(obj Object) error {
+	if m == nil {
+		return nil
+	}
+	size := SizeOf(obj)
+	if size > m.limit-m.used {
+		m.exceeded = true
+		return ErrMemoryLimit
+	}
+	m.used += size
+	return nil
+}
+
+// Used reports the bytes accounted so far.
+func (m *MemoryMeter) Used() uint64 {
+	if m == nil {
+		return 0
+	}
+	return m.used
+}
+
+// Exceeded reports whether an allocation has been refused.
+func (m *MemoryMeter) Exceeded() bool {
+	return m != nil && m.exceeded
+}
@@ -2,8 +2,11 @@ package object
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"strings"
 
 	"github.com/GhostNet-Dev/gscript/ast"
@@ -32,38 +35,28 @@ const (
 	HASH_OBJ              = "HASH"
 	CLOSURE_OBJ           = "CLOSURE"
 	STRUCT_OBJ            = "STRUCT"
+	ERROR_VALUE_OBJ       = "ERROR_VALUE"
+	TUPLE_OBJ             = "TUPLE"
+	RANGE_OBJ             = "RANGE"
+	ITER_OBJ              = "ITERATOR"
 )
 
-type Hashable interface{ HashKey() HashKey }
+// Hashable is a value usable as a hash key. Keys with equal HashKeys
+// may still differ; Hash tells them apart with Equal.
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
 
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
 }
 
-type HashPair struct {
-	Key   Object
-	Value Object
-}
-type Hash struct {
-	Pairs map[HashKey]HashPair
-}
-
-func (o *Hash) Type() ObjectType { return HASH_OBJ }
-func (o *Hash) Inspect() string {
-	var out bytes.Buffer
-	pairs := []string{}
-	for _, pair := range o.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
-	}
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
-	return out.String()
-}
-
 type Array struct {
 	Elements []Object
+	// Frozen marks an array made by freeze, which may be a hash key.
+	Frozen bool
 }
 
 func (o *Array) Type() ObjectType { return ARRAY_OBJ }
@@ -79,6 +72,26 @@ func (o *Array) Inspect() string {
 	return out.String()
 }
 
+// Tuple is an immutable sequence of values, such as (1, 2).
+type Tuple struct {
+	Elements []Object
+}
+
+func (o *Tuple) Type() ObjectType { return TUPLE_OBJ }
+func (o *Tuple) Inspect() string {
+	elements := []string{}
+	for _, e := range o.Elements {
+		elements = append(elements, e.Inspect())
+	}
+	if len(elements) == 1 {
+		return "(" + elements[0] + ",)"
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
+// BuiltinFunction receives the running engine as its context. Both the
+// evaluator and the VM pass a value implementing Caller, so a builtin can
+// call back into the script functions it is given.
 type BuiltinFunction func(context interface{}, args ...Object) Object
 type Builtin struct {
 	Fn BuiltinFunction
@@ -87,6 +100,18 @@ type Builtin struct {
 func (o *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (o *Builtin) Inspect() string  { return "builtin function" }
 
+// Caller synchronously invokes a script function (an evaluator Function, a
+// VM Closure or a Builtin) with args and returns its result.
+type Caller interface {
+	Call(fn Object, args ...Object) (Object, error)
+}
+
+// Printer is implemented by engines that send what puts prints somewhere
+// other than standard output.
+type Printer interface {
+	Stdout() io.Writer
+}
+
 type String struct {
 	Value string
 }
@@ -135,6 +160,15 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	Name          string
+	// Handlers are the try regions of Instructions, innermost first.
+	Handlers []code.Handler
+	// Lines, Locals and Free are the debug information of Instructions:
+	// where statements start, the names of local slots and the names of
+	// the free variables.
+	Lines  []code.Line
+	Locals []code.Local
+	Free   []string
 }
 
 func (o *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -142,12 +176,42 @@ func (o *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", o)
 }
 
+// Error is an error result of the evaluator and, in both engines, an
+// exception on its way to a catch clause. Kind and Trace are filled in as
+// it is raised.
 type Error struct {
 	Message string
+	Kind    string
+	Trace   []string
+	Value   Object // the thrown value, for ThrowError
 }
 
 func (o *Error) Inspect() string  { return "ERROR: " + o.Message }
 func (o *Error) Type() ObjectType { return ERROR_OBJ }
+func (o *Error) Error() string    { return o.Message }
+
+// Errors returned by both execution engines when a run is aborted by the
+// host rather than by the script itself.
+var (
+	ErrCanceled         = errors.New("execution canceled")
+	ErrDeadlineExceeded = errors.New("execution deadline exceeded")
+	ErrOutOfGas         = errors.New("out of gas")
+	ErrMemoryLimit      = errors.New("memory limit exceeded")
+)
+
+// ContextError reports whether ctx is done, translated to ErrCanceled or
+// ErrDeadlineExceeded. It does not block.
+func ContextError(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrDeadlineExceeded
+		}
+		return ErrCanceled
+	default:
+		return nil
+	}
+}
 
 type ReturnValue struct {
 	Value Object
@@ -161,7 +225,7 @@ type Identifier struct {
 	Value Object
 }
 
-func (o *Identifier) Inspect() string  { return fmt.Sprintf("%d", o.Value) }
+func (o *Identifier) Inspect() string  { return o.Value.Inspect() }
 func (o *Identifier) Type() ObjectType { return IDENTFIER_OBJ }
 
 type Struct struct {
@@ -170,6 +234,8 @@ type Struct struct {
 	Value    Object
 	Body     *ast.BlockStatement
 	Env      *Environment
+	// Frozen marks a struct made by freeze, which may be a hash key.
+	Frozen bool
 }
 
 func (o *Struct) Inspect() string  { return fmt.Sprintf("%d", o.Value) }
@@ -1,6 +1,8 @@
 package object
 
 import (
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -20,3 +22,201 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have different hash keys")
 	}
 }
+
+func TestMemoryMeter(t *testing.T) {
+	tests := []struct {
+		obj      Object
+		expected uint64
+	}{
+		{&String{Value: "abc"}, 19},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}, 48},
+		{hash(&Integer{Value: 1}), 64},
+		{&Closure{Free: []Object{&Null{}}}, 32},
+		{&Boolean{Value: true}, 0},
+	}
+	for _, tt := range tests {
+		if size := SizeOf(tt.obj); size != tt.expected {
+			t.Errorf("wrong size of %s. want=%d, got=%d", tt.obj.Type(), tt.expected, size)
+		}
+	}
+
+	meter := NewMemoryMeter(40)
+	if err := meter.Allocate(&String{Value: "abc"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := meter.Allocate(&String{Value: "abcdefg"}); err != ErrMemoryLimit {
+		t.Fatalf("wrong error: want=%q, got=%v", ErrMemoryLimit, err)
+	}
+	if meter.Used() != 19 || !meter.Exceeded() {
+		t.Errorf("wrong meter state. used=%d, exceeded=%t", meter.Used(), meter.Exceeded())
+	}
+
+	var unlimited *MemoryMeter
+	if err := unlimited.Allocate(&String{Value: "abc"}); err != nil {
+		t.Errorf("nil meter refused allocation: %s", err)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	array := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	fn := &Builtin{}
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 1}, true},
+		{&Integer{Value: 1}, &Integer{Value: 2}, false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&Boolean{Value: true}, &Boolean{Value: true}, true},
+		{&Null{}, &Null{}, true},
+		{&Null{}, &Boolean{Value: false}, false},
+		{&Integer{Value: 1}, &String{Value: "1"}, false},
+		{array, &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}, true},
+		{array, &Array{Elements: []Object{&Integer{Value: 1}}}, false},
+		{hash(array), hash(&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}), true},
+		{hash(&Integer{Value: 1}), hash(&Integer{Value: 2}), false},
+		{&Struct{Name: "p", Value: &Integer{Value: 1}}, &Struct{Name: "p", Value: &Integer{Value: 1}}, true},
+		{&Struct{Name: "p"}, &Struct{Name: "q"}, false},
+		{&Tuple{Elements: []Object{array}}, &Tuple{Elements: []Object{array}}, true},
+		{&Tuple{Elements: []Object{array}}, &Array{Elements: []Object{array}}, false},
+		{fn, fn, true},
+		{fn, &Builtin{}, false},
+	}
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("tests[%d]: Equal(%s, %s) = %t, want %t", i, tt.a.Inspect(), tt.b.Inspect(), got, tt.expected)
+		}
+	}
+}
+
+func TestKeyOf(t *testing.T) {
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	point := &Struct{Name: "p", Value: &Tuple{Elements: []Object{one, two}}}
+	frozen, err := Freeze(point)
+	if err != nil {
+		t.Fatalf("Freeze failed: %s", err)
+	}
+	if point.Frozen {
+		t.Errorf("Freeze changed its argument")
+	}
+	tests := []struct {
+		key Object
+		err string
+	}{
+		{&Tuple{Elements: []Object{one, &String{Value: "a"}}}, ""},
+		{&Tuple{}, ""},
+		{&Array{Elements: []Object{one}, Frozen: true}, ""},
+		{frozen, ""},
+		{&Array{Elements: []Object{one}}, "mutable ARRAY cannot be a hash key; freeze it or use a tuple"},
+		{&Tuple{Elements: []Object{&Array{}}}, "mutable ARRAY cannot be a hash key; freeze it or use a tuple"},
+		{point, "mutable STRUCT cannot be a hash key; freeze it first"},
+		{NewHash(0), "mutable HASH cannot be a hash key"},
+		{&Builtin{}, "unusable as hash key: BUILTIN"},
+	}
+	for i, tt := range tests {
+		_, err := KeyOf(tt.key)
+		if tt.err == "" && err != nil {
+			t.Errorf("tests[%d]: KeyOf(%s) failed: %s", i, tt.key.Inspect(), err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("tests[%d]: KeyOf(%s) error = %v, want %q", i, tt.key.Inspect(), err, tt.err)
+		}
+	}
+
+	again, _ := Freeze(point)
+	h := NewHash(0)
+	h.Set(frozen.(Hashable), one)
+	if got, ok := h.Get(again.(Hashable)); !ok || got != one {
+		t.Errorf("equal frozen structs are different keys")
+	}
+	other := &Tuple{Elements: []Object{two, one}}
+	if _, ok := h.Get(other); ok {
+		t.Errorf("a tuple found the struct's entry")
+	}
+}
+
+func TestIterate(t *testing.T) {
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	h := NewHash(2)
+	h.Set(&String{Value: "b"}, one)
+	h.Set(&String{Value: "a"}, two)
+	tests := []struct {
+		collection Object
+		vars       int
+		expected   string
+	}{
+		{&Array{Elements: []Object{one, two}}, 2, "0=1 1=2 "},
+		{&Tuple{Elements: []Object{two}}, 1, "2 "},
+		{&String{Value: "añ"}, 2, "0=a 1=ñ "},
+		{h, 1, "b a "},
+		{h, 2, "b=1 a=2 "},
+		{&Range{Start: 1, Stop: 7, Step: 3}, 1, "1 4 "},
+		{&Range{Start: 1, Stop: -2, Step: -1}, 1, "1 0 -1 "},
+		{&Range{Start: math.MaxInt64 - 1, Stop: math.MaxInt64, Step: 2}, 1, "9223372036854775806 "},
+		{&Range{Start: 0, Stop: 0, Step: 1}, 1, ""},
+		{&Struct{Name: "p", Value: &Array{Elements: []Object{one}}}, 1, "1 "},
+	}
+	for i, tt := range tests {
+		iter, err := Iterate(tt.collection)
+		if err != nil {
+			t.Fatalf("tests[%d]: Iterate failed: %s", i, err)
+		}
+		var out strings.Builder
+		for {
+			key, value, ok := iter.NextFor(tt.vars)
+			if !ok {
+				break
+			}
+			if key != nil {
+				out.WriteString(key.Inspect() + "=")
+			}
+			out.WriteString(value.Inspect() + " ")
+		}
+		if out.String() != tt.expected {
+			t.Errorf("tests[%d]: got %q, want %q", i, out.String(), tt.expected)
+		}
+	}
+	if _, err := Iterate(one); err == nil || err.Error() != "INTEGER is not iterable" {
+		t.Errorf("Iterate(1) error = %v", err)
+	}
+}
+
+func hash(value Object) *Hash {
+	h := &Hash{}
+	h.Set(&String{Value: "k"}, value)
+	return h
+}
+
+// collidingKey is a key whose HashKey collides with every other.
+type collidingKey struct{ String }
+
+func (k *collidingKey) HashKey() HashKey { return HashKey{Type: STRING_OBJ} }
+
+func TestHash(t *testing.T) {
+	h := &Hash{}
+	a, b, c := &collidingKey{String{"a"}}, &collidingKey{String{"b"}}, &collidingKey{String{"c"}}
+	h.Set(a, &Integer{Value: 1})
+	h.Set(b, &Integer{Value: 2})
+	h.Set(c, &Integer{Value: 3})
+	h.Set(a, &Integer{Value: 4})
+	if got := h.Inspect(); got != "{a: 4, b: 2, c: 3}" {
+		t.Errorf("wrong hash. want=%q, got=%q", "{a: 4, b: 2, c: 3}", got)
+	}
+	if value, ok := h.Get(b); !ok || value.Inspect() != "2" {
+		t.Errorf("wrong value for b: %v, %t", value, ok)
+	}
+	if _, ok := h.Get(&collidingKey{String{"d"}}); ok {
+		t.Errorf("found a key never set")
+	}
+
+	if !h.Delete(b) || h.Delete(b) {
+		t.Errorf("b deleted not exactly once")
+	}
+	h.Set(b, &Integer{Value: 5})
+	if got := h.Inspect(); got != "{a: 4, c: 3, b: 5}" {
+		t.Errorf("wrong hash. want=%q, got=%q", "{a: 4, c: 3, b: 5}", got)
+	}
+	if value, ok := h.Get(c); !ok || value.Inspect() != "3" || h.Len() != 3 {
+		t.Errorf("wrong hash after delete: %s", h.Inspect())
+	}
+}
@@ -0,0 +1,27 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/lexer"
+)
+
+func FuzzParser(f *testing.F) {
+	for _, seed := range []string{
+		"let x = 5; x + 10;",
+		"let f = fn(a, b) { if (a < b) { return a; } else { b } }; f(1, 2)",
+		`let h = {"a": [1, 2]}; h["a"][0]`,
+		"for (let i = 0; i < 3; i = i + 1) { i; }",
+		"try { throw 1; } catch (e) { e } finally { 2 }",
+		"type point struct { let x = 0; }",
+		"let x: int = f()?;",
+		"let = ; fn( { [ }",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		p := NewParser(lexer.NewLexer(input))
+		program := p.ParseProgram()
+		_ = program.String()
+	})
+}
@@ -8,9 +8,24 @@ import (
 	"github.com/GhostNet-Dev/gscript/lexer"
 )
 
+// Error is a syntax error found at Token.
+type Error struct {
+	Token   gtoken.Token
+	Message string
+}
+
+func (e Error) String() string {
+	return fmt.Sprintf("%d:%d: %s", e.Token.Line, e.Token.Column, e.Message)
+}
+
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors []Error
+
+	// reported counts the errors found, including those left out as
+	// duplicates, and synced their number when the parser last skipped
+	// to the end of a statement.
+	reported, synced int
 
 	curToken  gtoken.Token
 	peekToken gtoken.Token
@@ -22,7 +37,7 @@ type Parser struct {
 func NewParser(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []Error{},
 	}
 
 	p.initExpression()
@@ -43,16 +58,52 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != gtoken.EOF {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			program.Statements = append(program.Statements, stmt)
-		}
+		program.Statements = append(program.Statements, p.parseListedStatement())
 		p.NextToken()
 	}
 
 	return program
 }
 
+// parseListedStatement parses a statement of a program or block, and skips
+// the rest of it if it has errors the parser has not recovered from.
+func (p *Parser) parseListedStatement() ast.Statement {
+	reported := p.reported
+	stmt := p.parseStatement()
+	if p.reported > reported && p.reported > p.synced {
+		p.synchronize()
+	}
+	return stmt
+}
+
+// synchronize skips to the end of a statement with errors: the semicolon
+// ending it, or the token before the keyword starting the next statement
+// or the brace closing the block around it.
+func (p *Parser) synchronize() {
+	p.synced = p.reported
+	depth := 0
+	for !p.curTokenIs(gtoken.EOF) {
+		switch p.curToken.Type {
+		case gtoken.LBRACE:
+			depth++
+		case gtoken.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		}
+		if depth == 0 {
+			if p.curTokenIs(gtoken.SEMICOLON) {
+				return
+			}
+			switch p.peekToken.Type {
+			case gtoken.LET, gtoken.RETURN, gtoken.THROW, gtoken.TRY, gtoken.TYPE, gtoken.RBRACE, gtoken.EOF:
+				return
+			}
+		}
+		p.NextToken()
+	}
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case gtoken.TYPE:
@@ -61,6 +112,12 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case gtoken.RETURN:
 		return p.parseReturnStatement()
+	case gtoken.THROW:
+		return p.parseThrowStatement()
+	case gtoken.TRY:
+		return p.parseTryStatement()
+	case gtoken.LBRACE:
+		return p.parseBlockOrHashStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -70,14 +127,62 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
 	p.NextToken()
+	return p.parseBlockStatements(block)
+}
 
-	for !p.curTokenIs(gtoken.RBRACE) && !p.curTokenIs(gtoken.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
+// parseBlockOrHashStatement parses a statement starting with {: a block,
+// unless its first expression is followed by a colon. {} is an empty hash.
+func (p *Parser) parseBlockOrHashStatement() ast.Statement {
+	if p.peekTokenIs(gtoken.RBRACE) {
+		return p.parseExpressionStatement()
+	}
+	block := &ast.BlockStatement{Token: p.curToken}
+	block.Statements = []ast.Statement{}
+	p.NextToken()
+	if p.prefixParseFns[p.curToken.Type] == nil || p.curTokenIs(gtoken.LBRACE) {
+		return p.parseBareBlock(block)
+	}
+
+	first := &ast.ExpressionStatement{Token: p.curToken}
+	first.Expression = p.parseExpression(LOWEST)
+	if p.peekTokenIs(gtoken.COLON) {
+		hash := p.parseHashLiteralFrom(block.Token, first.Expression)
+		stmt := &ast.ExpressionStatement{Token: block.Token}
+		stmt.Expression = p.parseInfixExpressions(hash, LOWEST)
+		if p.peekTokenIs(gtoken.SEMICOLON) {
+			p.NextToken()
 		}
+		return stmt
+	}
+	if p.peekTokenIs(gtoken.SEMICOLON) {
+		p.NextToken()
+	}
+	block.Statements = append(block.Statements, first)
+	p.NextToken()
+	return p.parseBareBlock(block)
+}
+
+// parseBareBlock parses the rest of a block used as a statement, which
+// may be followed by a semicolon.
+func (p *Parser) parseBareBlock(block *ast.BlockStatement) *ast.BlockStatement {
+	p.parseBlockStatements(block)
+	if p.peekTokenIs(gtoken.SEMICOLON) {
+		p.NextToken()
+	}
+	return block
+}
+
+// parseBlockStatements parses the statements of block from the current
+// token up to the closing brace.
+func (p *Parser) parseBlockStatements(block *ast.BlockStatement) *ast.BlockStatement {
+	for !p.curTokenIs(gtoken.RBRACE) && !p.curTokenIs(gtoken.EOF) {
+		block.Statements = append(block.Statements, p.parseListedStatement())
 		p.NextToken()
 	}
+	block.Rbrace = p.curToken
+	if p.curTokenIs(gtoken.EOF) {
+		p.errorAt(p.curToken, "expected } to close the block")
+	}
 	return block
 }
 
@@ -87,12 +192,13 @@ func (p *Parser) parseObjectBlockStatement() *ast.ObjectBlockStatement {
 	p.NextToken()
 
 	for !p.curTokenIs(gtoken.RBRACE) && !p.curTokenIs(gtoken.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
-		}
+		block.Statements = append(block.Statements, p.parseListedStatement())
 		p.NextToken()
 	}
+	block.Rbrace = p.curToken
+	if p.curTokenIs(gtoken.EOF) {
+		p.errorAt(p.curToken, "expected } to close the block")
+	}
 	return block
 }
 
@@ -102,52 +208,107 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(gtoken.SEMICOLON) && !p.curTokenIs(gtoken.EOF) {
+	if p.peekTokenIs(gtoken.SEMICOLON) {
+		p.NextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+	p.NextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(gtoken.SEMICOLON) {
 		p.NextToken()
 	}
 	return stmt
 }
 
-func (p *Parser) parseTypeStatement() *ast.TypeStatement {
+func (p *Parser) parseTryStatement() ast.Statement {
+	stmt := &ast.TryStatement{Token: p.curToken}
+	if !p.expectPeek(gtoken.LBRACE) {
+		return p.badStmt(stmt.Token)
+	}
+	stmt.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(gtoken.CATCH) {
+		p.NextToken()
+		if p.peekTokenIs(gtoken.LPAREN) {
+			p.NextToken()
+			if !p.expectPeek(gtoken.IDENT) {
+				return p.badStmt(stmt.Token)
+			}
+			stmt.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.expectPeek(gtoken.RPAREN) {
+				return p.badStmt(stmt.Token)
+			}
+		}
+		if !p.expectPeek(gtoken.LBRACE) {
+			return p.badStmt(stmt.Token)
+		}
+		stmt.Catch = p.parseBlockStatement()
+	}
+	if p.peekTokenIs(gtoken.FINALLY) {
+		p.NextToken()
+		if !p.expectPeek(gtoken.LBRACE) {
+			return p.badStmt(stmt.Token)
+		}
+		stmt.Finally = p.parseBlockStatement()
+	}
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.errorAt(p.curToken, "expected catch or finally after try block")
+		return p.badStmt(stmt.Token)
+	}
+	if p.peekTokenIs(gtoken.SEMICOLON) {
+		p.NextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseTypeStatement() ast.Statement {
 	stmt := &ast.TypeStatement{Token: p.curToken}
 	if !p.expectPeek(gtoken.IDENT) {
-		return nil
+		return p.badStmt(stmt.Token)
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	if !p.expectPeek(gtoken.STRUCT) {
-		return nil
+		return p.badStmt(stmt.Token)
 	}
 	stmt.Type = &ast.IdentifierType{Token: p.curToken, Value: p.curToken.Literal}
 
-	if stmt.Type.Value == "struct" && p.expectPeek(gtoken.LBRACE) {
-		stmt.Body = p.parseObjectBlockStatement()
+	if !p.expectPeek(gtoken.LBRACE) {
+		return p.badStmt(stmt.Token)
 	}
+	stmt.Body = p.parseObjectBlockStatement()
 	return stmt
 }
 
-func (p *Parser) parseStructStatement() *ast.StructStatement {
+func (p *Parser) parseStructStatement() ast.Statement {
 	stmt := &ast.StructStatement{Token: p.curToken}
 	if !p.expectPeek(gtoken.IDENT) {
-		return nil
+		return p.badStmt(stmt.Token)
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	if !p.expectPeek(gtoken.LBRACE) {
-		return nil
+		return p.badStmt(stmt.Token)
 	}
 	stmt.Body = p.parseBlockStatement()
 	return stmt
 }
-func (p *Parser) parseLetStatement() *ast.LetStatement {
+func (p *Parser) parseLetStatement() ast.Statement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 	if !p.expectPeek(gtoken.IDENT) {
-		return nil
+		return p.badStmt(stmt.Token)
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	stmt.Name.Type = p.parseTypeAnnotation()
 	if !p.expectPeek(gtoken.ASSIGN) {
-		return nil
+		return p.badStmt(stmt.Token)
 	}
 	p.NextToken()
 	stmt.Value = p.parseExpression(LOWEST)
@@ -156,12 +317,39 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 		fl.Name = stmt.Name.Value
 	}
 
-	for !p.curTokenIs(gtoken.SEMICOLON) && !p.curTokenIs(gtoken.EOF) {
+	if p.peekTokenIs(gtoken.SEMICOLON) {
 		p.NextToken()
 	}
 	return stmt
 }
 
+// parseTypeAnnotation parses the : type following a let name, a parameter
+// or a parameter list, if there is one.
+func (p *Parser) parseTypeAnnotation() *ast.IdentifierType {
+	if !p.peekTokenIs(gtoken.COLON) {
+		return nil
+	}
+	p.NextToken()
+	switch p.peekToken.Type {
+	case gtoken.IDENT, gtoken.FUNCTION, gtoken.NULL:
+		p.NextToken()
+		return &ast.IdentifierType{Token: p.curToken, Value: p.curToken.Literal}
+	}
+	p.errorAt(p.peekToken, "expected a type, got %s instead", p.peekToken.Type)
+	return nil
+}
+
+// badStmt returns a placeholder for the statement from token from, which
+// has errors, up to the current token.
+func (p *Parser) badStmt(from gtoken.Token) ast.Statement {
+	return &ast.BadStmt{From: from, To: p.curToken}
+}
+
+// badExpr is badStmt for expressions.
+func (p *Parser) badExpr(from gtoken.Token) ast.Expression {
+	return &ast.BadExpr{From: from, To: p.curToken}
+}
+
 func (p *Parser) curTokenIs(t gtoken.TokenType) bool {
 	return p.curToken.Type == t
 }
@@ -180,12 +368,34 @@ func (p *Parser) expectPeek(t gtoken.TokenType) bool {
 	}
 }
 
+// Errors returns the syntax errors found, as line:column: message.
 func (p *Parser) Errors() []string {
+	msgs := make([]string, len(p.errors))
+	for i, e := range p.errors {
+		msgs[i] = e.String()
+	}
+	return msgs
+}
+
+// ErrorList returns the syntax errors found.
+func (p *Parser) ErrorList() []Error {
 	return p.errors
 }
 
+// errorAt records an error at tok, unless the parser has not yet
+// recovered from an earlier one or the last one is at the same place:
+// errors following from another are left out.
+func (p *Parser) errorAt(tok gtoken.Token, format string, a ...interface{}) {
+	p.reported++
+	if p.reported-1 > p.synced {
+		return
+	}
+	if n := len(p.errors); n > 0 && p.errors[n-1].Token.Line == tok.Line && p.errors[n-1].Token.Column == tok.Column {
+		return
+	}
+	p.errors = append(p.errors, Error{Token: tok, Message: fmt.Sprintf(format, a...)})
+}
+
 func (p *Parser) peekError(t gtoken.TokenType) {
-	msg := fmt.Sprintf("%d: expected next token to be %s, got %s instead",
-		p.peekToken.Line, t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.errorAt(p.peekToken, "expected next token to be %s, got %s instead", t, p.peekToken.Type)
 }
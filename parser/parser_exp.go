@@ -1,7 +1,6 @@
 package parser
 
 import (
-	"fmt"
 	"strconv"
 
 	"github.com/GhostNet-Dev/gscript/ast"
@@ -18,7 +17,7 @@ const (
 	PRODUCT     // *
 	PREFIX      // -X OR !X
 	CALL        // myFunction(X)
-	INDEX       // array[index]
+	INDEX       // array[index] OR x?
 	DOT         // .
 )
 
@@ -33,6 +32,7 @@ var precedences = map[gtoken.TokenType]int{
 	gtoken.ASTERISK: PRODUCT,
 	gtoken.LPAREN:   CALL,
 	gtoken.LBRACKET: INDEX,
+	gtoken.QUESTION: INDEX,
 	gtoken.ASSIGN:   ASSIGN,
 	gtoken.DOT:      DOT,
 }
@@ -71,6 +71,7 @@ func (p *Parser) initExpression() {
 	p.registerInfix(gtoken.LPAREN, p.parseCallExpression)
 	p.registerInfix(gtoken.LBRACKET, p.parseIndexExpression)
 	p.registerInfix(gtoken.ASSIGN, p.parseInfixExpression)
+	p.registerInfix(gtoken.QUESTION, p.parsePropagateExpression)
 }
 
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
@@ -87,10 +88,14 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
-		return nil
+		return p.badExpr(p.curToken)
 	}
-	leftExp := prefix()
+	return p.parseInfixExpressions(prefix(), precedence)
+}
 
+// parseInfixExpressions continues an expression after its first operand
+// leftExp, with the operators binding tighter than precedence.
+func (p *Parser) parseInfixExpressions(leftExp ast.Expression, precedence int) ast.Expression {
 	for !p.peekTokenIs(gtoken.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
@@ -103,25 +108,36 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
-	hash := &ast.HashLiteral{Token: p.curToken}
+	return p.parseHashLiteralFrom(p.curToken, nil)
+}
+
+// parseHashLiteralFrom parses a hash literal opened by token. A statement
+// starting with { has parsed the first key, if not nil, before it knew it
+// was a hash.
+func (p *Parser) parseHashLiteralFrom(token gtoken.Token, key ast.Expression) ast.Expression {
+	hash := &ast.HashLiteral{Token: token}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
 
-	for !p.peekTokenIs(gtoken.RBRACE) {
-		p.NextToken()
-		key := p.parseExpression(LOWEST)
+	for key != nil || !p.peekTokenIs(gtoken.RBRACE) {
+		if key == nil {
+			p.NextToken()
+			key = p.parseExpression(LOWEST)
+		}
 		if !p.expectPeek((gtoken.COLON)) {
-			return nil
+			return p.badExpr(token)
 		}
 		p.NextToken()
 		value := p.parseExpression(LOWEST)
 		hash.Pairs[key] = value
+		key = nil
 		if !p.peekTokenIs(gtoken.RBRACE) && !p.expectPeek(gtoken.COMMA) {
-			return nil
+			return p.badExpr(token)
 		}
 	}
 	if !p.expectPeek(gtoken.RBRACE) {
-		return nil
+		return p.badExpr(token)
 	}
+	hash.Rbrace = p.curToken
 	return hash
 }
 
@@ -131,17 +147,28 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp.Index = p.parseExpression(LOWEST)
 
 	if !p.expectPeek(gtoken.RBRACKET) {
-		return nil
+		return p.badExpr(exp.Token)
 	}
+	exp.Rbracket = p.curToken
 	return exp
 }
 
+func (p *Parser) parsePropagateExpression(left ast.Expression) ast.Expression {
+	return &ast.PropagateExpression{Token: p.curToken, Value: left}
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(gtoken.RBRACKET)
+	if array.Elements == nil {
+		return p.badExpr(array.Token)
+	}
+	array.Rbracket = p.curToken
 	return array
 }
 
+// parseExpressionList parses the expressions up to end, separated by
+// commas. It returns nil if end is missing.
 func (p *Parser) parseExpressionList(end gtoken.TokenType) []ast.Expression {
 	list := []ast.Expression{}
 	if p.peekTokenIs(end) {
@@ -169,6 +196,10 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(gtoken.RPAREN)
+	if exp.Arguments == nil {
+		return p.badExpr(exp.Token)
+	}
+	exp.Rparen = p.curToken
 	return exp
 }
 
@@ -180,12 +211,16 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 
 	if !p.expectPeek(gtoken.LPAREN) {
-		return nil
+		return p.badExpr(lit.Token)
 	}
 	lit.Parameters = p.parseFunctionParameters()
+	if lit.Parameters == nil {
+		return p.badExpr(lit.Token)
+	}
+	lit.ReturnType = p.parseTypeAnnotation()
 
 	if !p.expectPeek(gtoken.LBRACE) {
-		return nil
+		return p.badExpr(lit.Token)
 	}
 	lit.Body = p.parseBlockStatement()
 	return lit
@@ -197,13 +232,19 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		p.NextToken()
 		return identifiers
 	}
-	p.NextToken()
+	if !p.expectPeek(gtoken.IDENT) {
+		return nil
+	}
 	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	ident.Type = p.parseTypeAnnotation()
 	identifiers = append(identifiers, ident)
 	for p.peekTokenIs(gtoken.COMMA) {
 		p.NextToken()
-		p.NextToken()
+		if !p.expectPeek(gtoken.IDENT) {
+			return nil
+		}
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		ident.Type = p.parseTypeAnnotation()
 		identifiers = append(identifiers, ident)
 	}
 	if !p.expectPeek(gtoken.RPAREN) {
@@ -215,64 +256,126 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 func (p *Parser) parseForExpresion() ast.Expression {
 	expression := &ast.ForExpression{Token: p.curToken}
 	if !p.expectPeek(gtoken.LPAREN) {
-		return nil
+		return p.badExpr(expression.Token)
 	}
 	p.NextToken()
-	expression.Init = p.parseStatement()
+	if p.curTokenIs(gtoken.IDENT) && (p.peekTokenIs(gtoken.IN) || p.peekTokenIs(gtoken.COMMA)) {
+		return p.parseForInExpression(expression.Token)
+	}
+	if !p.curTokenIs(gtoken.SEMICOLON) {
+		expression.Init = p.parseStatement()
+	}
 
-	if p.curTokenIs(gtoken.SEMICOLON) {
+	if p.curTokenIs(gtoken.SEMICOLON) && !p.peekTokenIs(gtoken.SEMICOLON) {
 		p.NextToken()
 		expression.Condition = p.parseExpression(LOWEST)
 	}
-	if p.expectPeek(gtoken.SEMICOLON) {
+	if !p.expectPeek(gtoken.SEMICOLON) {
+		return p.badExpr(expression.Token)
+	}
+	if !p.peekTokenIs(gtoken.RPAREN) {
 		p.NextToken()
 		expression.Increment = p.parseExpression(LOWEST)
 	}
 
 	if !p.expectPeek(gtoken.RPAREN) {
-		return nil
+		return p.badExpr(expression.Token)
 	}
 	if !p.expectPeek(gtoken.LBRACE) {
-		return nil
+		return p.badExpr(expression.Token)
 	}
 	expression.Consequence = p.parseBlockStatement()
 
 	return expression
 }
 
+// parseForInExpression parses the rest of a for-in loop from its first
+// variable: for (k, v in xs) { }.
+func (p *Parser) parseForInExpression(forToken gtoken.Token) ast.Expression {
+	expression := &ast.ForInExpression{Token: forToken}
+	expression.Variables = append(expression.Variables,
+		&ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	if p.peekTokenIs(gtoken.COMMA) {
+		p.NextToken()
+		if !p.expectPeek(gtoken.IDENT) {
+			return p.badExpr(forToken)
+		}
+		expression.Variables = append(expression.Variables,
+			&ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	}
+	if !p.expectPeek(gtoken.IN) {
+		return p.badExpr(forToken)
+	}
+	p.NextToken()
+	expression.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(gtoken.RPAREN) {
+		return p.badExpr(forToken)
+	}
+	if !p.expectPeek(gtoken.LBRACE) {
+		return p.badExpr(forToken)
+	}
+	expression.Consequence = p.parseBlockStatement()
+	return expression
+}
+
 func (p *Parser) parseIfExpresion() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 	if !p.expectPeek(gtoken.LPAREN) {
-		return nil
+		return p.badExpr(expression.Token)
 	}
 	p.NextToken()
 	expression.Condition = p.parseExpression(LOWEST)
 	if !p.expectPeek(gtoken.RPAREN) {
-		return nil
+		return p.badExpr(expression.Token)
 	}
 	if !p.expectPeek(gtoken.LBRACE) {
-		return nil
+		return p.badExpr(expression.Token)
 	}
 	expression.Consequence = p.parseBlockStatement()
 	if p.peekTokenIs(gtoken.ELSE) {
 		p.NextToken()
 		if !p.expectPeek(gtoken.LBRACE) {
-			return nil
+			return p.badExpr(expression.Token)
 		}
 		expression.Alternative = p.parseBlockStatement()
 	}
 	return expression
 }
 
+// parseGroupedExpresion parses a parenthesized expression, or a tuple if
+// a comma follows the first expression.
 func (p *Parser) parseGroupedExpresion() ast.Expression {
+	lparen := p.curToken
 	p.NextToken()
 	exp := p.parseExpression(LOWEST)
+	if p.peekTokenIs(gtoken.COMMA) {
+		return p.parseTupleLiteral(lparen, exp)
+	}
 	if !p.expectPeek(gtoken.RPAREN) {
-		return nil
+		return p.badExpr(lparen)
 	}
 	return exp
 }
 
+// parseTupleLiteral parses the rest of a tuple after its first element.
+// A trailing comma is allowed, and needed for a tuple of one.
+func (p *Parser) parseTupleLiteral(lparen gtoken.Token, first ast.Expression) ast.Expression {
+	tuple := &ast.TupleLiteral{Token: lparen, Elements: []ast.Expression{first}}
+	for p.peekTokenIs(gtoken.COMMA) {
+		p.NextToken()
+		if p.peekTokenIs(gtoken.RPAREN) {
+			break
+		}
+		p.NextToken()
+		tuple.Elements = append(tuple.Elements, p.parseExpression(LOWEST))
+	}
+	if !p.expectPeek(gtoken.RPAREN) {
+		return p.badExpr(lparen)
+	}
+	tuple.Rparen = p.curToken
+	return tuple
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(gtoken.TRUE)}
 }
@@ -307,7 +410,7 @@ func (p *Parser) parseIdentifier() ast.Expression {
 		typeIdent := &ast.TypeIdentifier{Token: p.curToken, Value: p.curToken.Literal}
 
 		p.NextToken()
-		typeIdent.Variable = p.parseExpression(LOWEST).(*ast.Identifier)
+		typeIdent.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		return typeIdent
 	}
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
@@ -318,9 +421,8 @@ func (p *Parser) parseIntergerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		p.errorAt(p.curToken, "could not parse %q as integer", p.curToken.Literal)
+		return p.badExpr(lit.Token)
 	}
 
 	lit.Value = value
@@ -341,8 +443,7 @@ func (p *Parser) curPrecedence() int {
 }
 
 func (p *Parser) noPrefixParseFnError(t gtoken.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorAt(p.curToken, "no prefix parse function for %s found", t)
 }
 
 func (p *Parser) registerPrefix(tokenType gtoken.TokenType, fn prefixParseFn) {
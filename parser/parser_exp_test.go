@@ -132,6 +132,32 @@ func TestParsingArrayLiterals(t *testing.T) {
 	testInfixExpression(t, array.Elements[2], 3, "+", 3)
 }
 
+func TestParsingTupleLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		length   int
+	}{
+		{"(1, 2 * 2)", "(1, (2 * 2))", 2},
+		{"(1, 2,)", "(1, 2)", 2},
+		{"(a,)", "(a,)", 1},
+		{"((1, 2), 3)", "((1, 2), 3)", 2},
+	}
+	for _, tt := range tests {
+		stmt := testExpressionStatement(tt.input, t)
+		tuple, ok := stmt.Expression.(*ast.TupleLiteral)
+		if !ok {
+			t.Fatalf("exp not ast.TupleLiteral. got=%T", stmt.Expression)
+		}
+		if len(tuple.Elements) != tt.length {
+			t.Errorf("len(tuple.Elements) not %d. got=%d", tt.length, len(tuple.Elements))
+		}
+		if tuple.String() != tt.expected {
+			t.Errorf("tuple.String() not %q. got=%q", tt.expected, tuple.String())
+		}
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"hello world";`
 	stmt := testExpressionStatement(input, t)
@@ -263,6 +289,36 @@ func TestForExpression(t *testing.T) {
 	}
 }
 
+func TestForInExpression(t *testing.T) {
+	tests := []struct {
+		input     string
+		variables []string
+		expected  string
+	}{
+		{`for (x in xs) { x }`, []string{"x"}, "for (x in xs) x"},
+		{`for (k, v in {"a": 1}) { v }`, []string{"k", "v"}, "for (k, v in {a:1}) v"},
+		{`for (i in range(0, 10, 2)) { i }`, []string{"i"}, "for (i in range(0, 10, 2)) i"},
+	}
+	for _, tt := range tests {
+		stmt := testExpressionStatement(tt.input, t)
+		exp, ok := stmt.Expression.(*ast.ForInExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.ForInExpression. got=%T", stmt.Expression)
+		}
+		if len(exp.Variables) != len(tt.variables) {
+			t.Fatalf("wrong number of variables. want=%d, got=%d", len(tt.variables), len(exp.Variables))
+		}
+		for i, v := range exp.Variables {
+			if v.Value != tt.variables[i] {
+				t.Errorf("variable %d is not %q. got=%q", i, tt.variables[i], v.Value)
+			}
+		}
+		if exp.String() != tt.expected {
+			t.Errorf("exp.String() not %q. got=%q", tt.expected, exp.String())
+		}
+	}
+}
+
 func TestIfExpression(t *testing.T) {
 	input := `if (x < y) { x }`
 	stmt := testExpressionStatement(input, t)
@@ -310,6 +366,9 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"3 + 4; -5 * 5", "(3 + 4)((-5) * 5)"},
 		{"5 > 4 == 3 < 4", "((5 > 4) == (3 < 4))"},
 		{"5 < 4 != 3 > 4", "((5 < 4) != (3 > 4))"},
+		{"a + f(b)?", "(a + (f(b)?))"},
+		{"-a?", "(-(a?))"},
+		{"a?[0]", "((a?)[0])"},
 		{"3 + 4 * 5 == 3 * 1 + 4 * 5", "((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))"},
 		{"1 + (2 + 3) + 4", "((1 + (2 + 3)) + 4)"},
 		{"(5 + 5) * 2", "((5 + 5) * 2)"},
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/GhostNet-Dev/gscript/ast"
@@ -199,3 +200,180 @@ func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	}
 	return true
 }
+
+func TestTryStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		param    string
+		catch    bool
+		finally  bool
+		expected string
+	}{
+		{`try { x } catch (e) { y }`, "e", true, false, "try xcatch(e) y"},
+		{`try { x } catch { y };`, "", true, false, "try xcatch y"},
+		{`try { x } finally { z }`, "", false, true, "try xfinally z"},
+		{`try { x } catch (e) { y } finally { z }`, "e", true, true, "try xcatch(e) yfinally z"},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.TryStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.TryStatement. got=%T", program.Statements[0])
+		}
+		if (stmt.Param != nil && stmt.Param.Value != tt.param) || (stmt.Param == nil && tt.param != "") {
+			t.Errorf("wrong catch parameter: %v", stmt.Param)
+		}
+		if (stmt.Catch != nil) != tt.catch || (stmt.Finally != nil) != tt.finally {
+			t.Errorf("wrong clauses: catch=%v, finally=%v", stmt.Catch, stmt.Finally)
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("stmt.String() wrong. want=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+
+	p := NewParser(lexer.NewLexer(`try { x }`))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("try without catch or finally parsed without errors")
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	p := NewParser(lexer.NewLexer(`throw "boom"; 1`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.ThrowStatement. got=%T", program.Statements[0])
+	}
+	if stmt.String() != `throw boom;` {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestBareBlocks(t *testing.T) {
+	tests := []struct {
+		input      string
+		statements int
+		block      bool
+	}{
+		{"{ let a = 1; a };", 2, true},
+		{"{ a = 1 }", 1, true},
+		{"{ a; { b } }", 2, true},
+		{"{ return 1; }", 1, true},
+		{"{}", 0, false},
+		{`{"a": 1}`, 0, false},
+		{`{1: 2, 3: 4}[1] + 1;`, 0, false},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("%q: program.Statements does not contain 1 statement. got=%d", tt.input, len(program.Statements))
+		}
+		block, ok := program.Statements[0].(*ast.BlockStatement)
+		if ok != tt.block {
+			t.Fatalf("%q: wrong statement type. got=%T", tt.input, program.Statements[0])
+		}
+		if ok && len(block.Statements) != tt.statements {
+			t.Errorf("%q: block has %d statements, want %d", tt.input, len(block.Statements), tt.statements)
+		}
+	}
+}
+
+func TestUnclosedBlocks(t *testing.T) {
+	for _, input := range []string{"{", "{ a; b", "fn(x) { x", "if (x) { 1 } else {"} {
+		p := NewParser(lexer.NewLexer(input))
+		p.ParseProgram()
+		errors := p.ErrorList()
+		if len(errors) == 0 || errors[len(errors)-1].Message != "expected } to close the block" {
+			t.Errorf("%q: wrong errors: %v", input, p.Errors())
+		}
+	}
+}
+
+func TestStatementsWithoutSemicolons(t *testing.T) {
+	p := NewParser(lexer.NewLexer("let add = fn(a, b) { a + b }\nputs(add(1, 2))\nreturn 1\nx"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 4 {
+		t.Fatalf("program.Statements does not contain 4 statements. got=%d", len(program.Statements))
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 5;", "let x: int = 5;"},
+		{"let f = fn(a: string, b, c: fn): bool { a };", "let f = fn<f>(a: string, b, c: fn): bool a;"},
+		{"let g: fn = fn(): null { };", "let g: fn = fn<g>(): null ;"},
+		{"type p struct { let x: int = 1; }", "type p type {\nlet x: int = 1;}"},
+	}
+
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: wrong tree. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	p := NewParser(lexer.NewLexer("let x: 1 = 2;"))
+	p.ParseProgram()
+	if errors := p.Errors(); len(errors) == 0 || errors[0] != "1:8: expected a type, got INT instead" {
+		t.Errorf("wrong errors: %q", errors)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input    string
+		errors   []string
+		expected string
+	}{
+		{"let = 1; let y = 2; y", []string{"1:5: expected next token to be IDENT, got = instead"},
+			"<bad statement>let y = 2;y"},
+		{"let x 5; puts(x)", []string{"1:7: expected next token to be =, got INT instead"},
+			"<bad statement>puts(x)"},
+		{"if (x { 1 } let z = 2;", []string{"1:7: expected next token to be ), got { instead"},
+			"<bad expression>let z = 2;"},
+		{"let f = fn() { let = 1; x }; let y = ;\nputs(y)",
+			[]string{"1:20: expected next token to be IDENT, got = instead", "1:38: no prefix parse function for ; found"},
+			"let f = fn<f>()<bad statement>x;let y = <bad expression>;puts(y)"},
+		{"fn(1) { x }; let a = 1", []string{"1:4: expected next token to be IDENT, got INT instead"},
+			"<bad expression>let a = 1;"},
+		{"x = = 3; y", []string{"1:5: no prefix parse function for = found"}, "(x = <bad expression>)y"},
+		{"[1, 2; let b = 3", []string{"1:6: expected next token to be ], got ; instead"}, "<bad expression>let b = 3;"},
+		{"if (a) { let = ; } puts(1",
+			[]string{"1:14: expected next token to be IDENT, got = instead", "1:26: expected next token to be ), got EOF instead"},
+			"ifa <bad statement><bad expression>"},
+		{"type p struct { let x = 1;", []string{"1:27: expected } to close the block"}, "type p type {\nlet x = 1;}"},
+		{"a b c", nil, "ac"},
+		// Errors following from the first at the end of the input are left out.
+		{"let q = (", []string{"1:10: no prefix parse function for EOF found"}, "let q = <bad expression>;"},
+		{"let a = 1;\nfoo(1, ;\n\n", []string{"2:8: no prefix parse function for ; found"}, "let a = 1;<bad expression>"},
+	}
+
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		if strings.Join(p.Errors(), "\n") != strings.Join(tt.errors, "\n") {
+			t.Errorf("%q: wrong errors.\nwant=%q\ngot=%q", tt.input, tt.errors, p.Errors())
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: wrong tree. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
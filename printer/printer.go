@@ -0,0 +1,490 @@
+// Package printer formats gscript programs in the canonical style: two
+// spaces of indentation, one statement per line, single spaces around
+// binary operators and only the parentheses precedence needs. Comments
+// and single blank lines between statements are kept.
+package printer
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GhostNet-Dev/gscript/ast"
+	"github.com/GhostNet-Dev/gscript/gtoken"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/parser"
+)
+
+const indent = "  "
+
+// Operator precedences, as the parser binds them.
+const (
+	lowest = iota
+	assign
+	equals
+	lessGreater
+	sum
+	product
+	prefix
+	postfix
+	primary
+)
+
+var precedences = map[string]int{
+	"=":  assign,
+	"==": equals,
+	"!=": equals,
+	"<":  lessGreater,
+	">":  lessGreater,
+	"+":  sum,
+	"-":  sum,
+	"*":  product,
+	"/":  product,
+}
+
+// Error reports the syntax errors that keep a source from being formatted.
+type Error struct {
+	Errors []parser.Error
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.String()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Format returns src formatted, or an *Error if it does not parse.
+func Format(src string) (string, error) {
+	l := lexer.NewLexer(src)
+	p := parser.NewParser(l)
+	program := p.ParseProgram()
+	if len(p.ErrorList()) != 0 {
+		return "", &Error{Errors: p.ErrorList()}
+	}
+
+	pr := &printer{comments: l.Comments(), blank: make(map[int]bool)}
+	for i, line := range strings.Split(src, "\n") {
+		if strings.TrimSpace(line) == "" {
+			pr.blank[i+1] = true
+		}
+	}
+	pr.program(program)
+	return pr.out.String(), nil
+}
+
+// Fprint writes node to w in the canonical style. It has no source to take
+// comments and blank lines from.
+func Fprint(w io.Writer, node ast.Node) error {
+	p := &printer{}
+	switch node := node.(type) {
+	case *ast.Program:
+		p.program(node)
+	case ast.Statement:
+		p.statement(node)
+	case ast.Expression:
+		p.expression(node, lowest)
+	}
+	_, err := w.Write(p.out.Bytes())
+	return err
+}
+
+type printer struct {
+	out   bytes.Buffer
+	depth int
+
+	// comments are the comments of the source, of which the first next
+	// have been printed.
+	comments []gtoken.Token
+	next     int
+	// blank holds the numbers of the blank lines of the source.
+	blank map[int]bool
+	// last is the source line of what was printed last, and first is set
+	// while nothing has been printed in the current statement list.
+	last  int
+	first bool
+}
+
+func (p *printer) program(program *ast.Program) {
+	p.statements(program.Statements, ast.Pos{Line: 1 << 30}, false)
+	if p.out.Len() > 0 {
+		p.out.WriteString("\n")
+	}
+}
+
+// block prints a block of statements in braces, up to rbrace.
+func (p *printer) block(block *ast.BlockStatement) {
+	if len(block.Statements) == 0 && !p.commentBefore(start(block.Rbrace)) {
+		p.out.WriteString("{}")
+		return
+	}
+	p.out.WriteString("{")
+	p.depth++
+	p.statements(block.Statements, start(block.Rbrace), true)
+	p.depth--
+	p.newline(false)
+	p.out.WriteString("}")
+}
+
+// statements prints a list of statements, with the comments before end. In
+// a block, the last statement is its value and needs no semicolon.
+func (p *printer) statements(stmts []ast.Statement, end ast.Pos, block bool) {
+	p.first = true
+	for i, s := range stmts {
+		next := end
+		if i+1 < len(stmts) {
+			next, _ = ast.Span(stmts[i+1])
+		}
+		pos, _ := ast.Span(s)
+		p.flushComments(pos)
+		p.separate(pos.Line)
+		p.statement(s)
+
+		switch {
+		case !isExpression(s):
+		case isBlockExpression(s):
+			if i+1 < len(stmts) && continues(stmts[i+1]) {
+				p.out.WriteString(";")
+			}
+		case !block || i+1 < len(stmts):
+			p.out.WriteString(";")
+		}
+		_, stop := ast.Span(s)
+		p.last = stop.Line
+		if p.next < len(p.comments) {
+			c := p.comments[p.next]
+			if c.Line == p.last && start(c).Before(next) {
+				p.out.WriteString(" " + c.Literal)
+				p.next++
+			}
+		}
+	}
+	p.flushComments(end)
+	p.first = false
+}
+
+// separate starts a new line for what is on line in the source, keeping a
+// blank line before it if the source has one.
+func (p *printer) separate(line int) {
+	switch {
+	case p.first && p.depth == 0:
+	case p.first:
+		p.newline(false)
+	default:
+		p.newline(p.blankBetween(p.last, line))
+	}
+	p.first = false
+}
+
+func (p *printer) newline(blank bool) {
+	if blank {
+		p.out.WriteString("\n")
+	}
+	p.out.WriteString("\n")
+	p.out.WriteString(strings.Repeat(indent, p.depth))
+}
+
+func (p *printer) blankBetween(from, to int) bool {
+	for line := from + 1; line < to; line++ {
+		if p.blank[line] {
+			return true
+		}
+	}
+	return false
+}
+
+// flushComments prints the comments before pos on lines of their own.
+func (p *printer) flushComments(pos ast.Pos) {
+	for p.commentBefore(pos) {
+		c := p.comments[p.next]
+		p.separate(c.Line)
+		p.out.WriteString(c.Literal)
+		p.last = c.Line
+		p.next++
+	}
+}
+
+func (p *printer) commentBefore(pos ast.Pos) bool {
+	return p.next < len(p.comments) && start(p.comments[p.next]).Before(pos)
+}
+
+func start(tok gtoken.Token) ast.Pos {
+	pos, _ := ast.TokenSpan(tok)
+	return pos
+}
+
+// statement prints s without the semicolon ending an expression statement.
+func (p *printer) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		p.out.WriteString("let ")
+		p.binding(s.Name)
+		p.out.WriteString(" = ")
+		if fn, ok := s.Value.(*ast.FunctionLiteral); ok && fn.Name == s.Name.Value {
+			// The parser names a function after the let binding it.
+			p.function(fn, false)
+		} else {
+			p.expression(s.Value, lowest)
+		}
+		p.out.WriteString(";")
+	case *ast.ReturnStatement:
+		p.out.WriteString("return ")
+		p.expression(s.ReturnValue, lowest)
+		p.out.WriteString(";")
+	case *ast.ThrowStatement:
+		p.out.WriteString("throw ")
+		p.expression(s.Value, lowest)
+		p.out.WriteString(";")
+	case *ast.TryStatement:
+		p.out.WriteString("try ")
+		p.block(s.Block)
+		if s.Catch != nil {
+			p.out.WriteString(" catch ")
+			if s.Param != nil {
+				p.out.WriteString("(" + s.Param.Value + ") ")
+			}
+			p.block(s.Catch)
+		}
+		if s.Finally != nil {
+			p.out.WriteString(" finally ")
+			p.block(s.Finally)
+		}
+	case *ast.BlockStatement:
+		p.block(s)
+	case *ast.ExpressionStatement:
+		p.expression(s.Expression, lowest)
+	case *ast.TypeStatement:
+		p.out.WriteString("type " + s.Name.Value + " " + s.Type.Value + " {")
+		p.depth++
+		if s.Body != nil {
+			p.statements(s.Body.Statements, start(s.Body.Rbrace), true)
+		}
+		p.depth--
+		p.newline(false)
+		p.out.WriteString("}")
+	case *ast.StructStatement:
+		p.out.WriteString("struct " + s.Name.Value + " ")
+		p.block(s.Body)
+	}
+}
+
+// expression prints e, in parentheses if it binds looser than prec.
+func (p *printer) expression(e ast.Expression, prec int) {
+	if e == nil {
+		return
+	}
+	if precedence(e) < prec {
+		p.out.WriteString("(")
+		defer p.out.WriteString(")")
+	}
+
+	switch e := e.(type) {
+	case *ast.Identifier:
+		p.out.WriteString(e.Value)
+	case *ast.TypeIdentifier:
+		p.out.WriteString(e.Value + " " + e.Variable.Value)
+	case *ast.IntegerLiteral:
+		if e.Token.Literal != "" {
+			p.out.WriteString(e.Token.Literal)
+		} else {
+			p.out.WriteString(strconv.FormatInt(e.Value, 10))
+		}
+	case *ast.StringLiteral:
+		p.out.WriteString(`"` + e.Value + `"`)
+	case *ast.Boolean:
+		p.out.WriteString(strconv.FormatBool(e.Value))
+	case *ast.Null:
+		p.out.WriteString("null")
+	case *ast.PrefixExpression:
+		p.out.WriteString(e.Operator)
+		p.expression(e.Right, prefix)
+	case *ast.InfixExpression:
+		op := precedences[e.Operator]
+		p.expression(e.Left, op)
+		p.out.WriteString(" " + e.Operator + " ")
+		p.expression(e.Right, op+1)
+	case *ast.CallExpression:
+		p.expression(e.Function, postfix)
+		p.out.WriteString("(")
+		p.list(e.Arguments)
+		p.out.WriteString(")")
+	case *ast.IndexExpression:
+		p.expression(e.Left, postfix)
+		p.out.WriteString("[")
+		p.expression(e.Index, lowest)
+		p.out.WriteString("]")
+	case *ast.PropagateExpression:
+		p.expression(e.Value, postfix)
+		p.out.WriteString("?")
+	case *ast.ArrayLiteral:
+		p.out.WriteString("[")
+		p.list(e.Elements)
+		p.out.WriteString("]")
+	case *ast.TupleLiteral:
+		p.out.WriteString("(")
+		p.list(e.Elements)
+		if len(e.Elements) == 1 {
+			p.out.WriteString(",")
+		}
+		p.out.WriteString(")")
+	case *ast.HashLiteral:
+		p.hash(e)
+	case *ast.FunctionLiteral:
+		p.function(e, e.Name != "")
+	case *ast.IfExpression:
+		p.out.WriteString("if (")
+		p.expression(e.Condition, lowest)
+		p.out.WriteString(") ")
+		p.block(e.Consequence)
+		if e.Alternative != nil {
+			p.out.WriteString(" else ")
+			p.block(e.Alternative)
+		}
+	case *ast.ForExpression:
+		p.out.WriteString("for (")
+		if e.Init != nil {
+			p.statement(e.Init)
+			if isExpression(e.Init) {
+				p.out.WriteString(";")
+			}
+		} else {
+			p.out.WriteString(";")
+		}
+		if e.Condition != nil {
+			p.out.WriteString(" ")
+			p.expression(e.Condition, lowest)
+		}
+		p.out.WriteString(";")
+		if e.Increment != nil {
+			p.out.WriteString(" ")
+			p.expression(e.Increment, lowest)
+		}
+		p.out.WriteString(") ")
+		p.block(e.Consequence)
+	case *ast.ForInExpression:
+		p.out.WriteString("for (")
+		for i, v := range e.Variables {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			p.out.WriteString(v.Value)
+		}
+		p.out.WriteString(" in ")
+		p.expression(e.Iterable, lowest)
+		p.out.WriteString(") ")
+		p.block(e.Consequence)
+	}
+}
+
+func (p *printer) list(exprs []ast.Expression) {
+	for i, e := range exprs {
+		if i > 0 {
+			p.out.WriteString(", ")
+		}
+		p.expression(e, lowest)
+	}
+}
+
+func (p *printer) function(fn *ast.FunctionLiteral, named bool) {
+	p.out.WriteString("fn")
+	if named {
+		p.out.WriteString(" " + fn.Name)
+	}
+	p.out.WriteString("(")
+	for i, param := range fn.Parameters {
+		if i > 0 {
+			p.out.WriteString(", ")
+		}
+		p.binding(param)
+	}
+	p.out.WriteString(")")
+	if fn.ReturnType != nil {
+		p.out.WriteString(": " + fn.ReturnType.Value)
+	}
+	p.out.WriteString(" ")
+	p.block(fn.Body)
+}
+
+// binding prints the name of a let or a parameter, with its annotation.
+func (p *printer) binding(id *ast.Identifier) {
+	p.out.WriteString(id.Value)
+	if id.Type != nil {
+		p.out.WriteString(": " + id.Type.Value)
+	}
+}
+
+// hash prints the pairs of a hash in the order of their keys in the
+// source, or of the keys printed if they have no positions.
+func (p *printer) hash(hash *ast.HashLiteral) {
+	type pair struct {
+		key, value ast.Expression
+		pos        ast.Pos
+		text       string
+	}
+	pairs := make([]pair, 0, len(hash.Pairs))
+	for k, v := range hash.Pairs {
+		var text bytes.Buffer
+		Fprint(&text, k)
+		pos, _ := ast.Span(k)
+		pairs = append(pairs, pair{k, v, pos, text.String()})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+		if a.pos != b.pos {
+			return a.pos.Before(b.pos)
+		}
+		return a.text < b.text
+	})
+
+	p.out.WriteString("{")
+	for i, pair := range pairs {
+		if i > 0 {
+			p.out.WriteString(", ")
+		}
+		p.expression(pair.key, lowest)
+		p.out.WriteString(": ")
+		p.expression(pair.value, lowest)
+	}
+	p.out.WriteString("}")
+}
+
+func precedence(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return precedences[e.Operator]
+	case *ast.PrefixExpression:
+		return prefix
+	case *ast.CallExpression, *ast.IndexExpression, *ast.PropagateExpression:
+		return postfix
+	}
+	return primary
+}
+
+func isExpression(s ast.Statement) bool {
+	_, ok := s.(*ast.ExpressionStatement)
+	return ok
+}
+
+// isBlockExpression reports whether s is an if or for statement, which
+// ends with its block and needs no semicolon.
+func isBlockExpression(s ast.Statement) bool {
+	switch s.(*ast.ExpressionStatement).Expression.(type) {
+	case *ast.IfExpression, *ast.ForExpression, *ast.ForInExpression:
+		return true
+	}
+	return false
+}
+
+// continues reports whether s starts with a token that would continue an
+// expression before it, as a call, index or subtraction.
+func continues(s ast.Statement) bool {
+	if !isExpression(s) {
+		return false
+	}
+	var out bytes.Buffer
+	Fprint(&out, s)
+	return out.Len() > 0 && strings.ContainsRune("([-", rune(out.Bytes()[0]))
+}
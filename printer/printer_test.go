@@ -0,0 +1,195 @@
+package printer
+
+import (
+	"bytes"
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/ast"
+	"github.com/GhostNet-Dev/gscript/gtoken"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/parser"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1+2*3;x", "let x = 1 + 2 * 3;\nx;\n"},
+		{"(1 + 2) * 3 - (4 - 5)", "(1 + 2) * 3 - (4 - 5);\n"},
+		{"-(a + b); !(-a); (-a)[0]; -a[0]", "-(a + b);\n!-a;\n(-a)[0];\n-a[0];\n"},
+		{"a = b = c; a = (b = c)", "a = b = c;\na = (b = c);\n"},
+		{"let t=(1,2);(t ,)", "let t = (1, 2);\n(t,);\n"},
+		{"for(k,v in h){k} for (x in range(3)) {x}", "for (k, v in h) {\n  k\n}\nfor (x in range(3)) {\n  x\n}\n"},
+		{`let h = {"b":2,"a":[1,2], true: fn(x){x}}`, "let h = {\"b\": 2, \"a\": [1, 2], true: fn(x) {\n  x\n}};\n"},
+		{"let add = fn(a, b) { let sum = a + b; sum }; add(1)(2)?", "let add = fn(a, b) {\n  let sum = a + b;\n  sum\n};\nadd(1)(2)?;\n"},
+		{"fn fact(n) { if (n < 2) { return 1; } else { n * fact(n - 1) } }",
+			"fn fact(n) {\n  if (n < 2) {\n    return 1;\n  } else {\n    n * fact(n - 1)\n  }\n};\n"},
+		{"for (let i = 0; i < 3; i = i + 1) { puts(i); }\nfor (;;) {}\nfor (i = 0; ; ) { break_(); }",
+			"for (let i = 0; i < 3; i = i + 1) {\n  puts(i)\n}\nfor (;;) {}\nfor (i = 0;;) {\n  break_()\n}\n"},
+		{"if (x) { 1 };\n(y + 1) * 2\nif (x) { 1 };\n-y\nif (x) { 1 }\ny",
+			"if (x) {\n  1\n};\n(y + 1) * 2;\nif (x) {\n  1\n};\n-y;\nif (x) {\n  1\n}\ny;\n"},
+		{"try { throw \"e\" } catch (e) { puts(e) } finally { 1 }\ntry { 1 } catch { 2 }",
+			"try {\n  throw \"e\";\n} catch (e) {\n  puts(e)\n} finally {\n  1\n}\ntry {\n  1\n} catch {\n  2\n}\n"},
+		{"{ let a = 1; a }; {\"k\": 1}[\"k\"]", "{\n  let a = 1;\n  a\n}\n{\"k\": 1}[\"k\"];\n"},
+		{
+			"// header\n\n\nlet a = 1; // one\n\n// two\nlet b = 2;\nlet f = fn() {\n  // inside\n\n  a // value\n  // end\n};\n// trailer\n",
+			"// header\n\nlet a = 1; // one\n\n// two\nlet b = 2;\nlet f = fn() {\n  // inside\n\n  a // value\n  // end\n};\n// trailer\n",
+		},
+		{"let f = fn() { // opening\n};", "let f = fn() {\n  // opening\n};\n"},
+		{"let s = \"a\n\nb\";\nputs(s)", "let s = \"a\n\nb\";\nputs(s);\n"},
+		{"let n:int=1; let f=fn(a:string,b):bool{a==b}", "let n: int = 1;\nlet f = fn(a: string, b): bool {\n  a == b\n};\n"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := Format(tt.input)
+		if err != nil {
+			t.Errorf("Format(%q) error: %s", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("Format(%q) wrong.\nwant=%q\ngot=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	_, err := Format("let x = ;\nlet = 2;")
+	perr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("err is not *Error. got=%T (%v)", err, err)
+	}
+	if len(perr.Errors) == 0 || !strings.HasPrefix(err.Error(), "1:9: ") {
+		t.Errorf("wrong error. got=%q", err.Error())
+	}
+}
+
+// TestRoundTrip formats every program the tests of the other packages run,
+// and checks that formatting again changes nothing and that the formatted
+// program parses to the same tree.
+func TestRoundTrip(t *testing.T) {
+	inputs := testInputs(t)
+	if len(inputs) < 100 {
+		t.Fatalf("found only %d test inputs", len(inputs))
+	}
+	for _, input := range inputs {
+		formatted, err := Format(input)
+		if err != nil {
+			t.Errorf("Format(%q) error: %s", input, err)
+			continue
+		}
+		again, err := Format(formatted)
+		if err != nil {
+			t.Errorf("formatted %q does not parse: %s\n%s", input, err, formatted)
+			continue
+		}
+		if again != formatted {
+			t.Errorf("formatting %q is not idempotent.\nonce=%q\ntwice=%q", input, formatted, again)
+		}
+		if want, got := dump(parse(input)), dump(parse(formatted)); want != got {
+			t.Errorf("formatting %q changed the tree.\nwant=%s\ngot=%s", input, want, got)
+		}
+	}
+}
+
+// testInputs returns the string constants in the tests of the repository,
+// and the scripts in their testdata, that parse as programs.
+func testInputs(t *testing.T) []string {
+	var inputs []string
+	files, _ := filepath.Glob("../*/*_test.go")
+	fset := gotoken.NewFileSet()
+	for _, file := range files {
+		f, err := goparser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		goast.Inspect(f, func(n goast.Node) bool {
+			lit, ok := n.(*goast.BasicLit)
+			if !ok || lit.Kind != gotoken.STRING {
+				return true
+			}
+			if s, err := strconv.Unquote(lit.Value); err == nil && parses(s) {
+				inputs = append(inputs, s)
+			}
+			return true
+		})
+	}
+	scripts, _ := filepath.Glob("../*/testdata/*.gs")
+	for _, file := range scripts {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, string(data))
+	}
+	return inputs
+}
+
+func parses(input string) bool {
+	p := parser.NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	return len(p.Errors()) == 0 && len(program.Statements) > 0
+}
+
+func parse(input string) *ast.Program {
+	return parser.NewParser(lexer.NewLexer(input)).ParseProgram()
+}
+
+// dump renders a tree without its token positions, and with the pairs of
+// hashes sorted, so that trees parsed from different layouts compare equal.
+func dump(node interface{}) string {
+	var out bytes.Buffer
+	dumpValue(&out, reflect.ValueOf(node))
+	return out.String()
+}
+
+var tokenType = reflect.TypeOf(gtoken.Token{})
+
+func dumpValue(out *bytes.Buffer, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			out.WriteString("nil")
+			return
+		}
+		dumpValue(out, v.Elem())
+	case reflect.Struct:
+		out.WriteString(v.Type().Name() + "{")
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Type == tokenType {
+				continue
+			}
+			out.WriteString(v.Type().Field(i).Name + ":")
+			dumpValue(out, v.Field(i))
+			out.WriteString(" ")
+		}
+		out.WriteString("}")
+	case reflect.Slice:
+		out.WriteString("[")
+		for i := 0; i < v.Len(); i++ {
+			dumpValue(out, v.Index(i))
+			out.WriteString(" ")
+		}
+		out.WriteString("]")
+	case reflect.Map:
+		var pairs []string
+		for _, k := range v.MapKeys() {
+			pairs = append(pairs, dump(k.Interface())+":"+dump(v.MapIndex(k).Interface()))
+		}
+		sort.Strings(pairs)
+		out.WriteString("map[" + strings.Join(pairs, " ") + "]")
+	default:
+		fmt.Fprintf(out, "%v", v.Interface())
+	}
+}
@@ -18,7 +18,7 @@ const PROMPT = ">> "
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
+	var globals []object.Object
 	symbolTable := compiler.NewSymbolTable()
 	for i, v := range object.Builtins {
 		symbolTable.DefineBuiltin(i, v.Name)
@@ -50,6 +50,8 @@ func Start(in io.Reader, out io.Writer) {
 		constants = code.Constants
 		machine := vm.NewVMWithGlobalsStore(code, globals)
 		err = machine.Run()
+		// Keep the globals defined before an error too.
+		globals = machine.GlobalsStore()
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
 			continue
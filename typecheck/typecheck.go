@@ -0,0 +1,613 @@
+// Package typecheck checks gscript programs against their optional type
+// annotations before they run.
+//
+// Annotations follow a colon, as in let n: int = 1 and fn(s: string): bool,
+// and name one of the types int, string, bool, null, array, tuple, hash, fn,
+// error and any, or a struct declared by a type statement. Code without
+// annotations stays dynamically typed: the checker infers types through
+// literals, builtins and function bodies, and reports only values that do
+// not match an annotation and operations that fail whatever the values.
+package typecheck
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/GhostNet-Dev/gscript/ast"
+	"github.com/GhostNet-Dev/gscript/gtoken"
+)
+
+// Type is the static type of a value.
+type Type interface {
+	String() string
+}
+
+// Basic is a type named by a single word.
+type Basic string
+
+const (
+	// Any is the type of values the checker knows nothing about, which
+	// match every type.
+	Any    Basic = "any"
+	Int    Basic = "int"
+	String Basic = "string"
+	Bool   Basic = "bool"
+	Null   Basic = "null"
+	Array  Basic = "array"
+	Tuple  Basic = "tuple"
+	Hash   Basic = "hash"
+	Error  Basic = "error"
+)
+
+func (b Basic) String() string { return string(b) }
+
+// Func is the type of a function. Params is nil for a function taking any
+// arguments, as the annotation fn declares.
+type Func struct {
+	Params []Type
+	Result Type
+}
+
+func (f *Func) String() string {
+	if f.Params == nil {
+		return "fn"
+	}
+	params := make([]string, len(f.Params))
+	for i, p := range f.Params {
+		params[i] = p.String()
+	}
+	s := "fn(" + strings.Join(params, ", ") + ")"
+	if f.Result != Any {
+		s += ": " + f.Result.String()
+	}
+	return s
+}
+
+// Struct is a type declared by a type statement, with the types of its
+// fields.
+type Struct struct {
+	Name   string
+	Fields map[string]Type
+}
+
+func (s *Struct) String() string { return s.Name }
+
+// builtins holds the signatures of the builtins. Their argument counts are
+// left to vet's arity check.
+var builtins = map[string]*Func{
+	"len":      {Params: []Type{Any}, Result: Int},
+	"puts":     {Result: Null},
+	"first":    {Params: []Type{Array}, Result: Any},
+	"last":     {Params: []Type{Array}, Result: Any},
+	"rest":     {Params: []Type{Array}, Result: Array},
+	"push":     {Params: []Type{Array, Any}, Result: Array},
+	"int":      {Params: []Type{String}, Result: Int},
+	"string":   {Params: []Type{Int}, Result: String},
+	"map":      {Params: []Type{Array, &Func{Result: Any}}, Result: Array},
+	"filter":   {Params: []Type{Array, &Func{Result: Any}}, Result: Array},
+	"error":    {Params: []Type{String}, Result: Error},
+	"is_error": {Params: []Type{Any}, Result: Bool},
+	"delete":   {Params: []Type{Hash, Any}, Result: Hash},
+	"freeze":   {Params: []Type{Any}, Result: Any},
+	"range":    {Result: Any},
+}
+
+// Mismatch is a type error found at Pos.
+type Mismatch struct {
+	Pos     ast.Pos
+	Message string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%d:%d: %s", m.Pos.Line, m.Pos.Column, m.Message)
+}
+
+// Check checks program and returns the mismatches found, in source order.
+func Check(program *ast.Program) []Mismatch {
+	c := &checker{structs: make(map[string]*Struct)}
+	c.scope = &scope{names: make(map[string]*binding)}
+	c.statements(program.Statements)
+	sort.SliceStable(c.errors, func(i, j int) bool {
+		return c.errors[i].Pos.Before(c.errors[j].Pos)
+	})
+	return c.errors
+}
+
+// assignable reports whether a value of type t may be used where one of
+// type to is expected.
+func assignable(t, to Type) bool {
+	if t == Any || to == Any {
+		return true
+	}
+	switch to := to.(type) {
+	case *Func:
+		f, ok := t.(*Func)
+		if !ok {
+			return false
+		}
+		if f.Params == nil || to.Params == nil {
+			return true
+		}
+		if len(f.Params) != len(to.Params) {
+			return false
+		}
+		for i := range f.Params {
+			if !assignable(to.Params[i], f.Params[i]) {
+				return false
+			}
+		}
+		return assignable(f.Result, to.Result)
+	case *Struct:
+		s, ok := t.(*Struct)
+		return ok && s.Name == to.Name
+	}
+	return t == to
+}
+
+// binding is a name bound by a let, a parameter or a declaration.
+type binding struct {
+	typ Type
+	// declared is set when typ comes from an annotation; otherwise it is
+	// inferred, and assigning a value of another type makes it any.
+	declared bool
+}
+
+type scope struct {
+	outer *scope
+	names map[string]*binding
+}
+
+func (s *scope) lookup(name string) *binding {
+	for ; s != nil; s = s.outer {
+		if b, ok := s.names[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// function is the function whose body is being checked.
+type function struct {
+	name    string
+	result  Type // annotated, or nil
+	returns []Type
+}
+
+type checker struct {
+	errors  []Mismatch
+	scope   *scope
+	structs map[string]*Struct
+	fn      *function
+}
+
+func (c *checker) errorf(pos ast.Pos, format string, a ...interface{}) {
+	c.errors = append(c.errors, Mismatch{Pos: pos, Message: fmt.Sprintf(format, a...)})
+}
+
+func (c *checker) enter() {
+	c.scope = &scope{outer: c.scope, names: make(map[string]*binding)}
+}
+
+func (c *checker) leave() {
+	c.scope = c.scope.outer
+}
+
+func (c *checker) define(name string, t Type, declared bool) {
+	c.scope.names[name] = &binding{typ: t, declared: declared}
+}
+
+// resolve returns the type an annotation names.
+func (c *checker) resolve(it *ast.IdentifierType) Type {
+	if it == nil {
+		return Any
+	}
+	switch it.Value {
+	case "any", "int", "string", "bool", "null", "array", "tuple", "hash", "error":
+		return Basic(it.Value)
+	case "fn":
+		return &Func{Result: Any}
+	}
+	if s, ok := c.structs[it.Value]; ok {
+		return s
+	}
+	c.errorf(start(it), "unknown type %s", it.Value)
+	return Any
+}
+
+// statements checks stmts and returns the type of their value: that of the
+// last one if it is an expression.
+func (c *checker) statements(stmts []ast.Statement) Type {
+	var t Type = Null
+	for _, s := range stmts {
+		t = c.statement(s)
+	}
+	return t
+}
+
+func (c *checker) block(b *ast.BlockStatement) Type {
+	if b == nil {
+		return Null
+	}
+	c.enter()
+	t := c.statements(b.Statements)
+	c.leave()
+	return t
+}
+
+func (c *checker) statement(s ast.Statement) Type {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		c.let(s)
+	case *ast.ReturnStatement:
+		t := c.expression(s.ReturnValue)
+		if c.fn != nil {
+			c.result(s.ReturnValue, t)
+		}
+	case *ast.ThrowStatement:
+		c.expression(s.Value)
+	case *ast.ExpressionStatement:
+		return c.expression(s.Expression)
+	case *ast.BlockStatement:
+		return c.block(s)
+	case *ast.TryStatement:
+		c.block(s.Block)
+		if s.Catch != nil {
+			c.enter()
+			if s.Param != nil {
+				c.define(s.Param.Value, Any, false)
+			}
+			c.statements(s.Catch.Statements)
+			c.leave()
+		}
+		c.block(s.Finally)
+	case *ast.TypeStatement:
+		c.typeStatement(s)
+	case *ast.StructStatement:
+		c.block(s.Body)
+	}
+	return Any
+}
+
+func (c *checker) let(s *ast.LetStatement) {
+	if s.Name.Type == nil {
+		c.define(s.Name.Value, c.expression(s.Value), false)
+		return
+	}
+	want := c.resolve(s.Name.Type)
+	if t := c.expression(s.Value); !assignable(t, want) {
+		c.errorf(start(s.Value), "cannot use %s as %s in let %s", t, want, s.Name.Value)
+	}
+	c.define(s.Name.Value, want, true)
+}
+
+// result records t as returned by the current function, reporting it if it
+// does not match the annotated result.
+func (c *checker) result(e ast.Expression, t Type) {
+	if c.fn.result == nil {
+		c.fn.returns = append(c.fn.returns, t)
+		return
+	}
+	if !assignable(t, c.fn.result) {
+		name := c.fn.name
+		if name == "" {
+			name = "function"
+		}
+		c.errorf(start(e), "cannot return %s from %s returning %s", t, name, c.fn.result)
+	}
+}
+
+// typeStatement declares a struct, whose fields are the lets in its body.
+func (c *checker) typeStatement(s *ast.TypeStatement) {
+	st := &Struct{Name: s.Name.Value, Fields: make(map[string]Type)}
+	c.structs[st.Name] = st
+	if s.Body == nil {
+		return
+	}
+	c.enter()
+	for _, stmt := range s.Body.Statements {
+		c.statement(stmt)
+		if let, ok := stmt.(*ast.LetStatement); ok {
+			st.Fields[let.Name.Value] = c.scope.names[let.Name.Value].typ
+		}
+	}
+	c.leave()
+}
+
+func (c *checker) function(fn *ast.FunctionLiteral) *Func {
+	sig := &Func{Params: make([]Type, len(fn.Parameters)), Result: Any}
+	for i, p := range fn.Parameters {
+		sig.Params[i] = c.resolve(p.Type)
+	}
+	f := &function{name: fn.Name}
+	if fn.ReturnType != nil {
+		sig.Result = c.resolve(fn.ReturnType)
+		f.result = sig.Result
+	}
+
+	c.enter()
+	if fn.Name != "" {
+		c.define(fn.Name, sig, true)
+	}
+	for i, p := range fn.Parameters {
+		c.define(p.Value, sig.Params[i], p.Type != nil)
+	}
+	outer := c.fn
+	c.fn = f
+	t := c.statements(fn.Body.Statements)
+	if n := len(fn.Body.Statements); n > 0 {
+		switch last := fn.Body.Statements[n-1].(type) {
+		case *ast.ExpressionStatement:
+			c.result(last.Expression, t)
+		case *ast.ReturnStatement, *ast.ThrowStatement:
+		default:
+			f.returns = append(f.returns, Any)
+		}
+	}
+	c.fn = outer
+	c.leave()
+
+	if fn.ReturnType == nil && len(f.returns) > 0 {
+		sig.Result = f.returns[0]
+		for _, r := range f.returns[1:] {
+			if r != sig.Result {
+				sig.Result = Any
+			}
+		}
+	}
+	return sig
+}
+
+func (c *checker) expression(e ast.Expression) Type {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.StringLiteral:
+		return String
+	case *ast.Boolean:
+		return Bool
+	case *ast.Null:
+		return Null
+	case *ast.Identifier:
+		if b := c.scope.lookup(e.Value); b != nil {
+			return b.typ
+		}
+		if sig, ok := builtins[e.Value]; ok {
+			return sig
+		}
+	case *ast.TypeIdentifier:
+		// a b declares b of type a.
+		c.define(e.Variable.Value, c.resolve(&ast.IdentifierType{Token: e.Token, Value: e.Value}), true)
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			c.expression(el)
+		}
+		return Array
+	case *ast.TupleLiteral:
+		for _, el := range e.Elements {
+			c.expression(el)
+		}
+		return Tuple
+	case *ast.HashLiteral:
+		c.hash(e)
+		return Hash
+	case *ast.PrefixExpression:
+		t := c.expression(e.Right)
+		if e.Operator == "!" {
+			return Bool
+		}
+		if !assignable(t, Int) {
+			c.errorf(at(e.Token), "operator %s not defined on %s", e.Operator, t)
+		}
+		return Int
+	case *ast.InfixExpression:
+		return c.infix(e)
+	case *ast.IfExpression:
+		c.expression(e.Condition)
+		t := c.block(e.Consequence)
+		if e.Alternative != nil && c.block(e.Alternative) == t {
+			return t
+		}
+	case *ast.ForExpression:
+		c.enter()
+		if e.Init != nil {
+			c.statement(e.Init)
+		}
+		c.expression(e.Condition)
+		c.block(e.Consequence)
+		c.expression(e.Increment)
+		c.leave()
+	case *ast.ForInExpression:
+		c.forIn(e)
+		return Null
+	case *ast.FunctionLiteral:
+		return c.function(e)
+	case *ast.CallExpression:
+		return c.call(e)
+	case *ast.IndexExpression:
+		c.index(e)
+	case *ast.PropagateExpression:
+		c.expression(e.Value)
+		// An error value makes the function return it.
+		if c.fn != nil && c.fn.result == nil {
+			c.fn.returns = append(c.fn.returns, Error)
+		}
+	}
+	return Any
+}
+
+func (c *checker) infix(e *ast.InfixExpression) Type {
+	if e.Operator == "=" {
+		t := c.expression(e.Right)
+		ident, ok := e.Left.(*ast.Identifier)
+		if !ok {
+			c.expression(e.Left)
+			return t
+		}
+		if b := c.scope.lookup(ident.Value); b != nil {
+			if b.declared && !assignable(t, b.typ) {
+				c.errorf(start(e.Right), "cannot assign %s to %s of type %s", t, ident.Value, b.typ)
+			} else if !b.declared && b.typ != t {
+				b.typ = Any
+			}
+		}
+		return t
+	}
+
+	left, right := c.expression(e.Left), c.expression(e.Right)
+	switch e.Operator {
+	case "+":
+		for _, t := range []Type{Int, String} {
+			if assignable(left, t) && assignable(right, t) {
+				if left == Any && right == Any {
+					return Any
+				}
+				return t
+			}
+		}
+		c.errorf(at(e.Token), "operator + not defined on %s and %s", left, right)
+	case "-", "*", "/":
+		if !assignable(left, Int) || !assignable(right, Int) {
+			c.errorf(at(e.Token), "operator %s not defined on %s and %s", e.Operator, left, right)
+		}
+		return Int
+	case "<", ">":
+		for _, t := range []Type{Int, String} {
+			if assignable(left, t) && assignable(right, t) {
+				return Bool
+			}
+		}
+		c.errorf(at(e.Token), "operator %s not defined on %s and %s", e.Operator, left, right)
+		return Bool
+	case "==", "!=":
+		return Bool
+	}
+	return Any
+}
+
+func (c *checker) call(e *ast.CallExpression) Type {
+	callee := c.expression(e.Function)
+	args := make([]Type, len(e.Arguments))
+	for i, arg := range e.Arguments {
+		args[i] = c.expression(arg)
+	}
+
+	name := "function"
+	builtin := false
+	ident, named := e.Function.(*ast.Identifier)
+	if named {
+		name = ident.Value
+		builtin = c.scope.lookup(name) == nil && builtins[name] != nil
+	}
+	sig, ok := callee.(*Func)
+	if !ok {
+		if named && callee != Any {
+			c.errorf(start(ident), "cannot call %s of type %s", name, callee)
+		} else if callee != Any {
+			c.errorf(start(e.Function), "cannot call %s", callee)
+		}
+		return Any
+	}
+	if sig.Params == nil {
+		return sig.Result
+	}
+	if !builtin && len(args) != len(sig.Params) {
+		plural := "s"
+		if len(sig.Params) == 1 {
+			plural = ""
+		}
+		c.errorf(start(e.Function), "%s takes %d argument%s, got %d",
+			name, len(sig.Params), plural, len(args))
+	}
+	for i, t := range args {
+		if i < len(sig.Params) && !assignable(t, sig.Params[i]) {
+			c.errorf(start(e.Arguments[i]), "cannot use %s as %s in argument %d to %s",
+				t, sig.Params[i], i+1, name)
+		}
+	}
+	return sig.Result
+}
+
+// forIn checks a for-in loop, giving its variables the types of the keys
+// and values of the collection where they are known.
+func (c *checker) forIn(e *ast.ForInExpression) {
+	t := c.expression(e.Iterable)
+	key, value := Type(Any), Type(Any)
+	switch t {
+	case Any, Hash:
+	case Array, Tuple:
+		key = Int
+	case String:
+		key, value = Int, String
+	default:
+		if _, ok := t.(*Struct); !ok {
+			c.errorf(start(e.Iterable), "cannot iterate %s", t)
+		}
+	}
+	types := []Type{value}
+	if len(e.Variables) == 2 {
+		types = []Type{key, value}
+	}
+	c.enter()
+	for i, v := range e.Variables {
+		c.define(v.Value, types[i], false)
+	}
+	if e.Consequence != nil {
+		c.statements(e.Consequence.Statements)
+	}
+	c.leave()
+}
+
+func (c *checker) index(e *ast.IndexExpression) {
+	left, index := c.expression(e.Left), c.expression(e.Index)
+	switch left {
+	case Any, Hash:
+	case Array, Tuple:
+		if !assignable(index, Int) {
+			c.errorf(start(e.Index), "cannot index %s with %s", left, index)
+		}
+	case Error:
+		if !assignable(index, String) {
+			c.errorf(start(e.Index), "cannot index error with %s", index)
+		}
+	default:
+		c.errorf(start(e.Left), "cannot index %s", left)
+	}
+}
+
+// hash checks the pairs of a hash in source order, reporting keys that
+// cannot be hashed.
+func (c *checker) hash(e *ast.HashLiteral) {
+	keys := make([]ast.Expression, 0, len(e.Pairs))
+	for k := range e.Pairs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return start(keys[i]).Before(start(keys[j]))
+	})
+	for _, k := range keys {
+		switch t := c.expression(k); t {
+		case Any, Int, String, Bool, Tuple:
+		case Array:
+			// An array is a key once frozen, which a literal never is.
+			if _, ok := k.(*ast.ArrayLiteral); ok {
+				c.errorf(start(k), "invalid hash key of type %s", t)
+			}
+		default:
+			c.errorf(start(k), "invalid hash key of type %s", t)
+		}
+		c.expression(e.Pairs[k])
+	}
+}
+
+// start returns where node starts.
+func start(node ast.Node) ast.Pos {
+	pos, _ := ast.Span(node)
+	return pos
+}
+
+// at returns where tok starts.
+func at(tok gtoken.Token) ast.Pos {
+	pos, _ := ast.TokenSpan(tok)
+	return pos
+}
@@ -0,0 +1,135 @@
+package typecheck
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/object"
+	"github.com/GhostNet-Dev/gscript/parser"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"let a = 1; let b = a + 2; let c = b * len([1]); a - \"x\"; c + \"y\"; -true;",
+			[]string{
+				"1:51: operator - not defined on int and string",
+				"1:60: operator + not defined on int and string",
+				"1:67: operator - not defined on bool",
+			},
+		},
+		{
+			"let f = fn(x, y) { if (x) { 1 } else { y } }; f(1, 2) + \"s\"; let g = fn(s) { s }; g(\"a\")[0];",
+			nil,
+		},
+		{
+			"let n: int = \"1\"; let s: string = string(1); let h: hash = {}; let e: error = error(\"e\");\nlet q: thing = 1;",
+			[]string{
+				"1:14: cannot use string as int in let n",
+				"2:8: unknown type thing",
+			},
+		},
+		{
+			"let add = fn(a: int, b: int): int { a + b };\nadd(1, \"2\"); add(1); add(1, 2) + \"3\";\nlet k: string = add(1, 2);",
+			[]string{
+				"2:8: cannot use string as int in argument 2 to add",
+				"2:14: add takes 2 arguments, got 1",
+				"2:32: operator + not defined on int and string",
+				"3:17: cannot use int as string in let k",
+			},
+		},
+		{
+			"let f = fn(a): bool { if (a) { return 1; } a == 1 };\nlet g = fn(): string { \"s\" + 1 };\nlet r = fn(n: int): int { if (n < 1) { return 0; } r(n - 1) + 1 };",
+			[]string{
+				"1:39: cannot return int from f returning bool",
+				"2:28: operator + not defined on string and int",
+			},
+		},
+		{
+			"for (c in \"ab\") { c - 1 }; for (i, x in [1]) { i + \"s\" }; for (x in 1) { x }; for (k, v in {}) { k + v }; let t: tuple = (1, 2); t[\"a\"];",
+			[]string{
+				"1:21: operator - not defined on string and int",
+				"1:50: operator + not defined on int and string",
+				"1:69: cannot iterate int",
+				"1:132: cannot index tuple with string",
+			},
+		},
+		{
+			"let a: bool = \"a\" < \"b\"; \"a\" > 1; let s = \"s\"; s < \"t\" == true;",
+			[]string{
+				"1:30: operator > not defined on string and int",
+			},
+		},
+		{
+			"let x: int = 1; x = 2; x = \"s\"; let y = 1; y = \"s\"; y + \"t\";",
+			[]string{
+				"1:28: cannot assign string to x of type int",
+			},
+		},
+		{
+			"let s = fn() { \"s\" }; s() - 1; let t = fn(n) { if (n) { return 1; } \"s\" }; t(1) - 1;\nlet f: fn = len; f(1, 2); let g: fn = 1;",
+			[]string{
+				"1:27: operator - not defined on string and int",
+				"2:39: cannot use int as fn in let g",
+			},
+		},
+		{
+			"let a = 1; a(); \"s\"[0]; [1][\"x\"]; {[1]: 2, \"k\": 3}; error(\"e\")[\"message\"]; first(\"s\"); map([1], 2);",
+			[]string{
+				"1:12: cannot call a of type int",
+				"1:17: cannot index string",
+				"1:29: cannot index array with string",
+				"1:36: invalid hash key of type array",
+				"1:82: cannot use string as array in argument 1 to first",
+				"1:97: cannot use int as fn in argument 2 to map",
+			},
+		},
+		{
+			"type point struct { let x: int = 1; let y: int = \"2\"; }\nlet p: point = 1;\nlet f = fn(q: point): point { q }; f(f(1));",
+			[]string{
+				"1:50: cannot use string as int in let y",
+				"2:16: cannot use int as point in let p",
+				"3:40: cannot use int as point in argument 1 to f",
+			},
+		},
+		{
+			"let f = fn(x) { if (x) { return error(\"e\"); } x }; let g = fn(x) { let v = f(x)?; v + 1 }; g(1)[\"message\"];\n(1 + 2)(3);",
+			[]string{
+				"2:2: cannot call int",
+			},
+		},
+		{
+			"let apply = fn(f: fn, x) { f(x) }; apply(fn(a) { a }, 1); apply(1, 1);\nlet id = fn(a: int): int { a }; let h: fn = id;",
+			[]string{
+				"1:65: cannot use int as fn in argument 1 to apply",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		p := parser.NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%q: parser errors: %v", tt.input, p.Errors())
+		}
+		var got []string
+		for _, m := range Check(program) {
+			got = append(got, m.String())
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: wrong mismatches.\nwant=%q\ngot=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	for _, b := range object.Builtins {
+		if _, ok := builtins[b.Name]; !ok {
+			t.Errorf("no signature for builtin %s", b.Name)
+		}
+	}
+}
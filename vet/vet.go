@@ -0,0 +1,428 @@
+// Package vet reports suspicious constructs in gscript programs: code that
+// runs but likely does not do what its author meant.
+//
+// A diagnostic is suppressed by a comment on its line: "// vet:ignore"
+// suppresses all of them, and "// vet:ignore unused,shadow" the ones of
+// the checks listed.
+package vet
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/GhostNet-Dev/gscript/ast"
+	"github.com/GhostNet-Dev/gscript/gtoken"
+	"github.com/GhostNet-Dev/gscript/object"
+	"github.com/GhostNet-Dev/gscript/printer"
+	"github.com/GhostNet-Dev/gscript/typecheck"
+)
+
+// Checks lists the checks by ID, with what each reports.
+var Checks = []struct {
+	ID  string
+	Doc string
+}{
+	{"unused", "let bindings and parameters that are never read"},
+	{"shadow", "bindings that hide a binding or builtin of an enclosing scope"},
+	{"unreachable", "statements after a return or throw"},
+	{"arity", "calls to builtins with the wrong number of arguments"},
+	{"dupkey", "hash literals with the same literal key twice"},
+	{"selfcompare", "comparisons of an expression with itself"},
+	{"undefined", "assignments to variables that are not defined"},
+	{"types", "values that do not match their type annotations, and operations on values of the wrong type"},
+}
+
+// arities holds the number of arguments each builtin takes, or -1 if it
+// takes any number.
+var arities = map[string]int{
+	"len":      1,
+	"puts":     -1,
+	"first":    1,
+	"last":     1,
+	"rest":     1,
+	"push":     2,
+	"int":      1,
+	"string":   1,
+	"map":      2,
+	"filter":   2,
+	"error":    1,
+	"is_error": 1,
+	"delete":   2,
+	"freeze":   1,
+	"range":    -1,
+}
+
+// Diagnostic is a suspicious construct found by the check named Check.
+type Diagnostic struct {
+	Line, Column int
+	Check        string
+	Message      string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s (%s)", d.Line, d.Column, d.Message, d.Check)
+}
+
+// Check runs the checks over program, parsed from a source with comments,
+// and returns what they found in source order.
+func Check(program *ast.Program, comments []gtoken.Token) []Diagnostic {
+	c := &checker{builtins: make(map[string]bool)}
+	for _, b := range object.Builtins {
+		c.builtins[b.Name] = true
+	}
+	c.scope = &scope{names: make(map[string]*binding), global: true}
+	c.statements(program.Statements)
+	c.leave()
+	for _, m := range typecheck.Check(program) {
+		c.reportAt(m.Pos, "types", "%s", m.Message)
+	}
+
+	ignored := ignores(comments)
+	diags := c.diags[:0]
+	for _, d := range c.diags {
+		if checks, ok := ignored[d.Line]; !ok || len(checks) > 0 && !checks[d.Check] {
+			diags = append(diags, d)
+		}
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i], diags[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return diags
+}
+
+// ignores returns the checks suppressed by the comments, by line. An empty
+// set suppresses them all.
+func ignores(comments []gtoken.Token) map[int]map[string]bool {
+	ignored := make(map[int]map[string]bool)
+	for _, c := range comments {
+		text := strings.TrimSpace(strings.TrimPrefix(c.Literal, "//"))
+		if text != "vet:ignore" && !strings.HasPrefix(text, "vet:ignore ") {
+			continue
+		}
+		checks := make(map[string]bool)
+		for _, id := range strings.FieldsFunc(strings.TrimPrefix(text, "vet:ignore"), func(r rune) bool {
+			return r == ',' || r == ' '
+		}) {
+			checks[id] = true
+		}
+		ignored[c.Line] = checks
+	}
+	return ignored
+}
+
+// binding is a name bound by a let, a parameter or a catch.
+type binding struct {
+	name  string
+	tok   gtoken.Token
+	param bool
+	used  bool
+	// self is set for the name a function has for itself, which refers to
+	// the binding of the function in the enclosing scope.
+	self *binding
+}
+
+type scope struct {
+	outer  *scope
+	names  map[string]*binding
+	global bool
+}
+
+type checker struct {
+	diags    []Diagnostic
+	scope    *scope
+	builtins map[string]bool
+}
+
+func (c *checker) report(tok gtoken.Token, check, format string, a ...interface{}) {
+	pos, _ := ast.TokenSpan(tok)
+	c.reportAt(pos, check, format, a...)
+}
+
+func (c *checker) reportAt(pos ast.Pos, check, format string, a ...interface{}) {
+	c.diags = append(c.diags, Diagnostic{pos.Line, pos.Column, check, fmt.Sprintf(format, a...)})
+}
+
+func (c *checker) enter() {
+	c.scope = &scope{outer: c.scope, names: make(map[string]*binding)}
+}
+
+// leave closes the current scope, reporting the bindings in it that were
+// never read. Globals may be read by the host, so they are not reported.
+func (c *checker) leave() {
+	for _, b := range c.scope.names {
+		c.unused(b)
+	}
+	c.scope = c.scope.outer
+}
+
+// unused reports b, of the current scope, if it was never read.
+func (c *checker) unused(b *binding) {
+	if c.scope.global || b.used || b.self != nil || strings.HasPrefix(b.name, "_") {
+		return
+	}
+	if b.param {
+		c.report(b.tok, "unused", "parameter %s is never used", b.name)
+	} else {
+		c.report(b.tok, "unused", "%s is declared but never used", b.name)
+	}
+}
+
+func (c *checker) define(ident *ast.Identifier, param bool) {
+	outer := c.scope.outer.lookup(ident.Value)
+	if prev := c.scope.names[ident.Value]; prev != nil {
+		// The binding is replaced, so it cannot be read any more. A
+		// function body shares the scope of the parameters, but a let
+		// in it hides one all the same.
+		c.unused(prev)
+		if prev.param {
+			outer = prev
+		}
+	}
+	if outer != nil {
+		if outer.self != nil {
+			outer = outer.self
+		}
+		c.report(ident.Token, "shadow", "%s shadows the declaration at %d:%d",
+			ident.Value, outer.tok.Line, outer.tok.Column)
+	} else if c.builtins[ident.Value] {
+		c.report(ident.Token, "shadow", "%s shadows the builtin", ident.Value)
+	}
+	c.scope.names[ident.Value] = &binding{name: ident.Value, tok: ident.Token, param: param}
+}
+
+// lookup returns the binding name refers to in s, or nil if it is a
+// builtin or undefined.
+func (s *scope) lookup(name string) *binding {
+	for ; s != nil; s = s.outer {
+		if b, ok := s.names[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+func (c *checker) statements(stmts []ast.Statement) {
+	for i, s := range stmts {
+		c.statement(s)
+		switch s.(type) {
+		case *ast.ReturnStatement, *ast.ThrowStatement:
+			if i+1 < len(stmts) {
+				pos, _ := ast.Span(stmts[i+1])
+				c.reportAt(pos, "unreachable", "unreachable code")
+			}
+			for _, rest := range stmts[i+1:] {
+				c.statement(rest)
+			}
+			return
+		}
+	}
+}
+
+func (c *checker) block(b *ast.BlockStatement) {
+	if b == nil {
+		return
+	}
+	c.enter()
+	c.statements(b.Statements)
+	c.leave()
+}
+
+func (c *checker) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		if fn, ok := s.Value.(*ast.FunctionLiteral); ok && fn.Name == s.Name.Value {
+			// The function can call itself by its name, which does not
+			// count as using the binding. It is defined by then, as
+			// scopes are closed in order.
+			c.define(s.Name, false)
+			c.function(fn, c.scope.names[s.Name.Value])
+			return
+		}
+		c.expression(s.Value)
+		c.define(s.Name, false)
+	case *ast.ReturnStatement:
+		c.expression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		c.expression(s.Value)
+	case *ast.ExpressionStatement:
+		c.expression(s.Expression)
+	case *ast.BlockStatement:
+		c.block(s)
+	case *ast.TryStatement:
+		c.block(s.Block)
+		if s.Catch != nil {
+			c.enter()
+			if s.Param != nil {
+				c.define(s.Param, false)
+			}
+			c.statements(s.Catch.Statements)
+			c.leave()
+		}
+		c.block(s.Finally)
+	}
+}
+
+// function checks fn, which is bound to self if it is not nil.
+func (c *checker) function(fn *ast.FunctionLiteral, self *binding) {
+	c.enter()
+	if self != nil {
+		c.scope.names[fn.Name] = &binding{name: fn.Name, tok: self.tok, self: self}
+	}
+	for _, p := range fn.Parameters {
+		c.define(p, true)
+	}
+	c.statements(fn.Body.Statements)
+	c.leave()
+}
+
+func (c *checker) expression(e ast.Expression) {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		if b := c.scope.lookup(e.Value); b != nil {
+			b.used = true
+		}
+	case *ast.PrefixExpression:
+		c.expression(e.Right)
+	case *ast.InfixExpression:
+		c.infix(e)
+	case *ast.IfExpression:
+		c.expression(e.Condition)
+		c.block(e.Consequence)
+		c.block(e.Alternative)
+	case *ast.ForExpression:
+		c.enter()
+		if e.Init != nil {
+			c.statement(e.Init)
+		}
+		c.expression(e.Condition)
+		c.block(e.Consequence)
+		c.expression(e.Increment)
+		c.leave()
+	case *ast.ForInExpression:
+		c.expression(e.Iterable)
+		if e.Consequence != nil {
+			c.enter()
+			for _, v := range e.Variables {
+				c.define(v, false)
+			}
+			c.statements(e.Consequence.Statements)
+			c.leave()
+		}
+	case *ast.FunctionLiteral:
+		c.function(e, nil)
+	case *ast.CallExpression:
+		c.call(e)
+	case *ast.IndexExpression:
+		c.expression(e.Left)
+		c.expression(e.Index)
+	case *ast.PropagateExpression:
+		c.expression(e.Value)
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			c.expression(el)
+		}
+	case *ast.TupleLiteral:
+		for _, el := range e.Elements {
+			c.expression(el)
+		}
+	case *ast.HashLiteral:
+		c.hash(e)
+	}
+}
+
+func (c *checker) infix(e *ast.InfixExpression) {
+	switch e.Operator {
+	case "=":
+		if ident, ok := e.Left.(*ast.Identifier); ok {
+			// Assigning to a variable does not read it.
+			if c.scope.lookup(ident.Value) == nil && !c.builtins[ident.Value] {
+				c.report(ident.Token, "undefined", "assignment to undefined variable %s", ident.Value)
+			}
+		} else {
+			c.expression(e.Left)
+		}
+		c.expression(e.Right)
+		return
+	case "==", "!=", "<", ">":
+		if left := source(e.Left); pure(e.Left) && left == source(e.Right) {
+			c.report(e.Token, "selfcompare", "%s %s %s compares an expression with itself",
+				left, e.Operator, left)
+		}
+	}
+	c.expression(e.Left)
+	c.expression(e.Right)
+}
+
+func (c *checker) call(e *ast.CallExpression) {
+	if ident, ok := e.Function.(*ast.Identifier); ok && c.scope.lookup(ident.Value) == nil {
+		if want, ok := arities[ident.Value]; ok && want >= 0 && want != len(e.Arguments) {
+			plural := "s"
+			if want == 1 {
+				plural = ""
+			}
+			c.report(ident.Token, "arity", "%s takes %d argument%s, got %d",
+				ident.Value, want, plural, len(e.Arguments))
+		}
+	}
+	c.expression(e.Function)
+	for _, arg := range e.Arguments {
+		c.expression(arg)
+	}
+}
+
+// hash reports the literal keys of a hash that are given again later.
+func (c *checker) hash(e *ast.HashLiteral) {
+	type key struct {
+		tok   gtoken.Token
+		value string
+	}
+	var keys []key
+	for k, v := range e.Pairs {
+		switch k := k.(type) {
+		case *ast.StringLiteral:
+			keys = append(keys, key{k.Token, fmt.Sprintf("%q", k.Value)})
+		case *ast.IntegerLiteral:
+			keys = append(keys, key{k.Token, fmt.Sprint(k.Value)})
+		case *ast.Boolean:
+			keys = append(keys, key{k.Token, fmt.Sprint(k.Value)})
+		}
+		c.expression(k)
+		c.expression(v)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i].tok, keys[j].tok
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if seen[k.value] {
+			c.report(k.tok, "dupkey", "duplicate key %s in hash literal", k.value)
+		}
+		seen[k.value] = true
+	}
+}
+
+// pure reports whether evaluating e has no effects, so that two
+// evaluations of it give the same value.
+func pure(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean, *ast.Null:
+		return true
+	case *ast.PrefixExpression:
+		return pure(e.Right)
+	case *ast.InfixExpression:
+		return e.Operator != "=" && pure(e.Left) && pure(e.Right)
+	case *ast.IndexExpression:
+		return pure(e.Left) && pure(e.Index)
+	}
+	return false
+}
+
+func source(e ast.Expression) string {
+	var out bytes.Buffer
+	printer.Fprint(&out, e)
+	return out.String()
+}
@@ -0,0 +1,138 @@
+package vet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/object"
+	"github.com/GhostNet-Dev/gscript/parser"
+)
+
+func TestChecks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"let f = fn(a, b, _c) { let d = 1; a };\nf(1, 2, 3);",
+			[]string{
+				"1:15: parameter b is never used (unused)",
+				"1:28: d is declared but never used (unused)",
+			},
+		},
+		{
+			"let unused = 1; let loop = fn(n) { loop(n) };",
+			nil,
+		},
+		{
+			"let f = fn(h) { for (k, v in h) { v }; for (_k, w in h) { w }; for (x in h) { x = 1 } };\nf({});",
+			[]string{
+				"1:22: k is declared but never used (unused)",
+				"1:69: x is declared but never used (unused)",
+			},
+		},
+		{
+			"let x = 1;\nlet f = fn(x) { if (x) { let x = 2; x } };\nlet len = 3;\nf(len);",
+			[]string{
+				"2:12: x shadows the declaration at 1:5 (shadow)",
+				"2:30: x shadows the declaration at 2:12 (shadow)",
+				"3:5: len shadows the builtin (shadow)",
+			},
+		},
+		{
+			"let f = fn(a) { let a = 2; a };\nf(1);",
+			[]string{
+				"1:12: parameter a is never used (unused)",
+				"1:21: a shadows the declaration at 1:12 (shadow)",
+			},
+		},
+		{
+			"let f = fn() { return 1; puts(2); 3 };\nf();\nthrow \"e\";\nlet y = 1;",
+			[]string{
+				"1:26: unreachable code (unreachable)",
+				"4:1: unreachable code (unreachable)",
+			},
+		},
+		{
+			"len(1, 2); push([]); puts(); puts(1, 2); first([1]);\nlet first = fn(a, b) { a + b }; first(1, 2);",
+			[]string{
+				"1:1: len takes 1 argument, got 2 (arity)",
+				"1:12: push takes 2 arguments, got 1 (arity)",
+				"2:5: first shadows the builtin (shadow)",
+			},
+		},
+		{
+			`{"a": 1, "b": 2, "a": 3, 1: 1, true: 2, 1: 3, "1": 4}`,
+			[]string{
+				`1:18: duplicate key "a" in hash literal (dupkey)`,
+				"1:41: duplicate key 1 in hash literal (dupkey)",
+			},
+		},
+		{
+			`let a = [1]; a == a; a[0] != a[0]; a + 1 < a + 1; "1" == 1; rest(a) == rest(a);`,
+			[]string{
+				"1:16: a == a compares an expression with itself (selfcompare)",
+				"1:27: a[0] != a[0] compares an expression with itself (selfcompare)",
+				"1:38: operator + not defined on array and int (types)",
+				"1:42: a + 1 < a + 1 compares an expression with itself (selfcompare)",
+				"1:46: operator + not defined on array and int (types)",
+			},
+		},
+		{
+			"let a = 1; a = 2; b = 3; let f = fn() { c = 1; a = 3; };",
+			[]string{
+				"1:19: assignment to undefined variable b (undefined)",
+				"1:41: assignment to undefined variable c (undefined)",
+			},
+		},
+		{
+			"let f = fn(a) { 1 }; // vet:ignore\n" +
+				"let g = fn(a) { b = 1 }; // vet:ignore undefined\n" +
+				"let h = fn(a) { a == a }; // vet:ignore unused, shadow\n" +
+				"f(g(h(1)));",
+			[]string{
+				"2:12: parameter a is never used (unused)",
+				"3:19: a == a compares an expression with itself (selfcompare)",
+			},
+		},
+		{
+			"try { 1 } catch (e) { 2 }\nfor (let i = 0; i < 2; i = i + 1) { let j = i; }",
+			[]string{
+				"1:18: e is declared but never used (unused)",
+				"2:41: j is declared but never used (unused)",
+			},
+		},
+		{
+			"let n: int = \"1\";\nlet f = fn(s: string): bool { s == \"\" };\nf(n); // vet:ignore types\nf(1);",
+			[]string{
+				"1:14: cannot use string as int in let n (types)",
+				"4:3: cannot use int as string in argument 1 to f (types)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewLexer(tt.input)
+		p := parser.NewParser(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%q: parser errors: %v", tt.input, p.Errors())
+		}
+		var got []string
+		for _, d := range Check(program, l.Comments()) {
+			got = append(got, d.String())
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: wrong diagnostics.\nwant=%q\ngot=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestArities(t *testing.T) {
+	for _, b := range object.Builtins {
+		if _, ok := arities[b.Name]; !ok {
+			t.Errorf("no arity for builtin %s", b.Name)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package vm
+
+import "github.com/GhostNet-Dev/gscript/object"
+
+// Debugger is called by a VM before each instruction it executes. The VM
+// waits while Before runs, which may inspect it through Frames, Locals,
+// Globals and Stack; an error from Before stops the program with that
+// error, uncaught by its try statements.
+type Debugger interface {
+	Before(vm *VM) error
+}
+
+// Variable is a named value of a paused program.
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// Frames returns the frame chain, from the main program to the function
+// about to execute an instruction.
+func (vm *VM) Frames() []*Frame {
+	frames := make([]*Frame, vm.framesIndex)
+	copy(frames, vm.frames)
+	return frames
+}
+
+// Locals returns the variables f can see other than globals: the locals
+// in scope at its instruction, in the order they were defined, then the
+// free variables of its closure. A local shadows any variable of the
+// same name defined before it.
+func (vm *VM) Locals(f *Frame) []Variable {
+	var vars []Variable
+	seen := make(map[string]bool)
+	locals := f.cl.Fn.Locals
+	for i := len(locals) - 1; i >= 0; i-- {
+		l := locals[i]
+		if f.ip < l.Start || f.ip >= l.End || seen[l.Name] {
+			continue
+		}
+		seen[l.Name] = true
+		if value := deref(vm.stack[f.basePointer+l.Index]); value != nil {
+			vars = append(vars, Variable{Name: l.Name, Value: value})
+		}
+	}
+	for i, j := 0, len(vars)-1; i < j; i, j = i+1, j-1 {
+		vars[i], vars[j] = vars[j], vars[i]
+	}
+	for i, name := range f.cl.Fn.Free {
+		if !seen[name] && i < len(f.cl.Free) {
+			vars = append(vars, Variable{Name: name, Value: deref(f.cl.Free[i])})
+		}
+	}
+	return vars
+}
+
+// Globals returns the globals that have been set, by slot.
+func (vm *VM) Globals() []Variable {
+	var vars []Variable
+	for i, name := range vm.globalNames {
+		if name == "" || i >= len(vm.globals) || vm.globals[i] == nil {
+			continue
+		}
+		vars = append(vars, Variable{Name: name, Value: vm.globals[i]})
+	}
+	return vars
+}
+
+// Stack returns the values on the stack, bottom first: the locals of
+// each frame followed by the operands it is working on.
+func (vm *VM) Stack() []object.Object {
+	stack := make([]object.Object, vm.sp)
+	for i, value := range vm.stack[:vm.sp] {
+		stack[i] = deref(value)
+	}
+	return stack
+}
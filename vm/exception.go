@@ -0,0 +1,57 @@
+package vm
+
+import (
+	"errors"
+
+	"github.com/GhostNet-Dev/gscript/object"
+)
+
+// unwind hands err to the innermost handler among the frames run above
+// depth, dropping the frames above it. It returns the error as an
+// *object.Error if no handler takes it. Aborts by the host are never
+// handled.
+func (vm *VM) unwind(err error, depth int) error {
+	if aborted(err) {
+		return err
+	}
+	exception, ok := err.(*object.Error)
+	if !ok {
+		exception = &object.Error{Message: err.Error(), Kind: object.RuntimeError}
+	}
+	if exception.Trace == nil {
+		exception.Trace = vm.trace()
+	}
+
+	for i := vm.framesIndex - 1; i >= depth; i-- {
+		frame := vm.frames[i]
+		h, ok := frame.handler()
+		if !ok {
+			continue
+		}
+		vm.framesIndex = i + 1
+		vm.sp = frame.basePointer + frame.cl.Fn.NumLocals + h.Depth
+		frame.ip = h.Target - 1
+		return vm.push(exception.Catch())
+	}
+	return exception
+}
+
+// trace names the active frames, the innermost first.
+func (vm *VM) trace() []string {
+	trace := make([]string, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i > 0; i-- {
+		name := vm.frames[i].cl.Fn.Name
+		if name == "" {
+			name = object.AnonymousFrame
+		}
+		trace = append(trace, name)
+	}
+	return append(trace, object.MainFrame)
+}
+
+func aborted(err error) bool {
+	return errors.Is(err, object.ErrCanceled) ||
+		errors.Is(err, object.ErrDeadlineExceeded) ||
+		errors.Is(err, object.ErrOutOfGas) ||
+		errors.Is(err, object.ErrMemoryLimit)
+}
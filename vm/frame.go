@@ -1,6 +1,8 @@
 package vm
 
 import (
+	"sort"
+
 	"github.com/GhostNet-Dev/gscript/code"
 	"github.com/GhostNet-Dev/gscript/object"
 )
@@ -18,3 +20,38 @@ func NewFrame(cl *object.Closure, basePointer int) *Frame {
 func (f *Frame) Instructions() code.Instructions {
 	return f.cl.Fn.Instructions
 }
+
+// handler returns the innermost handler covering the instruction at ip.
+func (f *Frame) handler() (code.Handler, bool) {
+	for _, h := range f.cl.Fn.Handlers {
+		if h.Start <= f.ip && f.ip < h.End {
+			return h, true
+		}
+	}
+	return code.Handler{}, false
+}
+
+// Name returns the name of the function f runs, or "" for the main
+// program and anonymous functions.
+func (f *Frame) Name() string {
+	return f.cl.Fn.Name
+}
+
+// IP returns the position of the instruction f is at.
+func (f *Frame) IP() int {
+	return f.ip
+}
+
+// Line returns the source line of the instruction f is at, or 0 if it
+// is unknown.
+func (f *Frame) Line() int {
+	return code.LineAt(f.cl.Fn.Lines, f.ip)
+}
+
+// AtStatement reports whether f is at the first instruction of a
+// statement.
+func (f *Frame) AtStatement() bool {
+	lines := f.cl.Fn.Lines
+	i := sort.Search(len(lines), func(i int) bool { return lines[i].Pos >= f.ip })
+	return i < len(lines) && lines[i].Pos == f.ip
+}
@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"io"
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/compiler"
+	"github.com/GhostNet-Dev/gscript/lexer"
+	"github.com/GhostNet-Dev/gscript/parser"
+)
+
+func FuzzVM(f *testing.F) {
+	for _, seed := range []string{
+		"let x = 5; x + 10;",
+		"let f = fn(a, b) { if (a < b) { return a; } else { b } }; f(1, 2)",
+		`let h = {"a": [1, 2]}; h["a"][0]`,
+		"let n = 0; for (let i = 0; i < 3; i = i + 1) { if (i > 1) { n = n * 10; }; n = n + i; }; n",
+		"try { throw 1; } catch (e) { e } finally { 2 }",
+		"let f = fn() { let x = error(\"e\"); x?; 1 }; f()",
+		"let f = fn(n) { f(n + 1) }; f(0)",
+		`let s = "a"; for (;;) { s = s + s; }`,
+		"1 / 0",
+		"map([1, 2], fn(x) { x * 2 })",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		p := parser.NewParser(lexer.NewLexer(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			return
+		}
+		for _, optimize := range []bool{false, true} {
+			c := compiler.NewCompilerWithOptions(compiler.Options{Optimize: optimize})
+			if err := c.Compile(program); err != nil {
+				return
+			}
+			// The limits make every run end, whatever the input.
+			vm := NewVMWithOptions(c.Bytecode(), Options{
+				MaxFrames:   64,
+				GasLimit:    100000,
+				MemoryLimit: 1 << 20,
+				Stdout:      io.Discard,
+			})
+			_ = vm.Run()
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package vm
+
+import (
+	"github.com/GhostNet-Dev/gscript/code"
+	"github.com/GhostNet-Dev/gscript/object"
+)
+
+// GasSchedule is the deterministic cost model of a metered VM. Costs only
+// depend on the executed instructions and the sizes of the values they
+// build, so the same program uses the same gas on every run and platform.
+type GasSchedule struct {
+	// Opcodes is the base cost charged before each instruction. Unlisted
+	// opcodes cost DefaultOpcode.
+	Opcodes       map[code.Opcode]uint64
+	DefaultOpcode uint64
+	// Builtins is the cost of calling a builtin, keyed by its name in
+	// object.Builtins. Unlisted builtins cost DefaultBuiltin.
+	Builtins       map[string]uint64
+	DefaultBuiltin uint64
+	// PerElement lists the builtins that copy or walk their array
+	// argument; they are also charged Element per element of it.
+	PerElement map[string]bool
+	// Element is charged per element built by OpArray or OpTuple, per
+	// pair built by OpHash and per element a PerElement builtin handles.
+	Element uint64
+	// Byte is charged per byte of a string produced by concatenation.
+	Byte uint64
+}
+
+// DefaultGasSchedule returns a fresh copy of the default cost model.
+func DefaultGasSchedule() *GasSchedule {
+	return &GasSchedule{
+		Opcodes: map[code.Opcode]uint64{
+			code.OpConstant:       1,
+			code.OpAdd:            3,
+			code.OpPop:            1,
+			code.OpSub:            3,
+			code.OpMul:            5,
+			code.OpDiv:            5,
+			code.OpTrue:           1,
+			code.OpFalse:          1,
+			code.OpEqual:          3,
+			code.OpNotEqual:       3,
+			code.OpGreaterThan:    3,
+			code.OpLessThan:       3,
+			code.OpMinus:          3,
+			code.OpBang:           3,
+			code.OpJumpNotTruthy:  2,
+			code.OpJump:           2,
+			code.OpNull:           1,
+			code.OpGetGlobal:      2,
+			code.OpSetGlobal:      2,
+			code.OpArray:          5,
+			code.OpHash:           5,
+			code.OpTuple:          5,
+			code.OpIter:           5,
+			code.OpIterNext:       2,
+			code.OpIndex:          3,
+			code.OpCall:           10,
+			code.OpTailCall:       10,
+			code.OpReturnValue:    2,
+			code.OpReturn:         2,
+			code.OpGetLocal:       1,
+			code.OpSetLocal:       1,
+			code.OpGetBuiltin:     1,
+			code.OpClosure:        5,
+			code.OpGetFree:        1,
+			code.OpSetFree:        1,
+			code.OpAssignLocal:    1,
+			code.OpCaptureLocal:   1,
+			code.OpCaptureFree:    1,
+			code.OpCurrentClosure: 1,
+			code.OpThrow:          5,
+			code.OpPropagate:      2,
+			// The instruction after an OpWide prefix is charged as usual.
+			code.OpWide: 0,
+		},
+		DefaultOpcode: 5,
+		Builtins: map[string]uint64{
+			"len":    2,
+			"puts":   20,
+			"first":  2,
+			"last":   2,
+			"rest":   10,
+			"push":   10,
+			"int":    5,
+			"string": 5,
+			"map":    10,
+			"filter": 10,
+
+			"error":    5,
+			"is_error": 2,
+			"delete":   10,
+			"freeze":   10,
+			"range":    2,
+		},
+		DefaultBuiltin: 10,
+		PerElement: map[string]bool{
+			"rest":   true,
+			"push":   true,
+			"map":    true,
+			"filter": true,
+		},
+		Element: 1,
+		Byte:    1,
+	}
+}
+
+type gasMeter struct {
+	limit     uint64
+	used      uint64
+	exhausted bool
+
+	opcodes    [256]uint64
+	builtins   map[*object.Builtin]uint64
+	builtin    uint64
+	perElement map[*object.Builtin]bool
+	element    uint64
+	byte       uint64
+}
+
+func newGasMeter(limit uint64, schedule *GasSchedule) *gasMeter {
+	if schedule == nil {
+		schedule = DefaultGasSchedule()
+	}
+	m := &gasMeter{
+		limit:      limit,
+		builtins:   make(map[*object.Builtin]uint64),
+		builtin:    schedule.DefaultBuiltin,
+		perElement: make(map[*object.Builtin]bool),
+		element:    schedule.Element,
+		byte:       schedule.Byte,
+	}
+	for op := range m.opcodes {
+		m.opcodes[op] = schedule.DefaultOpcode
+	}
+	for op, cost := range schedule.Opcodes {
+		m.opcodes[op] = cost
+	}
+	for _, def := range object.Builtins {
+		if cost, ok := schedule.Builtins[def.Name]; ok {
+			m.builtins[def.Builtin] = cost
+		}
+		if schedule.PerElement[def.Name] {
+			m.perElement[def.Builtin] = true
+		}
+	}
+	return m
+}
+
+func (m *gasMeter) use(amount uint64) error {
+	if amount > m.limit-m.used {
+		m.used, m.exhausted = m.limit, true
+		return object.ErrOutOfGas
+	}
+	m.used += amount
+	return nil
+}
+
+func (m *gasMeter) useN(n int, cost uint64) error {
+	if n > 0 && cost > 0 && uint64(n) > (m.limit-m.used)/cost {
+		m.used, m.exhausted = m.limit, true
+		return object.ErrOutOfGas
+	}
+	return m.use(uint64(n) * cost)
+}
+
+func (m *gasMeter) useBuiltin(builtin *object.Builtin, args []object.Object) error {
+	cost, ok := m.builtins[builtin]
+	if !ok {
+		cost = m.builtin
+	}
+	if err := m.use(cost); err != nil {
+		return err
+	}
+	if !m.perElement[builtin] || len(args) == 0 {
+		return nil
+	}
+	if arr, ok := args[0].(*object.Array); ok {
+		return m.useN(len(arr.Elements), m.element)
+	}
+	return nil
+}
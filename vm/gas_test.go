@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/code"
+	"github.com/GhostNet-Dev/gscript/object"
+)
+
+func TestGasUsed(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint64
+	}{
+		{"1 + 2", 6},
+		{"[1, 2, 3]", 12},
+		{`"ab" + "cd"`, 10},
+		{"{1: 2}", 9},
+		{"len([1])", 21},
+		{"push([1, 2], 3)", 34},
+		{"rest([1, 2, 3])", 36},
+	}
+	for _, tt := range tests {
+		for run := 0; run < 2; run++ {
+			vm := NewVMWithOptions(compileProgram(t, tt.input), Options{GasLimit: 1000})
+			if err := vm.Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			if vm.GasUsed() != tt.expected {
+				t.Errorf("%q: wrong gas used. want=%d, got=%d", tt.input, tt.expected, vm.GasUsed())
+			}
+		}
+	}
+}
+
+func TestOutOfGas(t *testing.T) {
+	tests := []struct {
+		input string
+		limit uint64
+	}{
+		{"for (;true;) {}", 10000},
+		{"let f = fn(x) { if (x == 0) { 0 } else { f(x - 1) } }; f(50)", 100},
+		{"map([1, 2, 3], fn(x) { for (;true;) {} })", 10000},
+		{"[1, 2, 3, 4, 5, 6, 7, 8, 9, 10]", 15},
+		{`let s = "aaaaaaaaaa"; s + s + s + s`, 30},
+		{"1 + 2", 5},
+	}
+	for _, tt := range tests {
+		vm := NewVMWithOptions(compileProgram(t, tt.input), Options{GasLimit: tt.limit})
+		if err := vm.Run(); !errors.Is(err, object.ErrOutOfGas) {
+			t.Fatalf("%q: wrong VM error: want=%q, got=%v", tt.input, object.ErrOutOfGas, err)
+		}
+		if vm.GasUsed() != tt.limit {
+			t.Errorf("%q: wrong gas used. want=%d, got=%d", tt.input, tt.limit, vm.GasUsed())
+		}
+	}
+}
+
+func TestCustomGasSchedule(t *testing.T) {
+	schedule := DefaultGasSchedule()
+	schedule.Opcodes[code.OpAdd] = 100
+	schedule.Builtins["len"] = 50
+
+	vm := NewVMWithOptions(compileProgram(t, `1 + len("")`), Options{GasLimit: 1000, Gas: schedule})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	// OpConstant, OpGetBuiltin, OpConstant, OpCall, len, OpAdd, OpPop
+	if expected := uint64(1 + 1 + 1 + 10 + 50 + 100 + 1); vm.GasUsed() != expected {
+		t.Errorf("wrong gas used. want=%d, got=%d", expected, vm.GasUsed())
+	}
+
+	delete(schedule.Opcodes, code.OpAdd)
+	schedule.DefaultOpcode = 7
+	vm = NewVMWithOptions(compileProgram(t, "1 + 2"), Options{GasLimit: 1000, Gas: schedule})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if expected := uint64(1 + 1 + 7 + 1); vm.GasUsed() != expected {
+		t.Errorf("unlisted opcode: wrong gas used. want=%d, got=%d", expected, vm.GasUsed())
+	}
+
+	unmetered := NewVM(compileProgram(t, "for (let i = 0; i < 100; i = i + 1) {}"))
+	if err := unmetered.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if unmetered.GasUsed() != 0 {
+		t.Errorf("unmetered VM reported gas. got=%d", unmetered.GasUsed())
+	}
+}
+
+func TestDefaultGasScheduleListsEveryOpcode(t *testing.T) {
+	schedule := DefaultGasSchedule()
+	for op := 0; op < 256; op++ {
+		def, err := code.Lookup(byte(op))
+		if err != nil {
+			continue
+		}
+		if _, ok := schedule.Opcodes[code.Opcode(op)]; !ok {
+			t.Errorf("%s has no cost in the default gas schedule", def.Name)
+		}
+	}
+}
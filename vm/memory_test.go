@@ -0,0 +1,59 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/GhostNet-Dev/gscript/object"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []string{
+		`let a = []; for (;true;) { a = push(a, 1) }`,
+		`let s = "a"; for (;true;) { s = s + s }`,
+		`let f = fn(h) { {1: h} }; let h = {}; for (;true;) { h = f(h) }`,
+		`let a = [1, 2, 3]; for (;true;) { a = map(a, fn(x) { [x] }) }`,
+		`let f = fn(x) { fn() { x } }; for (;true;) { f(1) }`,
+	}
+	for _, input := range tests {
+		vm := NewVMWithOptions(compileProgram(t, input), Options{MemoryLimit: 4096})
+		if err := vm.Run(); !errors.Is(err, object.ErrMemoryLimit) {
+			t.Fatalf("%q: wrong VM error: want=%q, got=%v", input, object.ErrMemoryLimit, err)
+		}
+		if vm.MemoryUsed() > 4096 {
+			t.Errorf("%q: memory used above limit: %d", input, vm.MemoryUsed())
+		}
+	}
+
+	vm := NewVMWithOptions(compileProgram(t, `let a = [1, 2]; "ab" + "c"`), Options{MemoryLimit: 4096})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if expected := uint64(16 + 2*16 + 16 + 3); vm.MemoryUsed() != expected {
+		t.Errorf("wrong memory used. want=%d, got=%d", expected, vm.MemoryUsed())
+	}
+}
+
+func TestVMOptions(t *testing.T) {
+	vm := NewVMWithOptions(compileProgram(t, `[1, 2, 3, 4, 5]`), Options{StackSize: 4})
+	if err := vm.Run(); err == nil || err.Error() != "stack overflow" {
+		t.Fatalf("wrong VM error: want=%q, got=%v", "stack overflow", err)
+	}
+
+	vm = NewVMWithOptions(compileProgram(t, `let a = 1; let b = 2; let c = 3;`), Options{GlobalsSize: 2})
+	if err := vm.Run(); err == nil || err.Error() != "too many globals: limit is 2" {
+		t.Fatalf("wrong VM error: want=%q, got=%v", "too many globals: limit is 2", err)
+	}
+
+	vm = NewVM(compileProgram(t, `let a = 1; let b = 2; a + b`))
+	if len(vm.globals) != 0 {
+		t.Fatalf("globals allocated eagerly: %d slots", len(vm.globals))
+	}
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 3, vm.LastPoppedStackElem())
+	if len(vm.globals) != 2 {
+		t.Errorf("wrong number of global slots. want=2, got=%d", len(vm.globals))
+	}
+}
@@ -1,13 +1,18 @@
 package vm
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/GhostNet-Dev/gscript/code"
 	"github.com/GhostNet-Dev/gscript/compiler"
 	"github.com/GhostNet-Dev/gscript/object"
 )
 
+// Default limits of a VM; see Options. A stack without a limit starts
+// with StackSize slots.
 const (
 	GlobalsSize = 65536
 	StackSize   = 2048
@@ -25,202 +30,507 @@ type VM struct {
 
 	stack []object.Object
 	sp    int
+	// stackLimit caps the stack at that many slots; 0 lets it grow.
+	stackLimit int
 
-	globals []object.Object
+	globals     []object.Object
+	globalsSize int
 
 	frames      []*Frame
 	framesIndex int
+
+	ctx context.Context
+	gas *gasMeter
+	mem *object.MemoryMeter
+
+	debugger    Debugger
+	globalNames []string
+	stdout      io.Writer
 }
 
-func NewVM(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+// Options configures a VM created by NewVMWithOptions. Zero values select
+// the defaults.
+type Options struct {
+	// StackSize is the maximum number of stack slots, MaxFrames the
+	// maximum call depth and GlobalsSize the maximum number of global
+	// bindings. Without a StackSize the stack grows as the program needs,
+	// so only MaxFrames bounds recursion.
+	StackSize   int
+	MaxFrames   int
+	GlobalsSize int
+
+	// GasLimit enables metering when non-zero. The run fails with
+	// object.ErrOutOfGas once it would use more gas than this.
+	GasLimit uint64
+	// Gas is the cost model of a metered run; nil uses
+	// DefaultGasSchedule.
+	Gas *GasSchedule
+
+	// MemoryLimit caps the approximate bytes allocated for arrays,
+	// hashes, strings and closures when non-zero. The run fails with
+	// object.ErrMemoryLimit once it is reached.
+	MemoryLimit uint64
+
+	// Debugger, if set, is called before each instruction.
+	Debugger Debugger
+	// Stdout receives what puts prints; nil means os.Stdout.
+	Stdout io.Writer
+}
+
+func NewVMWithOptions(bytecode *compiler.Bytecode, opts Options) *VM {
+	stackSize := opts.StackSize
+	if stackSize <= 0 {
+		stackSize = StackSize
+	}
+	if opts.MaxFrames <= 0 {
+		opts.MaxFrames = MaxFrames
+	}
+	if opts.GlobalsSize <= 0 {
+		opts.GlobalsSize = GlobalsSize
+	}
+
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		NumLocals:    bytecode.NumLocals,
+		Handlers:     bytecode.Handlers,
+		Lines:        bytecode.Lines,
+		Locals:       bytecode.Locals,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
+	frames := make([]*Frame, opts.MaxFrames)
 	frames[0] = mainFrame
 
-	return &VM{
+	vm := &VM{
 		constants:   bytecode.Constants,
-		stack:       make([]object.Object, StackSize),
-		sp:          0,
-		globals:     make([]object.Object, GlobalsSize),
+		stack:       make([]object.Object, stackSize),
+		stackLimit:  opts.StackSize,
+		sp:          mainFn.NumLocals,
+		globalsSize: opts.GlobalsSize,
 		frames:      frames,
 		framesIndex: 1,
+		ctx:         context.Background(),
+		debugger:    opts.Debugger,
+		globalNames: bytecode.Globals,
+		stdout:      opts.Stdout,
+	}
+	if opts.GasLimit > 0 {
+		vm.gas = newGasMeter(opts.GasLimit, opts.Gas)
 	}
+	if opts.MemoryLimit > 0 {
+		vm.mem = object.NewMemoryMeter(opts.MemoryLimit)
+	}
+	return vm
+}
+
+func NewVM(bytecode *compiler.Bytecode) *VM {
+	return NewVMWithOptions(bytecode, Options{})
 }
 
+// NewVMWithGlobalsStore runs bytecode against an existing globals store,
+// so bindings survive across VMs. The store is used as is and grows if the
+// program defines globals beyond its length; pass GlobalsStore to the next
+// VM to keep those.
 func NewVMWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	vm := NewVM(bytecode)
 	vm.globals = s
 	return vm
 }
 
+// GlobalsStore returns the globals store, which is a new slice if the
+// program outgrew the one the VM started with.
+func (vm *VM) GlobalsStore() []object.Object {
+	return vm.globals
+}
+
 func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
+// MemoryUsed reports the approximate bytes allocated so far by a VM with a
+// memory limit.
+func (vm *VM) MemoryUsed() uint64 {
+	return vm.mem.Used()
+}
+
+// Allocate makes the VM an object.Allocator, so builtins building values
+// are held to the memory limit.
+func (vm *VM) Allocate(obj object.Object) error {
+	return vm.mem.Allocate(obj)
+}
+
+// Stdout makes the VM an object.Printer, so puts writes to Options.Stdout.
+func (vm *VM) Stdout() io.Writer {
+	if vm.stdout == nil {
+		return os.Stdout
+	}
+	return vm.stdout
+}
+
+// GasUsed reports the gas used so far by a metered VM.
+func (vm *VM) GasUsed() uint64 {
+	if vm.gas == nil {
+		return 0
+	}
+	return vm.gas.used
+}
+
 func (vm *VM) Run() error {
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	return vm.run(0)
+}
+
+// RunContext runs the program like Run, but checks ctx on every backward
+// jump and function call. When ctx is done it stops with
+// object.ErrCanceled or object.ErrDeadlineExceeded.
+func (vm *VM) RunContext(ctx context.Context) error {
+	vm.ctx = ctx
+	defer func() { vm.ctx = context.Background() }()
+	return vm.run(0)
+}
+
+// Call synchronously invokes fn with args on top of the current stack and
+// returns its result. It is re-entrant: the VM is handed to builtins as
+// their context, so a builtin may call back into the closures it receives.
+// Nested frames share the VM's stack and frame limits, and on error the
+// stack and frames are restored to their state before the call.
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	sp, framesIndex := vm.sp, vm.framesIndex
+	restore := func(err error) (object.Object, error) {
+		vm.sp, vm.framesIndex = sp, framesIndex
+		return nil, err
+	}
+
+	if err := vm.push(fn); err != nil {
+		return restore(err)
+	}
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			return restore(err)
+		}
+	}
+	if err := vm.executeCall(len(args)); err != nil {
+		return restore(vm.unwind(err, framesIndex))
+	}
+	if err := vm.run(framesIndex); err != nil {
+		return restore(err)
+	}
+	return vm.pop(), nil
+}
+
+// run executes instructions until the frame stack unwinds to depth frames
+// or the outermost frame runs out of instructions.
+func (vm *VM) run(depth int) error {
+	if !vm.growStack(vm.sp) {
+		return fmt.Errorf("stack overflow")
+	}
+	for vm.framesIndex > depth && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
 		ip := vm.currentFrame().ip
 		ins := vm.currentFrame().Instructions()
 		op := code.Opcode(ins[ip])
 
-		switch op {
-		case code.OpConstant:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			err := vm.push(vm.constants[constIndex])
-			if err != nil {
-				return err
-			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			err := vm.executeBinaryOperation(op)
-			if err != nil {
-				return err
-			}
-		case code.OpTrue:
-			err := vm.push(True)
-			if err != nil {
-				return err
-			}
-		case code.OpFalse:
-			err := vm.push(False)
-			if err != nil {
-				return err
-			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
-			err := vm.executeComparison(op)
-			if err != nil {
-				return err
-			}
-		case code.OpBang:
-			if err := vm.executeBangOperator(); err != nil {
-				return err
-			}
-		case code.OpMinus:
-			if err := vm.executeMinusOperator(); err != nil {
-				return err
-			}
-		case code.OpNull:
-			if err := vm.push(Null); err != nil {
+		if vm.debugger != nil {
+			if err := vm.debugger.Before(vm); err != nil {
 				return err
 			}
-		case code.OpJump:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip = pos - 1
-		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
-			condition := vm.pop()
-			if !isTruthy(condition) {
-				vm.currentFrame().ip = pos - 1
-			}
-		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			vm.globals[globalIndex] = vm.pop()
-		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
-			if err := vm.push(vm.globals[globalIndex]); err != nil {
-				return err
-			}
-		case code.OpSetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			frame := vm.currentFrame()
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
-		case code.OpGetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			frame := vm.currentFrame()
-			if err := vm.push(vm.stack[frame.basePointer+int(localIndex)]); err != nil {
+		}
+		if err := vm.execute(op, ins, ip); err != nil {
+			if err = vm.unwind(err, depth); err != nil {
 				return err
 			}
-		case code.OpGetFree:
-			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+		}
+	}
+	return nil
+}
 
-			currentClosure := vm.currentFrame().cl
-			if err := vm.push(currentClosure.Free[freeIndex]); err != nil {
-				return err
-			}
-		case code.OpArray:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
-			array := vm.buildArray(vm.sp-numElements, vm.sp)
-			vm.sp = vm.sp - numElements
-			if err := vm.push(array); err != nil {
-				return err
-			}
-		case code.OpHash:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
-			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
-			if err != nil {
-				return err
-			}
-			vm.sp = vm.sp - numElements
-			if err = vm.push(hash); err != nil {
-				return err
-			}
-		case code.OpIndex:
-			index := vm.pop()
-			left := vm.pop()
-			if err := vm.executeIndexExpression(left, index); err != nil {
+func (vm *VM) execute(op code.Opcode, ins code.Instructions, ip int) error {
+	if vm.gas != nil {
+		if err := vm.gas.use(vm.gas.opcodes[op]); err != nil {
+			return err
+		}
+	}
+
+	switch op {
+	case code.OpConstant:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+		err := vm.push(vm.constants[constIndex])
+		if err != nil {
+			return err
+		}
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		err := vm.executeBinaryOperation(op)
+		if err != nil {
+			return err
+		}
+	case code.OpTrue:
+		err := vm.push(True)
+		if err != nil {
+			return err
+		}
+	case code.OpFalse:
+		err := vm.push(False)
+		if err != nil {
+			return err
+		}
+	case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+		err := vm.executeComparison(op)
+		if err != nil {
+			return err
+		}
+	case code.OpBang:
+		if err := vm.executeBangOperator(); err != nil {
+			return err
+		}
+	case code.OpMinus:
+		if err := vm.executeMinusOperator(); err != nil {
+			return err
+		}
+	case code.OpNull:
+		if err := vm.push(Null); err != nil {
+			return err
+		}
+	case code.OpJump:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip = pos - 1
+		if pos <= ip {
+			if err := object.ContextError(vm.ctx); err != nil {
 				return err
 			}
-		case code.OpClosure:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			numFree := code.ReadUint8(ins[ip+3:])
-			vm.currentFrame().ip += 3
-			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+		}
+	case code.OpJumpNotTruthy:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		condition := vm.pop()
+		if !isTruthy(condition) {
+			vm.currentFrame().ip = pos - 1
+		}
+	case code.OpSetGlobal:
+		globalIndex := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		if err := vm.setGlobal(globalIndex, vm.pop()); err != nil {
+			return err
+		}
+	case code.OpGetGlobal:
+		globalIndex := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		var global object.Object = Null
+		if globalIndex < len(vm.globals) && vm.globals[globalIndex] != nil {
+			global = vm.globals[globalIndex]
+		}
+		if err := vm.push(global); err != nil {
+			return err
+		}
+	case code.OpSetLocal, code.OpGetLocal, code.OpGetFree, code.OpCall, code.OpTailCall, code.OpGetBuiltin,
+		code.OpAssignLocal, code.OpSetFree, code.OpCaptureLocal, code.OpCaptureFree:
+		operand := int(code.ReadUint8(ins[ip+1:]))
+		vm.currentFrame().ip += 1
+		return vm.executeByteOperand(op, operand)
+	case code.OpArray:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		if vm.gas != nil {
+			if err := vm.gas.useN(numElements, vm.gas.element); err != nil {
 				return err
 			}
-		case code.OpCurrentClosure:
-			currentClosure := vm.currentFrame().cl
-			if err := vm.push(currentClosure); err != nil {
+		}
+		array := vm.buildArray(vm.sp-numElements, vm.sp)
+		if err := vm.mem.Allocate(array); err != nil {
+			return err
+		}
+		vm.sp = vm.sp - numElements
+		if err := vm.push(array); err != nil {
+			return err
+		}
+	case code.OpTuple:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		if vm.gas != nil {
+			if err := vm.gas.useN(numElements, vm.gas.element); err != nil {
 				return err
 			}
-		case code.OpCall:
-			numArgs := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			if err := vm.executeCall(int(numArgs)); err != nil {
+		}
+		tuple := vm.buildTuple(vm.sp-numElements, vm.sp)
+		if err := vm.mem.Allocate(tuple); err != nil {
+			return err
+		}
+		vm.sp = vm.sp - numElements
+		if err := vm.push(tuple); err != nil {
+			return err
+		}
+	case code.OpHash:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		if vm.gas != nil {
+			if err := vm.gas.useN(numElements/2, vm.gas.element); err != nil {
 				return err
 			}
+		}
+		hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+		if err != nil {
+			return err
+		}
+		if err := vm.mem.Allocate(hash); err != nil {
+			return err
+		}
+		vm.sp = vm.sp - numElements
+		if err = vm.push(hash); err != nil {
+			return err
+		}
+	case code.OpIndex:
+		index := vm.pop()
+		left := vm.pop()
+		if err := vm.executeIndexExpression(left, index); err != nil {
+			return err
+		}
+	case code.OpClosure:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		numFree := code.ReadUint8(ins[ip+3:])
+		vm.currentFrame().ip += 3
+		if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+			return err
+		}
+	case code.OpWide:
+		return vm.executeWide(ins, ip+1)
+	case code.OpCurrentClosure:
+		currentClosure := vm.currentFrame().cl
+		if err := vm.push(currentClosure); err != nil {
+			return err
+		}
+	case code.OpReturnValue:
+		returnValue := vm.pop()
+		if err := vm.returnFrom(returnValue); err != nil {
+			return err
+		}
+	case code.OpReturn:
+		if err := vm.returnFrom(Null); err != nil {
+			return err
+		}
+	case code.OpPop:
+		vm.pop()
+	case code.OpThrow:
+		return object.Throw(vm.pop())
+	case code.OpPropagate:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		if _, ok := vm.stack[vm.sp-1].(*object.ErrorValue); !ok {
+			vm.currentFrame().ip = pos - 1
+		}
+	case code.OpIter:
+		iter, err := object.Iterate(vm.pop())
+		if err != nil {
+			return err
+		}
+		if err := vm.mem.Allocate(iter); err != nil {
+			return err
+		}
+		return vm.push(iter)
+	case code.OpIterNext:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		vars := int(code.ReadUint8(ins[ip+3:]))
+		vm.currentFrame().ip += 3
+		return vm.executeIterNext(pos, vars)
+	}
+	return nil
+}
 
-		case code.OpReturnValue:
-			returnValue := vm.pop()
-
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-
-			if err := vm.push(returnValue); err != nil {
-				return err
-			}
-		case code.OpReturn:
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
+// executeByteOperand executes an instruction whose only operand is 1 byte
+// wide, or 2 bytes after an OpWide prefix.
+func (vm *VM) executeByteOperand(op code.Opcode, operand int) error {
+	frame := vm.currentFrame()
+	switch op {
+	case code.OpSetLocal:
+		vm.stack[frame.basePointer+operand] = vm.pop()
+	case code.OpAssignLocal:
+		slot := &vm.stack[frame.basePointer+operand]
+		if cell, ok := (*slot).(*object.Identifier); ok {
+			cell.Value = vm.pop()
+		} else {
+			*slot = vm.pop()
+		}
+	case code.OpGetLocal:
+		return vm.push(deref(vm.stack[frame.basePointer+operand]))
+	case code.OpCaptureLocal:
+		slot := &vm.stack[frame.basePointer+operand]
+		if _, ok := (*slot).(*object.Identifier); !ok {
+			*slot = &object.Identifier{Value: *slot}
+		}
+		return vm.push(*slot)
+	case code.OpGetFree:
+		return vm.push(deref(frame.cl.Free[operand]))
+	case code.OpSetFree:
+		cell, ok := frame.cl.Free[operand].(*object.Identifier)
+		if !ok {
+			return fmt.Errorf("free variable %d is not assignable", operand)
+		}
+		cell.Value = vm.pop()
+	case code.OpCaptureFree:
+		return vm.push(frame.cl.Free[operand])
+	case code.OpCall:
+		if err := object.ContextError(vm.ctx); err != nil {
+			return err
+		}
+		return vm.executeCall(operand)
+	case code.OpTailCall:
+		if err := object.ContextError(vm.ctx); err != nil {
+			return err
+		}
+		return vm.executeTailCall(operand)
+	case code.OpGetBuiltin:
+		return vm.push(object.Builtins[operand].Builtin)
+	}
+	return nil
+}
 
-			if err := vm.push(Null); err != nil {
-				return err
-			}
-		case code.OpGetBuiltin:
-			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+// deref returns the value of a variable, which a closure capturing it
+// moved into a cell shared with the function defining it.
+func deref(o object.Object) object.Object {
+	if cell, ok := o.(*object.Identifier); ok {
+		return cell.Value
+	}
+	return o
+}
 
-			definition := object.Builtins[builtinIndex]
-			if err := vm.push(definition.Builtin); err != nil {
-				return err
-			}
-		case code.OpPop:
-			vm.pop()
+// executeWide executes the instruction at ip, which follows an OpWide
+// prefix and so has 2-byte operands where it normally has 1-byte ones.
+func (vm *VM) executeWide(ins code.Instructions, ip int) error {
+	op := code.Opcode(ins[ip])
+	if vm.gas != nil {
+		if err := vm.gas.use(vm.gas.opcodes[op]); err != nil {
+			return err
 		}
 	}
-	return nil
+	switch op {
+	case code.OpSetLocal, code.OpGetLocal, code.OpGetFree, code.OpCall, code.OpTailCall, code.OpGetBuiltin,
+		code.OpAssignLocal, code.OpSetFree, code.OpCaptureLocal, code.OpCaptureFree:
+		operand := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 3
+		return vm.executeByteOperand(op, operand)
+	case code.OpClosure:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		numFree := code.ReadUint16(ins[ip+3:])
+		vm.currentFrame().ip += 5
+		return vm.pushClosure(int(constIndex), int(numFree))
+	}
+	return fmt.Errorf("opcode %d cannot follow OpWide", op)
+}
+
+// returnFrom returns value from the current frame. Returning from the main
+// frame ends the program with value as its result.
+func (vm *VM) returnFrom(value object.Object) error {
+	if vm.framesIndex == 1 {
+		frame := vm.currentFrame()
+		frame.ip = len(frame.Instructions()) - 1
+		vm.sp = 0
+		vm.stack[0] = value
+		return nil
+	}
+	frame := vm.popFrame()
+	vm.sp = frame.basePointer - 1
+	return vm.push(value)
 }
+
 func (vm *VM) pushClosure(constIndex, numFree int) error {
 	constant := vm.constants[constIndex]
 	function, ok := constant.(*object.CompiledFunction)
@@ -234,6 +544,9 @@ func (vm *VM) pushClosure(constIndex, numFree int) error {
 	vm.sp = vm.sp - numFree
 
 	closure := &object.Closure{Fn: function, Free: free}
+	if err := vm.mem.Allocate(closure); err != nil {
+		return err
+	}
 	return vm.push(closure)
 }
 
@@ -245,7 +558,7 @@ func (vm *VM) executeCall(numArg int) error {
 	case *object.Builtin:
 		return vm.callBuiltin(callee, numArg)
 	default:
-		return fmt.Errorf("calling non-function and non-built-in")
+		return fmt.Errorf("not a function: %s", callee.Type())
 	}
 }
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
@@ -254,36 +567,97 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 			cl.Fn.NumParameters, numArgs)
 	}
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	// A call the stack has no room for is as deep as the VM can go.
+	if !vm.growStack(frame.basePointer + cl.Fn.NumLocals) {
+		return fmt.Errorf("maximum call depth exceeded")
+	}
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 
 	return nil
 }
+
+// executeTailCall calls the function below the numArgs arguments on top
+// of the stack and returns its result from the current frame. A closure
+// takes over the current frame instead of pushing one, so tail recursion
+// runs in constant space; the frame is then missing from error traces.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		if err := vm.executeCall(numArgs); err != nil {
+			return err
+		}
+		return vm.returnFrom(vm.pop())
+	}
+	if vm.framesIndex == 1 {
+		return fmt.Errorf("tail call outside a function")
+	}
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+	frame := vm.currentFrame()
+	if !vm.growStack(frame.basePointer + cl.Fn.NumLocals) {
+		return fmt.Errorf("stack overflow")
+	}
+	// Move the callee and its arguments over those of the current call.
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.cl = cl
+	frame.ip = -1
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	return nil
+}
+
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(nil, args...)
+	if vm.gas != nil {
+		if err := vm.gas.useBuiltin(builtin, args); err != nil {
+			return err
+		}
+	}
+	result := builtin.Fn(vm, args...)
+	// A callback may have been cut short by the context or the gas limit;
+	// the builtin only sees an error, so abort the run here as well.
+	if err := object.ContextError(vm.ctx); err != nil {
+		return err
+	}
+	if vm.gas != nil && vm.gas.exhausted {
+		return object.ErrOutOfGas
+	}
+	if vm.mem.Exceeded() {
+		return object.ErrMemoryLimit
+	}
+	if errObj, ok := result.(*object.Error); ok {
+		if errObj.Kind == "" {
+			errObj.Kind = object.BuiltinError
+		}
+		return errObj
+	}
 	vm.sp = vm.sp - numArgs - 1
-	if result != nil {
-		vm.push(result)
-	} else {
-		vm.push(Null)
+	switch result := result.(type) {
+	case nil:
+		return vm.push(Null)
+	case *object.Boolean:
+		return vm.push(nativeBoolToBooleanObject(result.Value))
+	default:
+		return vm.push(result)
 	}
-	return nil
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash((endIndex - startIndex) / 2)
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
-		pair := object.HashPair{Key: key, Value: value}
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		hashKey, err := object.KeyOf(key)
+		if err != nil {
+			return nil, err
 		}
-		hashedPairs[hashKey.HashKey()] = pair
+		hash.Set(hashKey, value)
 	}
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return hash, nil
 }
 
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
@@ -294,6 +668,12 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 	return &object.Array{Elements: elements}
 }
 
+func (vm *VM) buildTuple(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+	copy(elements, vm.stack[startIndex:endIndex])
+	return &object.Tuple{Elements: elements}
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj := obj.(type) {
 	case *object.Boolean:
@@ -309,8 +689,15 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.TUPLE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeTupleIndex(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
+	case left.Type() == object.ERROR_VALUE_OBJ && index.Type() == object.STRING_OBJ:
+		if field := left.(*object.ErrorValue).Field(index.(*object.String).Value); field != nil {
+			return vm.push(field)
+		}
+		return vm.push(Null)
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
@@ -326,23 +713,58 @@ func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	return vm.push(arrayObject.Elements[i])
 }
 
+// executeIterNext pushes the next element of the loop on top of the
+// stack, or pops the loop and jumps to pos once it has ended.
+func (vm *VM) executeIterNext(pos, vars int) error {
+	iter := vm.stack[vm.sp-1].(*object.Iter)
+	key, value, ok := iter.NextFor(vars)
+	if !ok {
+		vm.pop()
+		vm.currentFrame().ip = pos - 1
+		return nil
+	}
+	if vars == 2 {
+		if err := vm.push(orNull(key)); err != nil {
+			return err
+		}
+	}
+	return vm.push(orNull(value))
+}
+
+// orNull returns obj, or Null in place of nil.
+func orNull(obj object.Object) object.Object {
+	if obj == nil {
+		return Null
+	}
+	return obj
+}
+
+func (vm *VM) executeTupleIndex(tuple, index object.Object) error {
+	tupleObject := tuple.(*object.Tuple)
+	i := index.(*object.Integer).Value
+	if i < 0 || i >= int64(len(tupleObject.Elements)) {
+		return vm.push(Null)
+	}
+	return vm.push(tupleObject.Elements[i])
+}
+
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	key, err := object.KeyOf(index)
+	if err != nil {
+		return err
 	}
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return vm.push(Null)
 	}
-	return vm.push(pair.Value)
+	return vm.push(value)
 }
 
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 	if operand.Type() != object.INTEGER_OBJ {
-		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+		return fmt.Errorf("unknown operator: -%s", operand.Type())
 	}
 	value := operand.(*object.Integer).Value
 	return vm.push(&object.Integer{Value: -value})
@@ -368,13 +790,20 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
 	}
-	switch op {
-	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
-	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
+	switch {
+	case op == code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
+	case op == code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		leftValue := left.(*object.String).Value
+		rightValue := right.(*object.String).Value
+		if op == code.OpLessThan {
+			return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+		}
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
 	default:
-		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+		return operatorError(op, left, right)
 	}
 }
 
@@ -389,8 +818,10 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
 	default:
-		return fmt.Errorf("unknown operator: %d", op)
+		return operatorError(op, left, right)
 	}
 }
 
@@ -413,18 +844,48 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
-		return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+		return operatorError(op, left, right)
+	}
+}
+
+// infixOperators are the operators binary opcodes are compiled from.
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+// operatorError reports a binary operator its operands do not support, in
+// the evaluator's words.
+func operatorError(op code.Opcode, left, right object.Object) error {
+	if left.Type() != right.Type() {
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), infixOperators[op], right.Type())
 	}
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), infixOperators[op], right.Type())
 }
 
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
 	if op != code.OpAdd {
-		return fmt.Errorf("unknown string operator: %d", op)
+		return operatorError(op, left, right)
 	}
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
+	if vm.gas != nil {
+		if err := vm.gas.useN(len(leftValue)+len(rightValue), vm.gas.byte); err != nil {
+			return err
+		}
+	}
 
-	return vm.push(&object.String{Value: leftValue + rightValue})
+	str := &object.String{Value: leftValue + rightValue}
+	if err := vm.mem.Allocate(str); err != nil {
+		return err
+	}
+	return vm.push(str)
 }
 
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
@@ -439,9 +900,12 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
 	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+		return operatorError(op, left, right)
 	}
 	return vm.push(&object.Integer{Value: result})
 }
@@ -450,9 +914,13 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= len(vm.frames) {
+		return fmt.Errorf("maximum call depth exceeded")
+	}
 	vm.frames[vm.framesIndex] = f
 	vm.framesIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -460,8 +928,51 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
+func (vm *VM) setGlobal(index int, o object.Object) error {
+	if index >= len(vm.globals) {
+		if index >= vm.globalsSize {
+			return fmt.Errorf("too many globals: limit is %d", vm.globalsSize)
+		}
+		size := 2 * len(vm.globals)
+		if size <= index {
+			size = index + 1
+		}
+		if size > vm.globalsSize {
+			size = vm.globalsSize
+		}
+		globals := make([]object.Object, size)
+		copy(globals, vm.globals)
+		vm.globals = globals
+	}
+	vm.globals[index] = o
+	return nil
+}
+
+// growStack makes room for n stack slots, unless that is past the limit.
+// Frames and the operands of their instructions are bounded by the
+// compiler's operand limits, so MaxFrames also bounds a stack without one.
+func (vm *VM) growStack(n int) bool {
+	if n <= len(vm.stack) {
+		return true
+	}
+	if vm.stackLimit > 0 && n > vm.stackLimit {
+		return false
+	}
+	size := 2 * len(vm.stack)
+	if size < n {
+		size = n
+	}
+	if vm.stackLimit > 0 && size > vm.stackLimit {
+		size = vm.stackLimit
+	}
+	stack := make([]object.Object, size)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return true
+}
+
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
+	if !vm.growStack(vm.sp + 1) {
 		return fmt.Errorf("stack overflow")
 	}
 	vm.stack[vm.sp] = o
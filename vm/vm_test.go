@@ -1,8 +1,12 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/GhostNet-Dev/gscript/ast"
 	"github.com/GhostNet-Dev/gscript/compiler"
@@ -37,6 +41,61 @@ func TestRecursiveFibonacci(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{"let count = fn(n, acc) { if (n == 0) { return acc; }; return count(n - 1, acc + 1); }; count(100000, 0)", 100000},
+		{`let even = fn(n, odd) { if (n == 0) { return true; }; return odd(n - 1, even); };
+		let odd = fn(n, even) { if (n == 0) { return false; }; return even(n - 1, odd); };
+		even(5001, odd)`, false},
+		{"let sum = fn(a, acc) { if (len(a) == 0) { return acc; }; return sum(rest(a), acc + first(a)); }; sum([1, 2, 3, 4], 0)", 10},
+		{"let f = fn(a) { return len(a); }; f([1, 2]) + 1", 3},
+		{"let k = 5; let f = fn(x) { return fn(y) { x + y + k }(1); }; f(2)", 8},
+		{"let f = fn(a, b, c) { a + b + c }; let g = fn(x) { let y = 10; return f(x, y, 100); }; g(1)", 111},
+		{"let f = fn(a) { a }; let g = fn() { return f(1, 2); }; g()",
+			&object.Error{Message: "wrong number of arguments: want=1, got=2"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestMaximumCallDepth(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { return 0; }; 1 + f(n - 1) };"
+	vm := NewVMWithOptions(compileProgram(t, input+"f(20)"), Options{MaxFrames: 16})
+	if err := vm.Run(); err == nil || err.Error() != "maximum call depth exceeded" {
+		t.Fatalf("wrong VM error: want=%q, got=%v", "maximum call depth exceeded", err)
+	}
+
+	vm = NewVMWithOptions(compileProgram(t, input+`let r = ""; try { f(20); } catch (e) { r = e["message"]; }; r`), Options{MaxFrames: 16})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if err := testStringObject("maximum call depth exceeded", vm.LastPoppedStackElem()); err != nil {
+		t.Error(err)
+	}
+
+	// Tail calls do not take frames.
+	input = "let f = fn(n) { if (n == 0) { return 0; }; return f(n - 1); };"
+	vm = NewVMWithOptions(compileProgram(t, input+"f(20)"), Options{MaxFrames: 16})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	// Frames with several arguments and locals outgrow the default stack
+	// long before MaxFrames; the stack grows and the depth limit still
+	// applies.
+	input = "let f = fn(n, a, b) { let c = a + b; let d = c * 2; if (n == 0) { return d; }; 1 + f(n - 1, b, c) };"
+	vm = NewVM(compileProgram(t, input+"f(1000, 0, 0)"))
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if err := testIntegerObject(1000, vm.LastPoppedStackElem()); err != nil {
+		t.Error(err)
+	}
+	vm = NewVM(compileProgram(t, input+"f(5000, 0, 0)"))
+	if err := vm.Run(); err == nil || err.Error() != "maximum call depth exceeded" {
+		t.Fatalf("wrong VM error: want=%q, got=%v", "maximum call depth exceeded", err)
+	}
+}
+
 func TestRecursiveFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -148,6 +207,20 @@ func TestClosures(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestClosureAssignment(t *testing.T) {
+	// A closure shares the variables it captures with the function
+	// defining them, so assignments on either side show on the other.
+	tests := []vmTestCase{
+		{`let g = fn() { let x = 1; let f = fn() { x }; x = 2; f() }; g()`, 2},
+		{`let k = fn() { let c = 0; let inc = fn() { c = c + 1 }; inc(); c }; k()`, 1},
+		{`let counter = fn() { let n = 0; fn() { n = n + 1; n } }; let a = counter(); a(); a(); a() + counter()()`, 4},
+		{`let f = fn() { let v = 1; let mid = fn() { let inner = fn() { v = v * 10 }; inner(); v }; mid() + v }; f()`, 20},
+		{`let fs = []; for (let i = 0; i < 3; i = i + 1) { fs = push(fs, fn() { i = i + 10; i }); }; fs[0]() + fs[0]() + fs[1]()`, 41},
+		{`let f = fn(n) { let acc = 0; for (x in range(n)) { let add = fn() { acc = acc + x }; add(); }; acc }; f(5)`, 10},
+	}
+	runVmTests(t, tests)
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{`len("")`, 0},
@@ -168,8 +241,361 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`rest([])`, Null},
 		{`push([], 1)`, []int{1}},
 		{`push(1, 1)`, &object.Error{Message: "argument to 'push' must be ARRAY, got INTEGER"}},
+		{`delete({1: 2}, 1)`, map[object.Hashable]int64{}},
+		{`delete(1, 1)`, &object.Error{Message: "argument to 'delete' must be HASH, got INTEGER"}},
+		{`delete({}, [])`, &object.Error{Message: "mutable ARRAY cannot be a hash key; freeze it or use a tuple"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 4, true: 5}`, "{b: 1, a: 2, 3: 4, true: 5}"},
+		{`{"a": 1, "b": 2, "a": 3}`, "{a: 3, b: 2}"},
+		{`delete({"a": 1, "b": 2, "c": 3}, "b")`, "{a: 1, c: 3}"},
+		{`delete({"a": 1}, "x")`, "{a: 1}"},
+		{`let h = {"a": 1}; delete(h, "a"); h`, "{a: 1}"},
+	}
+	for _, tt := range tests {
+		vm := NewVM(compileProgram(t, tt.input))
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong hash for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`(1, 2)`, "(1, 2)"},
+		{`(1,)`, "(1,)"},
+		{`(1, "a")[1]`, "a"},
+		{`(1, 2)[2]`, "null"},
+		{`len((1, 2, 3))`, "3"},
+		{`(1, (2, 3)) == (1, (2, 3))`, "true"},
+		{`{(1, 2): "a", (2, 1): "b"}[(2, 1)]`, "b"},
+		{`{(1, 2): "a", (1, 2): "b"}`, "{(1, 2): b}"},
+		{`{(1, (2, "x")): 1}[(1, (2, "x"))]`, "1"},
+		{`{freeze([1, 2]): 1}[freeze([1, 2])]`, "1"},
+		{`let k = freeze([[1], (2, [3])]); {k: "deep"}[k]`, "deep"},
+		{`delete({(1, 2): 1, (3, 4): 2}, (1, 2))`, "{(3, 4): 2}"},
+		{`{[1, 2]: 1}`, "mutable ARRAY cannot be a hash key; freeze it or use a tuple"},
+		{`{"a": 1}[[1]]`, "mutable ARRAY cannot be a hash key; freeze it or use a tuple"},
+		{`{(1, [2]): 1}`, "mutable ARRAY cannot be a hash key; freeze it or use a tuple"},
+		{`{({},): 1}`, "mutable HASH cannot be a hash key"},
+		{`freeze({})`, "cannot freeze HASH"},
+		{`{(1, fn() {}): 1}`, "unusable as hash key: CLOSURE"},
+	}
+	for _, tt := range tests {
+		vm := NewVM(compileProgram(t, tt.input))
+		if err := vm.Run(); err != nil {
+			if err.Error() != tt.expected {
+				t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, err)
+			}
+			continue
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestBuiltinCallbacks(t *testing.T) {
+	tests := []vmTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`map([], fn(x) { x })`, []int{}},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, []int{3, 4}},
+		{`let inc = fn(x) { x + 1 }; map(map([1, 2], inc), inc)`, []int{3, 4}},
+		{`map([[1], [2, 3]], len)`, []int{1, 2}},
+		{`map([1, 2], fn(x) { map([x], fn(y) { x + y })[0] })`, []int{2, 4}},
+		{`let base = 10; map([1], fn(x) { x + base })`, []int{11}},
+		{`map([1], fn(x) { x + true })`,
+			&object.Error{Message: "type mismatch: INTEGER + BOOLEAN"}},
+		{`map([1], fn(x, y) { x })`,
+			&object.Error{Message: "wrong number of arguments: want=2, got=1"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []vmTestCase{
+		{`let r = 0; try { throw 1; } catch (e) { r = e["value"]; }; r`, 1},
+		{`let r = ""; try { throw "boom"; } catch (e) { r = e["message"]; }; r`, "boom"},
+		{`let r = ""; try { 1 + true; } catch (e) { r = e["kind"]; }; r`, "runtime"},
+		{`let r = ""; try { len(1); } catch (e) { r = e["kind"] + ": " + e["message"]; }; r`,
+			"builtin: argument to 'len' not supported, got INTEGER"},
+		{`let r = ""; try { [1][true]; } catch (e) { r = e["kind"]; }; r`, "runtime"},
+		{`let r = 0; try { throw 1; } catch { r = 2; }; r`, 2},
+		{`let r = 0; try { r = 1; } catch (e) { r = 2; }; r`, 1},
+		{`let r = 0; try { throw 1; } catch (e) { r = 1; } finally { r = r + 10; }; r`, 11},
+		{`let r = 0; try { r = 1; } finally { r = r + 10; }; r`, 11},
+		{`let r = 0; try { try { throw 1; } finally { r = 5; } } catch (e) { r = r + e["value"]; }; r`, 6},
+		{`let r = 0; try { try { throw 1; } catch (e) { throw 2; } finally { r = 5; } } catch (e) { r = r + e["value"]; }; r`, 7},
+		{`let r = ""; try { try { throw 1; } catch (e) { throw e; } } catch (e) { r = e["kind"]; }; r`, "throw"},
+		{`let f = fn() { throw "boom"; }; let g = fn() { f() + 1 }; let r = ""; try { g(); } catch (e) { r = e["message"]; }; r`, "boom"},
+		{`let f = fn() { throw "boom"; }; let g = fn() { f() + 1 }; let r = 0; try { g(); } catch (e) { r = e["trace"]; }; r[0] + r[1] + r[2]`, "fg<main>"},
+		{`let r = 0; try { map([1], fn(x) { x + true }); } catch (e) { r = e["kind"] + e["trace"][0]; }; r`, "runtime<anonymous>"},
+		{`let f = fn(a) { let b = 2; try { a + [1, 2, a + true]; } catch (e) { b = 3; }; a + b }; f(1)`, 4},
+		{`let f = fn(x) { try { if (x > 0) { throw x; } } catch (e) { return e["value"] * 2; }; 0 }; f(1) + f(0)`, 2},
+		{`let r = 0; [1, if (true) { try { throw 2; } catch (e) { r = e["value"]; }; r }][1]`, 2},
+		{`let r = 0; let f = fn() { try { return 1; } finally { r = 2; } }; f() + r`, 3},
+		{`let r = 0; let f = fn() { try { return 1; } catch (e) { r = 5; } finally { throw 2; } }; try { f(); } catch (e) { r = r + e["value"]; }; r`, 2},
+		{`let f = fn() { if (true) { try { 1 } finally { 2 } } }; f()`, Null},
+		{`throw "boom";`, &object.Error{Message: "boom"}},
+		{`let f = fn() { try { throw 1; } finally { 2; } }; f()`, &object.Error{Message: "1"}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestErrorValues(t *testing.T) {
+	tests := []vmTestCase{
+		{`is_error(error("x"))`, true},
+		{`is_error(1)`, false},
+		{`error("x")["message"]`, "x"},
+		{`error("x")["kind"]`, "error"},
+		{`let e = error("x"); 1`, 1},
+		{`error(1)`, &object.Error{Message: "argument to 'error' must be STRING, got INTEGER"}},
+		{`let f = fn(x) { if (x > 0) { return error("bad"); }; x }; let g = fn(x) { let v = f(x)?; v + 1 }; g(0)`, 1},
+		{`let f = fn(x) { if (x > 0) { return error("bad"); }; x }; let g = fn(x) { let v = f(x)?; v + 1 }; g(1)["message"]`, "bad"},
+		{`let f = fn(x) { if (x > 0) { return error("bad"); }; x }; let g = fn(x) { 1 + f(x)? * 2 }; g(0)`, 1},
+		{`let f = fn(x) { if (x > 0) { return error("bad"); }; x }; let g = fn(x) { 1 + f(x)? * 2 }; is_error(g(1))`, true},
+		{`let f = fn() { [1, 2]?[1] }; f()`, 2},
+		{`let f = fn(e) { map([1, 2], fn(x) { e? }) }; is_error(f(error("x"))[1])`, true},
+		{`let r = 0; let f = fn() { try { error("e")?; r = 5; } finally { r = r + 1; } }; f(); r`, 1},
+		{`let r = ""; try { throw error("bad"); } catch (e) { r = e["kind"] + e["message"]; }; r`, "errorbad"},
+		{`let r = false; try { 1 + true; } catch (e) { r = is_error(e); }; r`, true},
+		{`error("top")?; 5`, &object.ErrorValue{Message: "top"}},
+		{`let f = fn() { 1 }; f()?; 5`, 5},
+	}
+	runVmTests(t, tests)
+}
+
+func TestAbortIsNotCatchable(t *testing.T) {
+	bytecode := compileProgram(t, `let r = 0; try { for (;true;) {} } catch (e) { r = 1; }; r`)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := NewVM(bytecode).RunContext(ctx); !errors.Is(err, object.ErrDeadlineExceeded) {
+		t.Fatalf("wrong vm error: want=%q, got=%v", object.ErrDeadlineExceeded, err)
+	}
+
+	bytecode = compileProgram(t, `let r = 0; try { for (;true;) {} } catch (e) { r = 1; }; r`)
+	vm := NewVMWithOptions(bytecode, Options{GasLimit: 1000})
+	if err := vm.Run(); !errors.Is(err, object.ErrOutOfGas) {
+		t.Fatalf("wrong vm error: want=%q, got=%v", object.ErrOutOfGas, err)
+	}
+}
+
+func TestCallFromHost(t *testing.T) {
+	program := parse(`let add = fn(a, b) { a + b }; add;`)
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewVM(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	add := vm.LastPoppedStackElem()
+
+	result, err := vm.Call(add, &object.Integer{Value: 1}, &object.Integer{Value: 2})
+	if err != nil {
+		t.Fatalf("vm call error: %s", err)
+	}
+	testExpectedObject(t, 3, result)
+
+	sp := vm.sp
+	if _, err := vm.Call(add, &object.Integer{Value: 1}); err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+	if vm.sp != sp || vm.framesIndex != 1 {
+		t.Fatalf("vm state not restored. sp=%d (want %d), framesIndex=%d",
+			vm.sp, sp, vm.framesIndex)
+	}
+}
+
+// countdown is a host object a for-in loop can iterate, yielding n down
+// to 1.
+type countdown struct{ n int64 }
+
+func (c *countdown) Type() object.ObjectType { return "COUNTDOWN" }
+func (c *countdown) Inspect() string         { return "countdown" }
+func (c *countdown) Iterate() object.Iterator {
+	return &countdownIterator{n: c.n}
+}
+
+type countdownIterator struct{ n int64 }
+
+func (it *countdownIterator) Next() (object.Object, object.Object, bool) {
+	if it.n == 0 {
+		return nil, nil, false
+	}
+	it.n--
+	return nil, &object.Integer{Value: it.n + 1}, true
+}
+
+func TestIterateHostObject(t *testing.T) {
+	program := parse(`fn(xs) { let s = []; for (k, v in xs) { s = push(s, [k, v]) }; s }`)
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewVM(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	result, err := vm.Call(vm.LastPoppedStackElem(), &countdown{n: 2})
+	if err != nil {
+		t.Fatalf("vm call error: %s", err)
+	}
+	if got := result.Inspect(); got != "[[null, 2], [null, 1]]" {
+		t.Errorf("wrong result. got=%s", got)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected error
+	}{
+		{`for (;true;) {}`, object.ErrDeadlineExceeded},
+		{`let a = 0; for (let i = 0; i < 10; i = i + 1) { a = a + i }; a`, nil},
+		{`let f = fn() { for (;true;) {} }; f()`, object.ErrDeadlineExceeded},
+		{`map([1], fn(x) { for (;true;) {} })`, object.ErrDeadlineExceeded},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.NewCompiler()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewVM(comp.Bytecode())
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := vm.RunContext(ctx)
+		cancel()
+		if tt.expected == nil {
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.expected) {
+			t.Fatalf("wrong VM error: want=%q, got=%v", tt.expected, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	vm := NewVM(compileProgram(t, `let f = fn() { 1 }; f()`))
+	if err := vm.RunContext(ctx); !errors.Is(err, object.ErrCanceled) {
+		t.Fatalf("wrong VM error: want=%q, got=%v", object.ErrCanceled, err)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 0; for (let i = 0; i < 5; i = i + 1) { a = a + i }; a", 10},
+		{"let a = 1; a = a + 1; a", 2},
+		{"let a = 1; a = 5", 5},
+		{"for (let i = 0; i < 5; i = i + 1) { i }", Null},
+		{"let f = fn() { let a = 0; for (let i = 0; i < 3; i = i + 1) { a = a + 2 }; a }; f()", 6},
+		{"let f = fn() { for (let i = 0; true; i = i + 1) { if (i > 3) { return i; } } }; f()", 4},
+		{"let i = 0; for (; i < 3;) { i = i + 1 }; i", 3},
+	}
+	runVmTests(t, tests)
+}
+
+func TestForInLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let s = 0; for (x in [1, 2, 3]) { s = s + x }; s", 6},
+		{"let s = 0; for (i, x in [10, 20, 30]) { s = s + i * x }; s", 80},
+		{"let s = 0; for (i, x in (5, 6)) { s = s + i + x }; s", 12},
+		{`let s = ""; for (k in {"a": 1, "b": 2}) { s = s + k }; s`, "ab"},
+		{`let s = 0; for (k, v in {"a": 1, "b": 2}) { s = s + v }; s`, 3},
+		{`let s = ""; for (c in "héllo") { s = c + s }; s`, "olléh"},
+		{`let n = 0; for (i, c in "日本") { n = n + i }; n`, 1},
+		{"let s = 0; for (i in range(0, 10, 2)) { s = s + i }; s", 20},
+		{"let s = []; for (i in range(3, 0, -1)) { s = push(s, i) }; s", []int{3, 2, 1}},
+		{"let s = []; for (i in range(3)) { s = push(s, i) }; s", []int{0, 1, 2}},
+		{"let s = []; for (i in range(9223372036854775806, 9223372036854775807)) { s = push(s, i) }; len(s)", 1},
+		{"for (x in []) { x }", Null},
+		{"for (x in [1]) { x }", Null},
+		{"let s = 0; for (x in [1, 2]) { for (y in [10, 20]) { s = s + x * y } }; s", 90},
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x } } }; f([1, 5, 7])", 5},
+		{"let f = fn(xs) { let s = 0; for (x in xs) { s = s + x }; s }; f([1, 2]) + f([3])", 6},
+		{"let fs = []; for (x in [1, 2]) { fs = push(fs, fn() { x }) }; fs[0]() * 10 + fs[1]()", 12},
+		{"let x = 5; for (x in [1]) { x }; x", 5},
+		{"let s = 0; for (x in [1, 2, 3]) { try { if (x == 2) { throw x } s = s + x } catch (e) { s = s + 10 } }; s", 14},
+		{"let s = 0; for (x in [1, 2]) { let y = x * 2; s = s + y }; s", 6},
+		{"for (x in 1) { x }", &object.Error{Message: "INTEGER is not iterable"}},
+		{"range(1, 2, 0)", &object.Error{Message: "range step cannot be zero"}},
+	}
+	runVmTests(t, tests)
+}
+
+// wideProgram returns a function of n parameters, with n locals and a
+// closure capturing all of them, called with 1, 2, ... n; so every
+// operand exceeds one byte when n > 256. The result is the sum of
+// those numbers.
+func wideProgram(n int) string {
+	// Identifiers are letters only.
+	name := func(prefix string, i int) string {
+		return prefix + string(rune('a'+i/26/26%26)) + string(rune('a'+i/26%26)) + string(rune('a'+i%26))
+	}
+	var params, locals, sum, args []string
+	for i := 0; i < n; i++ {
+		params = append(params, name("p", i))
+		locals = append(locals, fmt.Sprintf("let %s = %s;", name("l", i), name("p", i)))
+		sum = append(sum, name("l", i))
+		args = append(args, fmt.Sprint(i+1))
+	}
+	return fmt.Sprintf("let f = fn(%s) { %s fn() { %s } }; f(%s)()",
+		strings.Join(params, ", "), strings.Join(locals, " "),
+		strings.Join(sum, " + "), strings.Join(args, ", "))
+}
+
+func TestWideOperands(t *testing.T) {
+	tests := []vmTestCase{
+		{wideProgram(3), 6},
+		{wideProgram(300), 300 * 301 / 2},
+	}
+	runVmTests(t, tests)
+}
+
+func TestBlockScopes(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; if (true) { let x = 2; }; x", 1},
+		{"let x = 1; { let x = 2; x = 3; }; x", 1},
+		{"let x = 1; { x = 2; }; x", 2},
+		{"let x = 1; { let x = x + 1; x }", 2},
+		{"let x = 1; { let y = 2; { let x = y + 10; x } }", 12},
+		{"let f = fn(a) { let a = a * 2; a }; f(3)", 6},
+		{"let f = fn() { let r = 0; { let a = 1; r = r + a; }; { let b = 2; r = r + b; }; r }; f()", 3},
+		{"let e = 5; try { throw 1; } catch (e) { }; e", 5},
+		{"let a = 0; for (let a = 10; a < 12; a = a + 1) { }; a", 0},
+		{"let fs = []; for (let i = 0; i < 3; i = i + 1) { fs = push(fs, fn() { i }); }; fs[0]() + fs[1]() * 10 + fs[2]() * 100", 210},
+		{"let f = fn() { let fs = []; for (let i = 0; i < 3; i = i + 1) { let j = i * 2; fs = push(fs, fn() { j }); }; fs[1]() + fs[2]() }; f()", 6},
 	}
 	runVmTests(t, tests)
+
+	for _, input := range []string{
+		"if (true) { let y = 1; }; y",
+		"for (let i = 0; i < 1; i = i + 1) { }; i",
+		"{ let y = 1; }; y",
+		"try { throw 1; } catch (e) { }; e",
+	} {
+		err := compiler.NewCompiler().Compile(parse(input))
+		if err == nil || !strings.HasPrefix(err.Error(), "undefined variable") {
+			t.Errorf("%q: expected an undefined variable error, got %v", input, err)
+		}
+	}
 }
 
 func TestCallingFunctionsWithWrongArguments(t *testing.T) {
@@ -375,19 +801,19 @@ func TestIndexExpressions(t *testing.T) {
 func TestHashLiterals(t *testing.T) {
 	tests := []vmTestCase{
 		{
-			"{}", map[object.HashKey]int64{},
+			"{}", map[object.Hashable]int64{},
 		},
 		{
 			"{1: 2, 2: 3}",
-			map[object.HashKey]int64{
-				(&object.Integer{Value: 1}).HashKey(): 2,
-				(&object.Integer{Value: 2}).HashKey(): 3,
+			map[object.Hashable]int64{
+				&object.Integer{Value: 1}: 2,
+				&object.Integer{Value: 2}: 3,
 			},
 		},
 		{
-			"{1 + 1: 2 * 2, 3+ 3: 4 * 4}", map[object.HashKey]int64{
-				(&object.Integer{Value: 2}).HashKey(): 4,
-				(&object.Integer{Value: 6}).HashKey(): 16,
+			"{1 + 1: 2 * 2, 3+ 3: 4 * 4}", map[object.Hashable]int64{
+				&object.Integer{Value: 2}: 4,
+				&object.Integer{Value: 6}: 16,
 			},
 		},
 	}
@@ -421,6 +847,33 @@ func TestGlobalLetStatements(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestGlobalsStoreGrowsAcrossVMs(t *testing.T) {
+	symbolTable := compiler.NewSymbolTable()
+	var constants []object.Object
+	var globals []object.Object
+	var last object.Object
+	for _, line := range []string{"let a = 1; let b = 2;", "let c = 3;", "a + b + c"} {
+		comp := compiler.NewCompilerWithState(symbolTable, constants)
+		if err := comp.Compile(parse(line)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+		vm := NewVMWithGlobalsStore(bytecode, globals)
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		globals = vm.GlobalsStore()
+		last = vm.LastPoppedStackElem()
+	}
+	if err := testIntegerObject(6, last); err != nil {
+		t.Errorf("testIntegerObject failed: %s", err)
+	}
+	if len(globals) >= GlobalsSize {
+		t.Errorf("globals store did not start small. len=%d", len(globals))
+	}
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -454,6 +907,22 @@ func TestBooleanExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestValueEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a" == "a"`, true}, {`"a" + "b" == "ab"`, true}, {`"a" != "b"`, true},
+		{`let s = "a"; s + "" == s`, true},
+		{`"abc" < "abd"`, true}, {`"b" > "abc"`, true}, {`"a" < "a"`, false}, {`"" < "a"`, true},
+		{"[1, [2, 3]] == [1, [2, 3]]", true}, {"[1, 2] == [1, 2, 3]", false},
+		{"[1, 2] != [2, 1]", true}, {"push([1], 2) == [1, 2]", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false}, {`{"a": 1} == {"b": 1}`, false},
+		{"null == null", true}, {"null == false", false}, {"1 == true", false},
+		{`1 == "1"`, false}, {"[] == {}", false},
+		{"let f = fn() {}; f == f", true}, {"fn() {} == fn() {}", false},
+	}
+	runVmTests(t, tests)
+}
+
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []vmTestCase{
 		{"1", 1},
@@ -475,22 +944,35 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+// runVmTests runs every test case both as compiled and with the optimizer
+// enabled.
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
-	for i, tt := range tests {
-		program := parse(tt.input)
-		comp := compiler.NewCompiler()
-		err := comp.Compile(program)
-		if err != nil {
-			t.Fatalf("compiler error: %s, test #%d", err, i)
-		}
-		vm := NewVM(comp.Bytecode())
-		err = vm.Run()
-		if err != nil {
-			t.Fatalf("vm error: %s", err)
+	for _, opts := range []compiler.Options{{}, {Optimize: true}} {
+		for i, tt := range tests {
+			program := parse(tt.input)
+			comp := compiler.NewCompilerWithOptions(opts)
+			err := comp.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s, test #%d", err, i)
+			}
+			vm := NewVM(comp.Bytecode())
+			err = vm.Run()
+			// Errors are raised, so an expected error is the uncaught one.
+			if _, ok := tt.expected.(*object.Error); ok {
+				errObj, ok := err.(*object.Error)
+				if !ok {
+					t.Fatalf("no uncaught error: got=%T (%v), test #%d %+v", err, err, i, opts)
+				}
+				testExpectedObject(t, tt.expected, errObj)
+				continue
+			}
+			if err != nil {
+				t.Fatalf("vm error: %s, test #%d %+v", err, i, opts)
+			}
+			stackElem := vm.LastPoppedStackElem()
+			testExpectedObject(t, tt.expected, stackElem)
 		}
-		stackElem := vm.LastPoppedStackElem()
-		testExpectedObject(t, tt.expected, stackElem)
 	}
 }
 func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
@@ -523,23 +1005,23 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
-	case map[object.HashKey]int64:
+	case map[object.Hashable]int64:
 		hash, ok := actual.(*object.Hash)
 		if !ok {
 			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
 			return
 		}
-		if len(hash.Pairs) != len(expected) {
+		if hash.Len() != len(expected) {
 			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
-				len(expected), len(hash.Pairs))
+				len(expected), hash.Len())
 			return
 		}
 		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+			value, ok := hash.Get(expectedKey)
 			if !ok {
 				t.Errorf("no pair for given key in Pairs")
 			}
-			if err := testIntegerObject(expectedValue, pair.Value); err != nil {
+			if err := testIntegerObject(expectedValue, value); err != nil {
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
@@ -547,6 +1029,16 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if actual != Null {
 			t.Errorf("object is not Null: %T (%+v)", actual, actual)
 		}
+	case *object.ErrorValue:
+		errValue, ok := actual.(*object.ErrorValue)
+		if !ok {
+			t.Errorf("object is not ErrorValue: %T (%+v)", actual, actual)
+			return
+		}
+		if errValue.Message != expected.Message {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				expected.Message, errValue.Message)
+		}
 	case *object.Error:
 		errObj, ok := actual.(*object.Error)
 		if !ok {
@@ -560,6 +1052,15 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 	}
 }
 
+func compileProgram(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+	comp := compiler.NewCompiler()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
 func parse(input string) *ast.Program {
 	l := lexer.NewLexer(input)
 	p := parser.NewParser(l)